@@ -0,0 +1,321 @@
+package admin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Junjiayy/hamal/internal/core/nodes"
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/tools"
+	"github.com/go-zookeeper/zk"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// resource zookeeper 中可以被管理的配置节点
+	resource struct {
+		path     string
+		validate func(data []byte) error
+	}
+
+	// command 子命令
+	command struct {
+		usage string
+		run   func(a *admin, fs *flag.FlagSet) error
+	}
+
+	admin struct {
+		conf   *configs.SyncConfig
+		zkConn *zk.Conn
+		in     *bufio.Reader
+		out    io.Writer
+		yes    bool
+		dryRun bool
+	}
+
+	// followerTask follower 节点分配到的任务，和 leader 广播的数据格式一致
+	followerTask struct {
+		Readers map[string]json.RawMessage `json:"readers"`
+	}
+)
+
+var _resources = map[string]resource{
+	"rules":   {path: nodes.RulesPath, validate: validateRules},
+	"writers": {path: nodes.WriterConfigPath, validate: validateWriters},
+	"readers": {path: nodes.ReadersPath, validate: validateReaders},
+}
+
+var _commands = make(map[string]map[string]command) // 子命令 映射表 group:action
+
+func init() {
+	for name, res := range _resources {
+		setCommand(name, "push", command{
+			usage: fmt.Sprintf("validate json file and publish it to %s", res.path),
+			run:   pushFunc(res),
+		})
+		setCommand(name, "get", command{
+			usage: fmt.Sprintf("print current data of %s", res.path),
+			run:   getFunc(res),
+		})
+	}
+	setCommand("cluster", "status", command{
+		usage: "print leader, followers and reader assignments", run: clusterStatus,
+	})
+	setCommand("replay", "", command{
+		usage: "replay dead letters through the sync handler", run: replay,
+	})
+}
+
+func setCommand(group, action string, cmd command) {
+	if _, ok := _commands[group]; !ok {
+		_commands[group] = make(map[string]command)
+	}
+	_commands[group][action] = cmd
+}
+
+// IsCommand 判断是否为管理命令
+func IsCommand(group string) bool {
+	_, ok := _commands[group]
+	return ok
+}
+
+// Run 执行管理命令 args 格式为: group [action] [flags] [file]
+func Run(args []string, in io.Reader, out io.Writer) error {
+	if len(args) < 1 {
+		return errors.New(usage())
+	}
+	// 没有 action 的命令，例如: replay
+	cmd, ok := _commands[args[0]][""]
+	name, flagArgs := args[0], args[1:]
+	if !ok {
+		if len(args) < 2 {
+			return errors.New(usage())
+		}
+		if cmd, ok = _commands[args[0]][args[1]]; !ok {
+			return errors.New(usage())
+		}
+		name, flagArgs = args[0]+" "+args[1], args[2:]
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(out)
+	filePath := fs.String("f", "config.yaml", "Specify the config file")
+	a := &admin{in: bufio.NewReader(in), out: out}
+	fs.BoolVar(&a.yes, "y", false, "Skip confirmation")
+	fs.BoolVar(&a.dryRun, "dry-run", false, "Only print the diff")
+	if err := fs.Parse(flagArgs); err != nil {
+		return err
+	}
+
+	var err error
+	if a.conf, err = loadConfig(*filePath); err != nil {
+		return err
+	}
+	defer a.close()
+
+	return cmd.run(a, fs)
+}
+
+func usage() string {
+	lines := []string{"usage: hamal <group> [action] [-f config.yaml] [-y] [-dry-run] [file]"}
+	for group, actions := range _commands {
+		for action, cmd := range actions {
+			name := strings.TrimSpace(group + " " + action)
+			lines = append(lines, fmt.Sprintf("  %s\t%s", name, cmd.usage))
+		}
+	}
+	sort.Strings(lines[1:])
+
+	return strings.Join(lines, "\n")
+}
+
+func loadConfig(filePath string) (*configs.SyncConfig, error) {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var conf configs.SyncConfig
+	if err := tools.UnmarshalYamlAndBuildDefault(content, &conf); err != nil {
+		return nil, err
+	}
+
+	return &conf, nil
+}
+
+// pushFunc 校验配置文件，展示和当前节点数据的差异，确认后按版本号写入节点
+// 写入时节点版本号发生变化说明被其他人修改过，直接失败，防止互相覆盖
+func pushFunc(res resource) func(a *admin, fs *flag.FlagSet) error {
+	return func(a *admin, fs *flag.FlagSet) error {
+		if fs.NArg() != 1 {
+			return errors.Errorf("push requires exactly one json file")
+		}
+		content, err := ioutil.ReadFile(fs.Arg(0))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := res.validate(content); err != nil {
+			return errors.WithMessage(err, "validate failed")
+		}
+		buf := new(bytes.Buffer)
+		if err := json.Compact(buf, content); err != nil {
+			return errors.WithStack(err)
+		}
+		content = buf.Bytes()
+
+		current, version, err := a.getNodeData(res.path)
+		if err != nil {
+			return err
+		}
+		diff, err := diffJSON(current, content)
+		if err != nil {
+			return errors.WithMessagef(err, "current data of %s is invalid json", res.path)
+		}
+		if diff == "" {
+			_, _ = fmt.Fprintf(a.out, "%s no changes\n", res.path)
+			return nil
+		}
+		_, _ = fmt.Fprintf(a.out, "%s (version %d)\n%s", res.path, version, diff)
+		if a.dryRun || !a.confirm() {
+			return nil
+		}
+
+		if version < 0 {
+			_, err = a.zkConn.Create(res.path, content, 0, zk.WorldACL(zk.PermAll))
+		} else {
+			_, err = a.zkConn.Set(res.path, content, version)
+		}
+		if errors.Is(err, zk.ErrBadVersion) || errors.Is(err, zk.ErrNodeExists) {
+			return errors.Errorf("%s was modified by others since version %d, please retry", res.path, version)
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+		_, _ = fmt.Fprintf(a.out, "%s updated\n", res.path)
+
+		return nil
+	}
+}
+
+// getFunc 打印节点当前数据
+func getFunc(res resource) func(a *admin, fs *flag.FlagSet) error {
+	return func(a *admin, fs *flag.FlagSet) error {
+		current, version, err := a.getNodeData(res.path)
+		if err != nil {
+			return err
+		}
+		content, err := prettyJSON(current)
+		if err != nil {
+			return errors.WithMessagef(err, "current data of %s is invalid json", res.path)
+		}
+		_, _ = fmt.Fprintf(a.out, "# %s (version %d)\n%s\n", res.path, version, content)
+
+		return nil
+	}
+}
+
+// clusterStatus 打印 leader 节点、follower 节点 和 读取器分配情况
+func clusterStatus(a *admin, _ *flag.FlagSet) error {
+	zkConn, err := a.getZkConn()
+	if err != nil {
+		return err
+	}
+	leaderVal, _, err := zkConn.Get(nodes.LeaderPath)
+	if errors.Is(err, zk.ErrNoNode) {
+		leaderVal = []byte("<none>")
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	_, _ = fmt.Fprintf(a.out, "leader: %s\n", leaderVal)
+
+	followerPaths, _, err := zkConn.Children(nodes.FollowerRootPath)
+	if err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return errors.WithStack(err)
+	}
+	tasksData, _, err := a.getNodeData(nodes.FollowerRootPath)
+	if err != nil {
+		return err
+	}
+	var tasks map[string]followerTask
+	if len(tasksData) > 0 {
+		if err := json.Unmarshal(tasksData, &tasks); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	sort.Strings(followerPaths)
+	_, _ = fmt.Fprintf(a.out, "followers: %d\n", len(followerPaths))
+	for _, followerPath := range followerPaths {
+		uniqueIds := make([]string, 0, len(tasks[followerPath].Readers))
+		for uniqueId := range tasks[followerPath].Readers {
+			uniqueIds = append(uniqueIds, uniqueId)
+		}
+		sort.Strings(uniqueIds)
+		_, _ = fmt.Fprintf(a.out, "  %s readers: %d %s\n", followerPath, len(uniqueIds),
+			strings.Join(uniqueIds, ","))
+	}
+
+	return nil
+}
+
+// getZkConn 获取 zookeeper 连接，第一次调用时才建立连接
+func (a *admin) getZkConn() (*zk.Conn, error) {
+	if a.zkConn == nil {
+		zkConn, _, err := zk.Connect(a.conf.ZookeeperConfig.Hosts, time.Second*5, zk.WithLogInfo(false))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		a.zkConn = zkConn
+	}
+
+	return a.zkConn, nil
+}
+
+func (a *admin) close() {
+	if a.zkConn != nil {
+		a.zkConn.Close()
+	}
+}
+
+// getNodeData 获取节点数据 和 版本号，节点不存在时版本号为 -1
+func (a *admin) getNodeData(path string) ([]byte, int32, error) {
+	zkConn, err := a.getZkConn()
+	if err != nil {
+		return nil, 0, err
+	}
+	data, stat, err := zkConn.Get(path)
+	if errors.Is(err, zk.ErrNoNode) {
+		return nil, -1, nil
+	} else if err != nil {
+		return nil, 0, errors.WithStack(err)
+	}
+
+	return data, stat.Version, nil
+}
+
+// confirm 等待用户确认 -y 参数跳过确认
+func (a *admin) confirm() bool {
+	if a.yes {
+		return true
+	}
+	_, _ = fmt.Fprint(a.out, "apply changes? [y/N]: ")
+	answer, _ := a.in.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// Main 管理命令入口，出错时以非零状态码退出
+func Main(args []string) {
+	if err := Run(args, os.Stdin, os.Stdout); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,84 @@
+package admin
+
+import (
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		validate func([]byte) error
+		data     string
+		valid    bool
+	}{
+		{"rules", validateRules, `{"test_orders":[{"database":"test","table":"orders",
+			"target":"mysql:test.sync_tests.orders","sync_type":"copy","primary_key":"id","columns":{"id":"id"}}]}`, true},
+		{"rules key mismatch", validateRules, `{"orders":[{"database":"test","table":"orders",
+			"target":"mysql:test.sync_tests.orders","sync_type":"copy","primary_key":"id","columns":{"id":"id"}}]}`, false},
+		{"rules target format", validateRules, `{"test_orders":[{"database":"test","table":"orders",
+			"target":"test.orders","sync_type":"copy","primary_key":"id","columns":{"id":"id"}}]}`, false},
+		{"rules target type", validateRules, `{"test_orders":[{"database":"test","table":"orders",
+			"target":"oracle:test.orders","sync_type":"copy","primary_key":"id","columns":{"id":"id"}}]}`, false},
+		{"rules sync type", validateRules, `{"test_orders":[{"database":"test","table":"orders",
+			"target":"es:test.orders","sync_type":"merge","primary_key":"id","columns":{"id":"id"}}]}`, false},
+		{"writers", validateWriters, `{"mysql":{"test":{"name":"test","type":"mysql","host":"127.0.0.1"}}}`, true},
+		{"writers name mismatch", validateWriters, `{"mysql":{"test":{"name":"other","type":"mysql"}}}`, false},
+		{"writers type", validateWriters, `{"oracle":{"test":{"name":"test","type":"oracle"}}}`, false},
+		{"readers", validateReaders, `[{"type":"web","config":{"listen":":8081","push_path":"/push"}}]`, true},
+		{"readers type", validateReaders, `[{"type":"ftp","config":{}}]`, false},
+		{"readers duplicated", validateReaders, `[{"type":"web","config":{"listen":":8081","push_path":"/push"}},
+			{"type":"web","config":{"listen":":8081","push_path":"/push"}}]`, false},
+	}
+
+	for _, c := range cases {
+		if err := c.validate([]byte(c.data)); (err == nil) != c.valid {
+			t.Fatalf("%s: valid should be %v, err: %v", c.name, c.valid, err)
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	diff, err := diffJSON([]byte(`{"b":1,"a":{"x":"1","y":"2"}}`), []byte(`{"a":{"y":"2","x":"1"},"b":1}`))
+	if err != nil || diff != "" {
+		t.Fatalf("same data should have no diff, diff: %q, err: %v", diff, err)
+	}
+
+	diff, err = diffJSON([]byte(`{"a":{"x":"1","y":"2"},"b":1}`), []byte(`{"a":{"x":"3","y":"2"},"b":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(diff, `-     "x": "1"`) || !strings.Contains(diff, `+     "x": "3"`) {
+		t.Fatalf("diff should contain changed lines: %s", diff)
+	}
+
+	// 节点不存在时全部为新增
+	diff, err = diffJSON(nil, []byte(`{"a":1}`))
+	if err != nil || strings.Count(diff, "+ ") != 3 {
+		t.Fatalf("diff with empty node failed, diff: %q, err: %v", diff, err)
+	}
+}
+
+func TestReplayDeadLetter(t *testing.T) {
+	// binlog params 为空时返回错误，不能在拼接错误信息时 panic
+	letter := &deadletters.DeadLetter{RuleKey: "test_orders", Target: "mysql:test.sync_tests.orders"}
+	err := replayDeadLetter(nil, nil, letter)
+	if err == nil || !strings.Contains(err.Error(), "test_orders") || !strings.Contains(err.Error(), letter.Target) {
+		t.Fatalf("empty binlog params should fail with rule key and target, err: %v", err)
+	}
+}
+
+func TestLoadSyncConfigs(t *testing.T) {
+	a := &admin{conf: &configs.SyncConfig{Mode: configs.ModeStandalone, Rules: map[string][]*types.SyncRule{
+		"test_orders": {{Database: "test", Table: "orders", PrimaryKey: "id", Columns: map[string]string{"id": "id"},
+			Target: "mysql:test.sync_tests.orders", SyncType: "merge"}},
+	}}}
+	if _, _, err := a.loadSyncConfigs(); err == nil {
+		t.Fatal("invalid rules should fail before replay")
+	} else if _, ok := err.(types.RulesError); !ok {
+		t.Fatalf("error should be RulesError: %v", err)
+	}
+}
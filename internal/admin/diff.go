@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+const diffContextLines = 2 // diff 输出时 变更行前后保留的行数
+
+// prettyJSON 格式化 json 数据，对象的 key 会被排序，方便比较差异
+func prettyJSON(data []byte) (string, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return "", nil
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// diffJSON 比较两份 json 数据的差异，没有差异时返回空字符串
+// 删除的行以 "- " 开头，新增的行以 "+ " 开头
+func diffJSON(old, new []byte) (string, error) {
+	oldContent, err := prettyJSON(old)
+	if err != nil {
+		return "", err
+	}
+	newContent, err := prettyJSON(new)
+	if err != nil {
+		return "", err
+	}
+	if oldContent == newContent {
+		return "", nil
+	}
+
+	return diffLines(splitLines(oldContent), splitLines(newContent)), nil
+}
+
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	return strings.Split(content, "\n")
+}
+
+// diffLines 基于最长公共子序列计算行差异，只输出变更行和上下文
+func diffLines(oldLines, newLines []string) string {
+	n, m := len(oldLines), len(newLines)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && oldLines[i] == newLines[j]:
+			lines = append(lines, "  "+oldLines[i])
+			i, j = i+1, j+1
+		case j < m && (i == n || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, "+ "+newLines[j])
+			j++
+		default:
+			lines = append(lines, "- "+oldLines[i])
+			i++
+		}
+	}
+
+	// 只保留变更行 和 变更行前后的上下文
+	keep := make([]bool, len(lines))
+	for index, line := range lines {
+		if line[0] == ' ' {
+			continue
+		}
+		for k := index - diffContextLines; k <= index+diffContextLines; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+
+	var builder strings.Builder
+	for index, line := range lines {
+		if !keep[index] {
+			if index > 0 && keep[index-1] {
+				builder.WriteString("  ...\n")
+			}
+			continue
+		}
+		builder.WriteString(line)
+		builder.WriteString("\n")
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Junjiayy/hamal/internal/core/handlers"
+	"github.com/Junjiayy/hamal/internal/core/nodes"
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// replay 把死信重新交给 handlers.Handler 执行
+// 重放失败时停止，失败的死信和之后的死信都保留在死信队列中
+func replay(a *admin, _ *flag.FlagSet) error {
+	if a.dryRun {
+		return errors.New("replay does not support -dry-run")
+	}
+	redisCli := redis.NewClient(&redis.Options{
+		Addr: a.conf.RedisConfig.Addr, DB: a.conf.RedisConfig.DB,
+		Password: a.conf.RedisConfig.Password,
+	})
+	defer redisCli.Close()
+
+	dlq, err := deadletters.NewQueue(&a.conf.DeadLetter, redisCli)
+	if err != nil {
+		return err
+	} else if dlq == nil {
+		return errors.New("dead letter queue is not configured")
+	}
+	defer dlq.Close()
+
+	dbConfigsByType, rules, err := a.loadSyncConfigs()
+	if err != nil {
+		return err
+	}
+	if !a.confirm() {
+		return nil
+	}
+
+	// 重放时不再写入死信队列，失败直接返回错误
+	h, err := handlers.NewHandler(redisCli, a.conf, nil)
+	if err != nil {
+		return err
+	}
+	defer h.Release()
+	if err := h.GetWriterPool().SetConfigs(dbConfigsByType); err != nil {
+		return err
+	}
+
+	var replayed int
+	err = dlq.Replay(context.Background(), func(letter *deadletters.DeadLetter) error {
+		if err := replayDeadLetter(h, rules, letter); err != nil {
+			return err
+		}
+		replayed++
+
+		return nil
+	})
+	_, _ = fmt.Fprintf(a.out, "replayed %d dead letters\n", replayed)
+
+	return err
+}
+
+// replayDeadLetter 重放单条死信，返回的错误包含死信的规则key、目标 和 事件id
+func replayDeadLetter(h *handlers.Handler, rules map[string][]*types.SyncRule, letter *deadletters.DeadLetter) error {
+	if letter.BinlogParams == nil {
+		return errors.Errorf("replay %s %s failed: dead letter binlog params is empty", letter.RuleKey, letter.Target)
+	}
+	if err := submitDeadLetter(h, rules, letter); err != nil {
+		return errors.WithMessagef(err, "replay %s %s event %s failed", letter.RuleKey,
+			letter.Target, letter.BinlogParams.EventId)
+	}
+
+	return nil
+}
+
+// submitDeadLetter 根据规则key 和 目标找到当前的同步规则，重新执行同步
+func submitDeadLetter(h *handlers.Handler, rules map[string][]*types.SyncRule, letter *deadletters.DeadLetter) error {
+	// 通配和正则规则根据来源表替换目标中的占位符后再比较
+	var rule *types.SyncRule
+	for _, r := range rules[letter.RuleKey] {
+		if r, ok := r.Match(letter.BinlogParams.Database, letter.BinlogParams.Table); ok &&
+			r.GetFullTarget() == letter.Target {
+			rule = r
+			break
+		}
+	}
+	if rule == nil {
+		return errors.New("rule not exists")
+	}
+
+	swg := types.NewSyncWaitGroup()
+	defer swg.Recycle()
+	params := types.NewSyncParams(swg, rule, letter.Data, letter.Old, letter.BinlogParams)
+	params.RealEventType, params.Nulls, params.OldNulls = letter.RealEventType, letter.Nulls, letter.OldNulls
+	if err := h.Submit(params); err != nil {
+		return err
+	}
+
+	swg.Wait()
+	if errArr := swg.Errors(); len(errArr) > 0 {
+		return errArr[0]
+	}
+
+	return nil
+}
+
+// loadSyncConfigs 获取数据源配置 和 校验后的同步规则
+// 单机模式从配置文件获取，集群模式从 zookeeper 获取
+func (a *admin) loadSyncConfigs() (map[string]map[string]datasources.DataSourceConfig,
+	map[string][]*types.SyncRule, error) {
+	var (
+		dbConfigsByType map[string]map[string]datasources.DataSourceConfig
+		rules           map[string][]*types.SyncRule
+		err             error
+	)
+	if a.conf.Mode == configs.ModeStandalone {
+		if dbConfigsByType, err = a.conf.GetDataSourceConfigsByType(); err != nil {
+			return nil, nil, err
+		}
+		rules = a.conf.Rules
+	} else {
+		for path, dest := range map[string]interface{}{
+			nodes.WriterConfigPath: &dbConfigsByType, nodes.RulesPath: &rules,
+		} {
+			data, _, err := a.getNodeData(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(data) == 0 {
+				return nil, nil, errors.Errorf("%s is empty", path)
+			}
+			if err := json.Unmarshal(data, dest); err != nil {
+				return nil, nil, errors.WithMessagef(err, "decode %s failed", path)
+			}
+		}
+	}
+
+	// 规则编译后才能匹配来源表，不合法时在确认重放之前返回 RulesError
+	if err := types.ValidateRules(rules); err != nil {
+		return nil, nil, err
+	}
+
+	return dbConfigsByType, rules, nil
+}
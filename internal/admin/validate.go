@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/core/readers"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+)
+
+// validateRules 校验同步规则，格式为 map[database_table][]types.SyncRule
+func validateRules(data []byte) error {
+	var rules map[string][]*types.SyncRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := types.ValidateRules(rules); err != nil {
+		return err
+	}
+
+	for ruleKey, ruleArr := range rules {
+		for i, rule := range ruleArr {
+			if key := rule.GetRuleKey(); key != ruleKey {
+				return errors.Errorf("rules[%s][%d] key should be %s", ruleKey, i, key)
+			}
+			if datasources.GetDataSourceConstructor(rule.TargetType) == nil {
+				return errors.Errorf("rules[%s][%d] unsupported target type: %s", ruleKey, i, rule.TargetType)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateWriters 校验写入器数据源配置，格式为 map[type]map[name]datasources.DataSourceConfig
+func validateWriters(data []byte) error {
+	var dbConfigsByType map[string]map[string]datasources.DataSourceConfig
+	if err := json.Unmarshal(data, &dbConfigsByType); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for dbType, dbConfigs := range dbConfigsByType {
+		if datasources.GetDataSourceConstructor(dbType) == nil {
+			return errors.Errorf("writers[%s] unsupported datasource type", dbType)
+		}
+		for name, config := range dbConfigs {
+			if config.Name != name {
+				return errors.Errorf("writers[%s][%s] name mismatch: %s", dbType, name, config.Name)
+			}
+			if config.Type != dbType {
+				return errors.Errorf("writers[%s][%s] type mismatch: %s", dbType, name, config.Type)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateReaders 校验读取器配置，格式为 []readers.ReaderConfigByType
+func validateReaders(data []byte) error {
+	var configs []readers.ReaderConfigByType
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return errors.WithStack(err)
+	}
+
+	uniqueIds := make(map[string]int, len(configs))
+	for i, config := range configs {
+		if readers.GetReaderConstructor(config.Type) == nil {
+			return errors.Errorf("readers[%d] unsupported reader type: %s", i, config.Type)
+		}
+		uniqueId := config.Config.GetUniqueId()
+		if index, ok := uniqueIds[uniqueId]; ok {
+			return errors.Errorf("readers[%d] duplicated with readers[%d], unique id: %s", i, index, uniqueId)
+		}
+		uniqueIds[uniqueId] = i
+	}
+
+	return nil
+}
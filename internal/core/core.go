@@ -4,48 +4,89 @@ import (
 	"context"
 	"github.com/Junjiayy/hamal/internal/core/nodes"
 	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
+	"github.com/Junjiayy/hamal/pkg/metrics"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-zookeeper/zk"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
 	"time"
 )
 
-type Core struct {
-	conf       *configs.SyncConfig
-	ctx        context.Context
-	cancelFunc context.CancelFunc
-	f          *nodes.Follower
-}
+type (
+	Core struct {
+		conf       *configs.SyncConfig
+		ctx        context.Context
+		cancelFunc context.CancelFunc
+		n          runnableNode
+		ms         *metrics.Server // 监控服务 未配置时为 nil
+	}
 
-func NewCore(conf *configs.SyncConfig) (*Core, error) {
-	zkConn, _, err := zk.Connect(conf.ZookeeperConfig.Hosts, time.Second*5)
-	if err != nil {
-		return nil, err
+	// runnableNode 运行节点 集群模式为 nodes.Follower 单机模式为 nodes.Standalone
+	runnableNode interface {
+		Run() error
+		Stop()
 	}
+)
+
+func NewCore(conf *configs.SyncConfig) (*Core, error) {
 	redisCli := redis.NewClient(&redis.Options{
 		Addr: conf.RedisConfig.Addr, DB: conf.RedisConfig.DB,
 		Password: conf.RedisConfig.Password,
 	})
 
+	dlq, err := deadletters.NewQueue(&conf.DeadLetter, redisCli)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancelFunc := context.WithCancel(context.Background())
-	f, err := nodes.NewFollowerNode(ctx, redisCli, zkConn, conf.PoolSize)
+	n, err := newRunnableNode(ctx, redisCli, conf, dlq)
 	if err != nil {
 		defer cancelFunc()
 		return nil, err
 	}
 
 	c := &Core{
-		conf: conf, ctx: ctx, cancelFunc: cancelFunc, f: f,
+		conf: conf, ctx: ctx, cancelFunc: cancelFunc, n: n,
+		ms: metrics.NewServer(&conf.Metrics),
 	}
 
 	return c, nil
 }
 
+// newRunnableNode 根据运行模式创建运行节点
+func newRunnableNode(ctx context.Context, redisCli *redis.Client, conf *configs.SyncConfig,
+	dlq deadletters.Queue) (runnableNode, error) {
+	switch conf.Mode {
+	case configs.ModeStandalone:
+		return nodes.NewStandaloneNode(ctx, redisCli, conf, dlq)
+	case configs.ModeCluster:
+		zkConn, _, err := zk.Connect(conf.ZookeeperConfig.Hosts, time.Second*5)
+		if err != nil {
+			return nil, err
+		}
+
+		return nodes.NewFollowerNode(ctx, redisCli, zkConn, conf, dlq)
+	default:
+		return nil, errors.Errorf("unsupported mode: %s", conf.Mode)
+	}
+}
+
 func (c *Core) Run() error {
-	// Follower.Run 会被阻塞
-	return c.f.Run()
+	if c.ms != nil {
+		c.ms.Start()
+	}
+	// Follower.Run Standalone.Run 会被阻塞
+	return c.n.Run()
 }
 
 func (c *Core) Stop() {
 	c.cancelFunc()
-	c.f.Stop()
+	c.n.Stop()
+	if c.ms != nil {
+		if err := c.ms.Stop(); err != nil {
+			zap.L().Error("stop metrics server failed", zap.Error(err))
+		}
+	}
 }
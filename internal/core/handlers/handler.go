@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"fmt"
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
 	"github.com/Junjiayy/hamal/pkg/core/writers"
+	"github.com/Junjiayy/hamal/pkg/metrics"
+	"github.com/Junjiayy/hamal/pkg/tools/logs"
 	"github.com/Junjiayy/hamal/pkg/types"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redsync/redsync/v4"
@@ -15,27 +19,36 @@ import (
 )
 
 type Handler struct {
-	filter types.Filter
-	wp     *writers.WriterPool
-	pool   *ants.PoolWithFunc
-	rs     *redsync.Redsync
+	filter    types.Filter
+	wp        *writers.WriterPool
+	pool      *ants.PoolWithFunc
+	rs        *redsync.Redsync
+	dlq       deadletters.Queue // 死信队列 为 nil 时不开启
+	retryConf configs.RetryConfig
 }
 
 var (
 	emptyErr = errors.New("empty")
 )
 
-const syncLockKeyTpl = "lock:%s:%s::keys" // 格式 lock:database:table:column1_column2..
+const syncLockKeyTpl = "lock:%s:%s::keys"        // 格式 lock:database:table:column1_column2..
+const submitWaitInterval = 10 * time.Millisecond // 携程池已满时 再次提交任务的间隔
+const invokeMaxWait = time.Second                // 携程池已满时 派生任务最长等待时间，需要小于记录锁的过期时间
 
-func NewHandler(redisCli *redis.Client, poolSize int) (h *Handler, err error) {
+func NewHandler(redisCli *redis.Client, conf *configs.SyncConfig, dlq deadletters.Queue) (h *Handler, err error) {
 	h = &Handler{
-		wp:     writers.NewWriterPool(),
-		rs:     redsync.New(goredis.NewPool(redisCli)),
-		filter: new(emptyFilter),
+		dlq:       dlq,
+		retryConf: conf.Retry,
+		wp:        writers.NewWriterPool(),
+		rs:        redsync.New(goredis.NewPool(redisCli)),
+		filter:    newRedisFilter(redisCli),
 	}
 
-	h.pool, err = ants.NewPoolWithFunc(poolSize, h.sync,
+	h.pool, err = ants.NewPoolWithFunc(conf.PoolSize, h.sync,
 		ants.WithNonblocking(true))
+	if err == nil {
+		metrics.SetPool(h.pool)
+	}
 
 	return
 }
@@ -44,7 +57,8 @@ func (h *Handler) GetWriterPool() *writers.WriterPool {
 	return h.wp
 }
 
-// Invoke 分配任务到 携程池
+// Invoke 分配任务到 携程池，携程池已满时间隔重试，最多等待 invokeMaxWait
+// 携程池内部派生的任务使用，等待有上限，防止所有协程互相等待
 func (h *Handler) Invoke(params *types.SyncParams) (err error) {
 	defer func() {
 		if err != nil {
@@ -54,11 +68,35 @@ func (h *Handler) Invoke(params *types.SyncParams) (err error) {
 	}()
 
 	params.GetWg().Add(1)
-	if err := h.pool.Invoke(params); err != nil {
-		return errors.WithStack(err)
+	deadline := time.Now().Add(invokeMaxWait)
+	for {
+		err := h.pool.Invoke(params)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, ants.ErrPoolOverload) || time.Now().After(deadline) {
+			return errors.WithStack(err)
+		}
+
+		time.Sleep(submitWaitInterval)
 	}
+}
+
+// Submit 分配任务到携程池，携程池已满时阻塞等待，直到任务被接收或携程池被关闭
+// 只用于 reader 提交任务，给 reader 施加背压；携程池内部派生的任务使用 Invoke，防止所有协程互相等待
+func (h *Handler) Submit(params *types.SyncParams) error {
+	params.GetWg().Add(1)
+	for {
+		err := h.pool.Invoke(params)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, ants.ErrPoolOverload) {
+			// 携程池已关闭，直接释放本次执行参数
+			params.Recycle()
+			return errors.WithStack(err)
+		}
 
-	return nil
+		time.Sleep(submitWaitInterval)
+	}
 }
 
 // run 携程池执行方法，主要同步逻辑
@@ -70,6 +108,7 @@ func (h *Handler) sync(paramsInter interface{}) {
 			h.writeLog(params, errors.Errorf("recover: %v", err))
 		}
 	}()
+	h.incRuleTask(params, metrics.RuleResultInvoked)
 
 	mutex, lockKey := h.lockRecordByParams(params)
 	if mutex == nil {
@@ -91,9 +130,11 @@ func (h *Handler) sync(paramsInter interface{}) {
 		err = h.delete(params)
 	}
 
-	if err != nil && !errors.Is(err, emptyErr) {
+	if errors.Is(err, emptyErr) {
+		h.incRuleTask(params, metrics.RuleResultEmpty)
+	} else if err != nil {
 		h.writeLog(params, err)
-	} else if err == nil {
+	} else {
 		if err := h.filter.InsertEventRecord(params, columns); err != nil {
 			h.writeLog(params, err)
 		}
@@ -103,7 +144,8 @@ func (h *Handler) sync(paramsInter interface{}) {
 // lockRecordByParams 通过同步参数给记录添加分布式锁
 // 防止并发修改时数据错误
 func (h *Handler) lockRecordByParams(params *types.SyncParams) (*redsync.Mutex, string) {
-	lockArgs := []interface{}{params.Rule.Database, params.Rule.Table}
+	database, table := params.GetSource()
+	lockArgs := []interface{}{database, table}
 	for _, key := range params.Rule.LockColumns {
 		lockArgs = append(lockArgs, params.Data[key])
 	}
@@ -111,8 +153,12 @@ func (h *Handler) lockRecordByParams(params *types.SyncParams) (*redsync.Mutex,
 	tpl := strings.TrimRight(strings.Repeat("%s_", len(params.Rule.LockColumns)), "_")
 	// 组装分布式锁的KEY
 	lockKey := fmt.Sprintf(strings.ReplaceAll(syncLockKeyTpl, ":keys", tpl), lockArgs...)
-	mutex := h.rs.NewMutex(lockKey, redsync.WithExpiry(3*time.Second), redsync.WithRetryDelay(100*time.Millisecond))
-	if err := mutex.Lock(); err != nil {
+	mutex := h.rs.NewMutex(lockKey, redsync.WithExpiry(h.lockExpiry(params.Rule.TargetType)),
+		redsync.WithRetryDelay(100*time.Millisecond))
+	start := time.Now()
+	err := h.withRetry(params.Rule.TargetType, mutex.Lock)
+	metrics.ObserveSince(metrics.LockWaitSeconds, start)
+	if err != nil {
 		h.writeLog(params, err)
 		return nil, lockKey
 	}
@@ -130,7 +176,8 @@ func (h *Handler) unlockRecordByMutex(mutex *redsync.Mutex, lockKey string) {
 // insert insert 事件同步方法
 func (h *Handler) insert(params *types.SyncParams) ([]string, error) {
 	// 判断同步过滤条件是否通过
-	if !params.Rule.EvaluateFilterConditions(params.Data) {
+	if !params.EvaluateFilterConditions() {
+		h.incRuleTask(params, metrics.RuleResultFiltered)
 		return nil, nil
 	}
 	// 插入的数据，如果软删除字段已存在标识，不执行同步操作直接返回
@@ -160,7 +207,9 @@ func (h *Handler) insert(params *types.SyncParams) ([]string, error) {
 	}
 	values := params.GetUpdateValues(columns)
 
-	return columns, writer.Insert(params, values)
+	return columns, h.write(params, metrics.RuleResultInserted, func() error {
+		return writer.Insert(params, values)
+	})
 }
 
 // update update 同步事件
@@ -171,7 +220,8 @@ func (h *Handler) update(params *types.SyncParams) ([]string, error) {
 			return nil, err
 		}
 		deleteParams := params.Clone(types.EventTypeDelete)
-		deleteParams.Data, deleteParams.Old = deleteParams.MergeOldToData(), nil
+		deleteParams.Data, deleteParams.Nulls = deleteParams.MergeOldToData(), deleteParams.MergeOldNulls()
+		deleteParams.Old, deleteParams.OldNulls = nil, nil
 		if err := h.Invoke(deleteParams); err != nil {
 			return nil, err
 		}
@@ -192,8 +242,10 @@ func (h *Handler) update(params *types.SyncParams) ([]string, error) {
 		return h.insert(params)
 	}
 
-	oldFilterOk := params.Rule.EvaluateFilterConditions(params.MergeOldToData())
-	dataFilterOk := params.Rule.EvaluateFilterConditions(params.Data)
+	dataFilterOk := params.EvaluateFilterConditions()
+	// 更新前的数据没有 old，条件中的 old 字段使用更新前的值
+	// 描述变更的条件 (例如 old.status != status) 更新前不通过，符合条件的变更按新增数据写入
+	oldFilterOk := params.EvaluateOldFilterConditions()
 	// 判断同步过滤条件是否通过
 	if !dataFilterOk && oldFilterOk { // 新数据判断不通过，老数据通过，删除老数据
 		params.RealEventType = types.EventTypeDelete
@@ -202,6 +254,7 @@ func (h *Handler) update(params *types.SyncParams) ([]string, error) {
 		params.RealEventType = types.EventTypeInsert
 		return h.insert(params)
 	} else if !dataFilterOk && !oldFilterOk {
+		h.incRuleTask(params, metrics.RuleResultFiltered)
 		return nil, nil
 	}
 
@@ -228,13 +281,18 @@ func (h *Handler) realUpdate(params *types.SyncParams) ([]string, error) {
 	}
 	values := params.GetUpdateValues(columns)
 
-	return columns, writer.Update(params, values)
+	return columns, h.write(params, metrics.RuleResultUpdated, func() error {
+		return writer.Update(params, values)
+	})
 }
 
 // delete delete 事件同步方法
 func (h *Handler) delete(params *types.SyncParams) error {
 	_, err, isNotEmpty := h.filter.FilterColumns(params, nil)
 	if !isNotEmpty {
+		if err == nil {
+			h.incRuleTask(params, metrics.RuleResultEmpty)
+		}
 		return err
 	}
 	writer, err := h.wp.GetWriter(params.Rule.TargetType)
@@ -242,12 +300,43 @@ func (h *Handler) delete(params *types.SyncParams) error {
 		return err
 	}
 
-	return writer.Delete(params)
+	return h.write(params, metrics.RuleResultDeleted, func() error {
+		return writer.Delete(params)
+	})
+}
+
+// write 按重试策略执行写入操作，记录每次写入的耗时，写入成功后记录结果
+func (h *Handler) write(params *types.SyncParams, result string, fn func() error) error {
+	writerSeconds := metrics.WriterSeconds.WithLabelValues(params.Rule.TargetType,
+		params.Rule.GetFullTarget(), params.RealEventType)
+	err := h.withRetry(params.Rule.TargetType, func() error {
+		defer metrics.ObserveSince(writerSeconds, time.Now())
+		return fn()
+	})
+	if err == nil {
+		h.incRuleTask(params, result)
+	}
+
+	return err
 }
 
-// writeLog 写入日志，并追加错误到本次执行参数中
+// incRuleTask 记录同步规则的任务结果
+func (h *Handler) incRuleTask(params *types.SyncParams, result string) {
+	metrics.IncRuleTask(params.Rule.GetRuleKey(), params.Rule.GetFullTarget(), result)
+}
+
+// writeLog 写入日志，并把失败任务写入死信队列
+// 写入死信队列成功视为任务已处理，否则追加错误到本次执行参数中
 func (h *Handler) writeLog(params *types.SyncParams, err error) {
 	zap.L().Error("同步失败", zap.Reflect("params", params), zap.Error(err))
+	h.incRuleTask(params, metrics.RuleResultErrored)
+	if h.dlq != nil {
+		dlqErr := h.dlq.Push(deadletters.NewDeadLetter(params, err))
+		if dlqErr == nil {
+			return
+		}
+		logs.Error("push dead letter failed", dlqErr)
+	}
 	params.GetWg().AddErr(err)
 }
 
@@ -257,4 +346,7 @@ func (h *Handler) Release() {
 	for _, writer := range h.wp.GetWriters() {
 		_ = writer.GetDataSource().Close()
 	}
+	if h.dlq != nil {
+		_ = h.dlq.Close()
+	}
 }
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
 	"github.com/Junjiayy/hamal/pkg/core/writers"
 	types "github.com/Junjiayy/hamal/pkg/types"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
@@ -10,11 +13,12 @@ import (
 	"github.com/pkg/errors"
 	"os"
 	"testing"
+	"time"
 )
 
 type (
 	testWriterAndFilter struct {
-		records map[string]map[string]string
+		records map[string]map[string]interface{}
 	}
 )
 
@@ -22,14 +26,18 @@ func (t *testWriterAndFilter) Type() string {
 	return "test"
 }
 
+func (t *testWriterAndFilter) GetDataSource() datasources.DataSource {
+	return datasources.NewDataSourceBase(nil, nil)
+}
+
 func (t *testWriterAndFilter) Insert(params *types.SyncParams, values interface{}) error {
 	switch params.Rule.SyncType {
 	case types.SyncTypeCopy:
-		t.records[params.Data[params.Rule.PrimaryKey]] = values.(map[string]string)
+		t.records[params.Data[params.Rule.PrimaryKey]] = values.(map[string]interface{})
 	case types.SyncTypeJoin:
-		t.records[params.Rule.JoinFieldName] = values.(map[string]string)
+		t.records[params.Rule.JoinFieldName] = values.(map[string]interface{})
 	case types.SyncTypeInner:
-		t.records[params.Rule.JoinFieldName] = map[string]string{"value": values.(string)}
+		t.records[params.Rule.JoinFieldName] = map[string]interface{}{"value": values}
 	}
 
 	return nil
@@ -66,19 +74,35 @@ func (t *testWriterAndFilter) FilterColumns(params *types.SyncParams, columns []
 	return nil, nil, false
 }
 
-var h *handler
+var h *Handler
 
 func TestMain(m *testing.M) {
-	wsf := &testWriterAndFilter{records: make(map[string]map[string]string)}
-	redisCli := redis.NewClient(&redis.Options{
-		Addr:     "10.211.55.4:6379",
-		Password: "123456",
-		DB:       0,
+	wsf := &testWriterAndFilter{records: make(map[string]map[string]interface{})}
+	mr, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	defer mr.Close()
+	redisCli := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	writers.SetWriterConstructor("test", func(datasources.DataSource) writers.Writer {
+		return wsf
+	})
+	datasources.SetDataSourceConstructor("test", func() datasources.DataSource {
+		return wsf.GetDataSource()
 	})
+	wp := writers.NewWriterPool()
+	if err := wp.SetConfigs(map[string]map[string]datasources.DataSourceConfig{
+		"test": {},
+	}); err != nil {
+		panic(err)
+	}
 
-	h = &handler{
-		filter: wsf, ws: map[string]writers.Writer{"test": wsf},
+	h = &Handler{
+		filter: wsf, wp: wp,
 		rs: redsync.New(goredis.NewPool(redisCli)),
+		// 记录锁竞争失败时重试，保证并发加锁测试在单核环境下全部加锁成功
+		retryConf: configs.RetryConfig{Default: configs.RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond}},
 	}
 
 	pool, _ := ants.NewPoolWithFunc(5, h.sync,
@@ -140,6 +164,15 @@ func Test_handler_lockRecordByParams(t *testing.T) {
 	if j != 1000 {
 		t.Fatalf("分布式锁测试失败，期待值: 1000, 实际值: %d", j)
 	}
+
+	// 通配规则使用实际的来源表加锁
+	params.Rule.Table = "tests_*"
+	params.GetBingLogParams().Table = "tests_0"
+	mutex, lockKey := h.lockRecordByParams(params)
+	h.unlockRecordByMutex(mutex, lockKey)
+	if lockKey != "lock:test:tests_0:1_hhhhh_18" {
+		t.Fatalf("pattern rule lock key error: %s", lockKey)
+	}
 }
 
 func Test_handler_insert(t *testing.T) {
@@ -197,9 +230,67 @@ func Test_handler_delete(t *testing.T) {
 	}
 }
 
+func Test_handler_updateDataFilterReferencesOld(t *testing.T) {
+	wtf := h.filter.(*testWriterAndFilter)
+	// 记录开始符合条件，按新增数据写入
+	params := getSyncParams()
+	params.Rule.DataFilter = "age >= 18 and old.age is not null"
+	params.Old = map[string]string{"age": "17"}
+	if _, err := h.update(params); err != nil {
+		t.Fatalf("sync update event failed: %s", err)
+	}
+	if _, ok := wtf.records[params.Data["id"]]; !ok || params.RealEventType != types.EventTypeInsert {
+		t.Fatalf("record entering data filter should be inserted, real event type: %s", params.RealEventType)
+	}
+
+	// 记录不再符合条件，删除已同步的数据
+	params = getSyncParams()
+	params.Rule.DataFilter = "age >= 18 and old.age is not null"
+	params.Data["age"], params.Old = "17", map[string]string{"age": "18"}
+	if _, err := h.update(params); err != nil {
+		t.Fatalf("sync update event failed: %s", err)
+	}
+	if _, ok := wtf.records[params.Data["id"]]; ok || params.RealEventType != types.EventTypeDelete {
+		t.Fatalf("record leaving data filter should be deleted, real event type: %s", params.RealEventType)
+	}
+}
+
 func Test_handler_update(t *testing.T) {
 	params := getSyncParams()
 	// 常规更新
 	params.Old = map[string]string{"name": "name2", "age": "25"}
 
 }
+
+func Test_handler_InvokePoolFull(t *testing.T) {
+	release := make(chan struct{})
+	fullHandler := &Handler{}
+	fullHandler.pool, _ = ants.NewPoolWithFunc(1, func(paramsInter interface{}) {
+		<-release
+		paramsInter.(*types.SyncParams).Recycle()
+	}, ants.WithNonblocking(true))
+	defer fullHandler.pool.Release()
+
+	params := getSyncParams()
+	wg := params.GetWg()
+	if err := fullHandler.Invoke(params); err != nil {
+		t.Fatal(err)
+	}
+
+	// 携程池已满时等待空闲协程，不直接返回 ErrPoolOverload
+	time.AfterFunc(100*time.Millisecond, func() { release <- struct{}{} })
+	if err := fullHandler.Invoke(params.Clone(types.EventTypeInsert)); err != nil {
+		t.Fatalf("invoke should wait for idle worker: %v", err)
+	}
+
+	// 超过最长等待时间后返回 ErrPoolOverload
+	start := time.Now()
+	if err := fullHandler.Invoke(params.Clone(types.EventTypeDelete)); !errors.Is(err, ants.ErrPoolOverload) {
+		t.Fatalf("invoke should fail with pool overload, err: %v", err)
+	} else if time.Since(start) < invokeMaxWait {
+		t.Fatalf("invoke should wait %s before fail, actual: %s", invokeMaxWait, time.Since(start))
+	}
+
+	release <- struct{}{}
+	wg.Wait()
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+// redisFilter 基于 redis 的字段修改时间过滤器
+// 每条记录使用一个 hash 保存 字段:最后修改的事件时间，
+// 落后的事件只允许写入未被更新事件修改过的字段
+type redisFilter struct {
+	cli *redis.Client
+}
+
+const filterRecordKeyTpl = "filter:%s:%s:%s.%s.%s:%s" // 格式 filter:database:table:targetType.target.targetTable:identifyId
+const filterDeletedField = "__deleted_at__"           // 记录删除事件时间的字段
+const filterRecordExpiry = 72 * time.Hour             // 记录过期时间，超过这个时间的乱序事件不再过滤
+const filterTimeout = time.Second
+
+// insertEventRecordScript 只有事件时间大于已记录的时间才会覆盖字段记录
+// KEYS[1]: 记录key, ARGV[1]: 事件时间, ARGV[2]: 过期时间(毫秒), ARGV[3...]: 字段
+var insertEventRecordScript = redis.NewScript(`
+local eventAt = tonumber(ARGV[1])
+for i = 3, #ARGV do
+	local recorded = redis.call('HGET', KEYS[1], ARGV[i])
+	if not recorded or tonumber(recorded) < eventAt then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[1])
+	end
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+func newRedisFilter(cli *redis.Client) types.Filter {
+	return &redisFilter{cli: cli}
+}
+
+// InsertEventRecord 记录本次事件修改的字段和事件时间
+// 删除事件不存在修改字段，只记录删除时间
+func (r *redisFilter) InsertEventRecord(params *types.SyncParams, updatedColumns []string) error {
+	if params.RealEventType == types.EventTypeDelete {
+		updatedColumns = []string{filterDeletedField}
+	} else if len(updatedColumns) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(updatedColumns)+2)
+	args = append(args, params.GetBingLogParams().EventAt, filterRecordExpiry.Milliseconds())
+	for _, column := range updatedColumns {
+		args = append(args, column)
+	}
+
+	timeout, cancelFunc := context.WithTimeout(context.Background(), filterTimeout)
+	defer cancelFunc()
+	if err := insertEventRecordScript.Run(timeout, r.cli, []string{r.recordKey(params)},
+		args...).Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// FilterColumns 过滤已经被更新事件修改过的字段
+// 记录在本次事件之后被删除，或删除事件之后记录又被修改过，返回空
+func (r *redisFilter) FilterColumns(params *types.SyncParams, columns []string) ([]string, error, bool) {
+	timeout, cancelFunc := context.WithTimeout(context.Background(), filterTimeout)
+	defer cancelFunc()
+
+	records, err := r.cli.HGetAll(timeout, r.recordKey(params)).Result()
+	if err != nil {
+		return nil, errors.WithStack(err), false
+	}
+	eventAt := params.GetBingLogParams().EventAt
+
+	if params.RealEventType == types.EventTypeDelete {
+		for _, recorded := range records {
+			if parseEventAt(recorded) > eventAt {
+				return nil, nil, false
+			}
+		}
+
+		return columns, nil, true
+	}
+
+	if deletedAt, ok := records[filterDeletedField]; ok && parseEventAt(deletedAt) > eventAt {
+		return nil, nil, false
+	}
+
+	filteredColumns := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if recorded, ok := records[column]; ok && parseEventAt(recorded) > eventAt {
+			continue
+		}
+		filteredColumns = append(filteredColumns, column)
+	}
+
+	return filteredColumns, nil, len(filteredColumns) > 0
+}
+
+// recordKey 获取记录字段修改时间的 key，同一条来源记录在不同的同步目标下分别记录
+func (r *redisFilter) recordKey(params *types.SyncParams) string {
+	database, table := params.GetSource()
+
+	return fmt.Sprintf(filterRecordKeyTpl, database, table, params.Rule.TargetType, params.Rule.Target,
+		params.Rule.TargetTable, params.GetIdentifyId())
+}
+
+func parseEventAt(value string) int64 {
+	eventAt, _ := strconv.ParseInt(value, 10, 64)
+
+	return eventAt
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"testing"
+)
+
+func newTestRedisFilter(t *testing.T) types.Filter {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+
+	return newRedisFilter(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+}
+
+func getSyncParamsAt(eventType string, eventAt int64) *types.SyncParams {
+	params := getSyncParams()
+	params.RealEventType = eventType
+	params.GetBingLogParams().EventAt = eventAt
+
+	return params
+}
+
+func TestRedisFilter_FilterColumns(t *testing.T) {
+	filter := newTestRedisFilter(t)
+	columns := []string{"id", "name", "age"}
+
+	if err := filter.InsertEventRecord(getSyncParamsAt(types.EventTypeUpdate, 20),
+		[]string{"name", "age"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 落后的事件只能写入未被修改过的字段
+	filtered, err, ok := filter.FilterColumns(getSyncParamsAt(types.EventTypeUpdate, 10), columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(filtered) != 1 || filtered[0] != "id" {
+		t.Fatalf("stale columns should be filtered, expect: [id], actual: %v", filtered)
+	}
+
+	// 更新的事件可以写入所有字段
+	filtered, err, ok = filter.FilterColumns(getSyncParamsAt(types.EventTypeUpdate, 30), columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(filtered) != len(columns) {
+		t.Fatalf("newer event columns should not be filtered, actual: %v", filtered)
+	}
+
+	// 落后的删除事件不执行
+	if _, err, ok = filter.FilterColumns(getSyncParamsAt(types.EventTypeDelete, 15), nil); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("stale delete event should be filtered")
+	}
+}
+
+func TestRedisFilter_DeletedRecord(t *testing.T) {
+	filter := newTestRedisFilter(t)
+
+	if err := filter.InsertEventRecord(getSyncParamsAt(types.EventTypeDelete, 40), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除之前的事件不能恢复记录
+	if _, err, ok := filter.FilterColumns(getSyncParamsAt(types.EventTypeUpdate, 35),
+		[]string{"name"}); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("event before delete should be filtered")
+	}
+
+	// 删除之后的新增事件正常写入
+	if filtered, err, ok := filter.FilterColumns(getSyncParamsAt(types.EventTypeInsert, 50),
+		[]string{"name"}); err != nil {
+		t.Fatal(err)
+	} else if !ok || len(filtered) != 1 {
+		t.Fatalf("event after delete should not be filtered, actual: %v", filtered)
+	}
+}
+
+func TestRedisFilter_PatternRule(t *testing.T) {
+	filter := newTestRedisFilter(t)
+	getShardParams := func(table string, eventAt int64) *types.SyncParams {
+		params := getSyncParamsAt(types.EventTypeUpdate, eventAt)
+		params.Rule.Table = "tests_*"
+		params.GetBingLogParams().Table = table
+
+		return params
+	}
+
+	if err := filter.InsertEventRecord(getShardParams("tests_0", 20), []string{"name"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 通配规则按实际的来源表记录，其他分表的相同记录不受影响
+	if filtered, err, ok := filter.FilterColumns(getShardParams("tests_1", 10), []string{"name"}); err != nil {
+		t.Fatal(err)
+	} else if !ok || len(filtered) != 1 {
+		t.Fatalf("record of another shard should not be filtered, actual: %v", filtered)
+	}
+	if _, err, ok := filter.FilterColumns(getShardParams("tests_0", 10), []string{"name"}); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("stale record of the same shard should be filtered")
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"github.com/Junjiayy/hamal/pkg/core/writers"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+const syncLockExpiry = 3 * time.Second // 单次同步 记录锁的过期时间
+
+// withRetry 执行 fn，可重试的错误按目标类型的重试策略重试
+// 写入操作调用时已经持有记录锁，锁的过期时间包含了全部重试时间，见 lockExpiry
+func (h *Handler) withRetry(targetType string, fn func() error) error {
+	policy := h.retryConf.GetPolicy(targetType)
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || !isRetryableErr(targetType, err) {
+			return err
+		}
+
+		backoff := policy.Backoff(attempt)
+		zap.L().Warn("sync retry", zap.String("target_type", targetType), zap.Int("attempt", attempt),
+			zap.Duration("backoff", backoff), zap.Error(err))
+		time.Sleep(backoff)
+	}
+}
+
+// lockExpiry 获取记录锁的过期时间，需要覆盖全部重试的执行时间
+func (h *Handler) lockExpiry(targetType string) time.Duration {
+	policy := h.retryConf.GetPolicy(targetType)
+	if policy.MaxAttempts <= 1 {
+		return syncLockExpiry
+	}
+
+	return syncLockExpiry*time.Duration(policy.MaxAttempts) + policy.MaxDuration()
+}
+
+// isRetryableErr 判断错误是否可以重试
+// 记录锁竞争失败 和 写入器的暂时性错误可以重试，字段映射、校验等错误直接失败
+func isRetryableErr(targetType string, err error) bool {
+	if errors.Is(err, emptyErr) {
+		return false
+	} else if errors.Is(err, redsync.ErrFailed) {
+		return true
+	}
+	var takenErr *redsync.ErrTaken
+	if errors.As(err, &takenErr) {
+		return true
+	}
+
+	return writers.IsRetryableErr(targetType, err)
+}
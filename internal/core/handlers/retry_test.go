@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-sql-driver/mysql"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func TestIsRetryableErr(t *testing.T) {
+	cases := []struct {
+		targetType string
+		err        error
+		retryable  bool
+	}{
+		{types.DataSourceMysql, errors.WithStack(&mysql.MySQLError{Number: 1213}), true},
+		{types.DataSourceMysql, &mysql.MySQLError{Number: 1205}, true},
+		{types.DataSourceMysql, &mysql.MySQLError{Number: 1054}, false},
+		{types.DataSourceElasticSearch, errors.WithStack(&elastic.Error{Status: 429}), true},
+		{types.DataSourceElasticSearch, &elastic.Error{Status: 503}, true},
+		{types.DataSourceElasticSearch, &elastic.Error{Status: 400}, false},
+		{types.DataSourceElasticSearch, errors.WithStack(context.DeadlineExceeded), true},
+		{types.DataSourceMysql, errors.New("mysql writer only support copy"), false},
+		{types.DataSourceMysql, emptyErr, false},
+	}
+
+	for i, c := range cases {
+		if retryable := isRetryableErr(c.targetType, c.err); retryable != c.retryable {
+			t.Fatalf("case %d: %v retryable should be %v", i, c.err, c.retryable)
+		}
+	}
+}
+
+func TestHandler_withRetry(t *testing.T) {
+	retryHandler := &Handler{retryConf: configs.RetryConfig{
+		Default: configs.RetryPolicy{MaxAttempts: 1},
+		Targets: map[string]configs.RetryPolicy{
+			types.DataSourceMysql: {MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2},
+		},
+	}}
+
+	var attempts int
+	err := retryHandler.withRetry(types.DataSourceMysql, func() error {
+		attempts++
+		return &mysql.MySQLError{Number: 1213}
+	})
+	if err == nil || attempts != 3 {
+		t.Fatalf("deadlock should be retried 3 times, attempts: %d", attempts)
+	}
+
+	attempts = 0
+	err = retryHandler.withRetry(types.DataSourceMysql, func() error {
+		if attempts++; attempts < 2 {
+			return &mysql.MySQLError{Number: 1213}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("retry should succeed on second attempt, attempts: %d, err: %v", attempts, err)
+	}
+
+	attempts = 0
+	_ = retryHandler.withRetry(types.DataSourceMysql, func() error {
+		attempts++
+		return errors.New("unknown column")
+	})
+	if attempts != 1 {
+		t.Fatalf("non retryable error should not be retried, attempts: %d", attempts)
+	}
+
+	attempts = 0
+	_ = retryHandler.withRetry(types.DataSourceElasticSearch, func() error {
+		attempts++
+		return &elastic.Error{Status: 429}
+	})
+	if attempts != 1 {
+		t.Fatalf("es should use default policy, attempts: %d", attempts)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := configs.RetryPolicy{
+		MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond,
+		Multiplier: 2, Jitter: 0.2,
+	}
+
+	for attempt, expected := range map[int]time.Duration{1: 100, 2: 200, 3: 300, 4: 300} {
+		expected *= time.Millisecond
+		backoff := policy.Backoff(attempt)
+		if backoff < expected*8/10 || backoff > expected*12/10 {
+			t.Fatalf("attempt %d backoff %s out of range %s", attempt, backoff, expected)
+		}
+	}
+	if maxDuration := policy.MaxDuration(); maxDuration != 1080*time.Millisecond {
+		t.Fatalf("max duration should be 1.08s, current: %s", maxDuration)
+	}
+}
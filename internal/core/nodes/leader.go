@@ -29,7 +29,7 @@ type (
 	}
 )
 
-const readersPath = "/porter/readers" // 所有任务节点
+const ReadersPath = "/porter/readers" // 所有任务节点
 
 func newLeaderNode(parent context.Context, zkConn *zk.Conn, val string) *leader {
 	runnerCloseChan := make(chan struct{}, 1)
@@ -54,7 +54,7 @@ func (l *leader) run() error {
 	}
 	defer lock.Unlock()
 
-	followerData, _, err := l.zkConn.Get(followerRootPath)
+	followerData, _, err := l.zkConn.Get(FollowerRootPath)
 	if err != nil {
 		return err
 	}
@@ -63,7 +63,7 @@ func (l *leader) run() error {
 	}
 	// 开始监听 followers 目录结构变更
 	l.runner.RunWorker(l.watchFollowersChanged)
-	l.runner.RunWorker(l.watchNodeDataChange(readersPath, l.readersChanged))
+	l.runner.RunWorker(l.watchNodeDataChange(ReadersPath, l.readersChanged))
 
 	// 阻塞: 等待 runnerCloseChan 通道读取事件
 	select {
@@ -75,7 +75,7 @@ func (l *leader) run() error {
 
 // watchFollowersChanged 监听跟随者节点删除或新增
 func (l *leader) watchFollowersChanged(ctx context.Context) {
-	children, _, events, err := l.zkConn.ChildrenW(followerRootPath)
+	children, _, events, err := l.zkConn.ChildrenW(FollowerRootPath)
 	if err != nil {
 		panic(fmt.Sprintf("watch followers failed: %v", err))
 	}
@@ -85,7 +85,7 @@ func (l *leader) watchFollowersChanged(ctx context.Context) {
 		select {
 		case event := <-events:
 			if event.Type == zk.EventNodeChildrenChanged {
-				updatedFollowerPaths, _, err := l.zkConn.Children(followerRootPath)
+				updatedFollowerPaths, _, err := l.zkConn.Children(FollowerRootPath)
 				if err != nil {
 					panic(fmt.Sprintf("get updated follower paths failed: %v", err))
 				}
@@ -98,7 +98,7 @@ func (l *leader) watchFollowersChanged(ctx context.Context) {
 			return
 		}
 
-		_, _, events, err = l.zkConn.ChildrenW(followerRootPath)
+		_, _, events, err = l.zkConn.ChildrenW(FollowerRootPath)
 		if err != nil {
 			panic(fmt.Sprintf("watch followers failed: %v", err))
 		}
@@ -197,7 +197,7 @@ func (l *leader) broadcast() error {
 	if err != nil {
 		return err
 	}
-	if _, err = l.zkConn.Set(followerRootPath, followerData, -1); err != nil {
+	if _, err = l.zkConn.Set(FollowerRootPath, followerData, -1); err != nil {
 		return err
 	}
 	for followerPath, followerReaderConfigs := range l.tasks {
@@ -238,7 +238,7 @@ func (l *leader) stopOnceFunc() error {
 	// 所以在这再调用一次
 	l.cancelFunc()
 	close(l.runnerCloseChan)
-	data, _, err := l.zkConn.Get(leaderPath)
+	data, _, err := l.zkConn.Get(LeaderPath)
 	if err != nil {
 		if errors.Is(err, zk.ErrNoNode) {
 			return nil
@@ -249,7 +249,7 @@ func (l *leader) stopOnceFunc() error {
 
 	if string(data) == l.val {
 		// 如果主节点和 l.val 相等，说明当前 leader 节点是由当前进程创建，删除 leader 节点
-		if err := l.zkConn.Delete(leaderPath, -1); err != nil {
+		if err := l.zkConn.Delete(LeaderPath, -1); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,74 @@
+package nodes
+
+import (
+	"context"
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Standalone 单机节点，不依赖 zookeeper
+// 数据源、读取器、同步规则全部从配置文件加载，执行逻辑和 Follower 一致
+type Standalone struct {
+	worker
+	conf       *configs.SyncConfig
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func NewStandaloneNode(parent context.Context, redisCli *redis.Client, conf *configs.SyncConfig,
+	dlq deadletters.Queue) (*Standalone, error) {
+	w, err := newWorker(parent, redisCli, conf, dlq)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancelFunc := context.WithCancel(parent)
+
+	return &Standalone{
+		worker: w, conf: conf, ctx: ctx, cancelFunc: cancelFunc,
+	}, nil
+}
+
+func (s *Standalone) Run() error {
+	dbConfigsByType, err := s.conf.GetDataSourceConfigsByType()
+	if err != nil {
+		return err
+	}
+	if err := types.ValidateRules(s.conf.Rules); err != nil {
+		return err
+	}
+	readerConfigs, err := s.conf.GetReaderConfigs()
+	if err != nil {
+		return err
+	}
+	if err := s.setDataSourceConfigs(dbConfigsByType); err != nil {
+		return err
+	}
+	// 先加载同步规则 再开启读取器，防止读取到的数据找不到规则
+	s.setRules(s.conf.Rules)
+	s.setReaderConfigs(s.ctx, readerConfigs)
+	zap.L().Info("standalone node started", zap.Int("readers", len(readerConfigs)),
+		zap.Int("rules", len(s.conf.Rules)))
+
+	// 阻塞: 等待 runnerCloseChan 通道读取事件
+	select {
+	case <-s.runnerCloseChan:
+		// 接受到 runner 关闭通道数据，说明 runner 已经被关闭
+		return s.stopOnceFunc()
+	}
+}
+
+// stopOnceFunc 停止方法，只能调用一次，多次调用会 panic
+func (s *Standalone) stopOnceFunc() error {
+	// runners.Runner 可能会主动停止，所以在这再调用一次 cancelFunc
+	s.cancelFunc()
+
+	return s.release()
+}
+
+func (s *Standalone) Stop() {
+	s.cancelFunc()
+	s.runner.Stop()
+}
@@ -0,0 +1,247 @@
+package nodes
+
+import (
+	"context"
+	"github.com/Junjiayy/hamal/internal/core/handlers"
+	"github.com/Junjiayy/hamal/internal/core/runners"
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
+	"github.com/Junjiayy/hamal/pkg/core/readers"
+	"github.com/Junjiayy/hamal/pkg/metrics"
+	"github.com/Junjiayy/hamal/pkg/tools/logs"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	submitRetryMinBackoff = 100 * time.Millisecond // 事件处理失败后 第一次重新提交的间隔
+	submitRetryMaxBackoff = 10 * time.Second       // 事件处理失败后 最大重新提交间隔
+)
+
+// worker 同步任务执行器，负责 reader 的启停、规则匹配 和 任务分发
+// Follower 和 Standalone 共用，不依赖 zookeeper
+type worker struct {
+	rules           *types.RuleIndex
+	ruleRwMux       *sync.RWMutex
+	rs              map[string]readers.Reader
+	rsMux           *sync.Mutex
+	runner          *runners.Runner
+	runnerCloseChan chan struct{}
+	h               *handlers.Handler
+	wg              *sync.WaitGroup
+}
+
+func newWorker(parent context.Context, redisCli *redis.Client, conf *configs.SyncConfig,
+	dlq deadletters.Queue) (worker, error) {
+	h, err := handlers.NewHandler(redisCli, conf, dlq)
+	if err != nil {
+		return worker{}, err
+	}
+	runnerCloseChan := make(chan struct{}, 1)
+
+	return worker{
+		rules:           types.NewRuleIndex(nil),
+		ruleRwMux:       new(sync.RWMutex),
+		rs:              make(map[string]readers.Reader),
+		rsMux:           new(sync.Mutex),
+		runner:          runners.NewRunner(parent, runnerCloseChan),
+		runnerCloseChan: runnerCloseChan,
+		h:               h,
+		wg:              new(sync.WaitGroup),
+	}, nil
+}
+
+// setReaderConfigs 更新 reader 配置，开启新增的 reader，关闭已移除的 reader
+func (w *worker) setReaderConfigs(ctx context.Context, configs map[string]readers.ReaderConfigByType) {
+	w.rsMux.Lock()
+	defer w.rsMux.Unlock()
+
+	for uniqueId, config := range configs {
+		if reader, ok := w.rs[uniqueId]; ok {
+			// 如果更新前的配置信息和更新后的配置信息不一致，则关闭老的 reader
+			// notice: 一般不太会出现这个情况，reader config 的唯一id都是通过重要的敏感信息hash来的
+			if !reader.GetConfig().Equal(config.Config) {
+				zap.L().Info("reader replace close old reader", zap.String("id", uniqueId))
+				if err := reader.Close(); err != nil {
+					logs.Error("close reader failed", err)
+				}
+				delete(w.rs, uniqueId)
+			} else {
+				// 如果 reader config 没有被修改，直接提过本次循环
+				continue
+			}
+		}
+
+		// 开启 reader 监听
+		zap.L().Info("reader start listen", zap.Reflect("config", config.Config))
+		readerConstructor := readers.GetReaderConstructor(config.Type)
+		if readerConstructor == nil {
+			zap.L().Error("reader constructor not exists", zap.String("type", config.Type))
+			continue
+		}
+		reader, err := readerConstructor(config.Config, w.wg, ctx)
+		if err != nil {
+			logs.Error("reader initialize failed", err)
+			continue
+		}
+		w.rs[uniqueId] = reader
+
+		w.runner.RunWorker(w.listen(reader))
+	}
+
+	for uniqueId, reader := range w.rs {
+		// 检查所有正在执行的 reader，如果不在本次更新中就关闭 reader
+		if _, ok := configs[uniqueId]; !ok {
+			if err := reader.Close(); err != nil {
+				logs.Error("close reader failed", err)
+			}
+			delete(w.rs, uniqueId)
+		}
+	}
+}
+
+// setRules 替换全部同步规则，重新创建规则索引
+func (w *worker) setRules(rules map[string][]*types.SyncRule) {
+	index := types.NewRuleIndex(rules)
+	w.ruleRwMux.Lock()
+	defer w.ruleRwMux.Unlock()
+	w.rules = index
+}
+
+// setDataSourceConfigs 更新所有写入器的数据源配置
+func (w *worker) setDataSourceConfigs(configs map[string]map[string]datasources.DataSourceConfig) error {
+	return w.h.GetWriterPool().SetConfigs(configs)
+}
+
+// listen 开始监听 reader, 此方法被 runners.Runner 调用
+func (w *worker) listen(reader readers.Reader) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		for {
+			select {
+			case <-reader.GetCtx().Done():
+				// 每个 reader 都有自己独立都 context 当关闭 reader 时，context 需要一起关闭
+				return
+			case <-ctx.Done():
+				// Runner 被关闭
+				return
+			default:
+				uniqueId := reader.GetConfig().GetUniqueId()
+				bingLogParams, err := reader.Read()
+				if err == io.EOF || err == io.ErrClosedPipe {
+					zap.L().Info("reader closed", zap.String("unique", uniqueId))
+					return
+				} else if err != nil {
+					// todo: 考虑短时间内失败多次是否需要抛弃阅读器
+					zap.L().Error("listen reader failed", zap.String("unique", uniqueId), zap.Error(err))
+					continue
+				}
+				metrics.ReaderEvents.WithLabelValues(uniqueId).Inc()
+
+				if !bingLogParams.IsDdl {
+					err = w.submitUntilHandled(ctx, reader, bingLogParams)
+				} else {
+					metrics.ReaderDdlSkipped.WithLabelValues(uniqueId).Inc()
+				}
+
+				// 不管是否 ddl 修改，都需要提交 reader 成功
+				// 非 ddl 修改只有全部子任务成功或写入死信队列后才会提交
+				if err == nil {
+					start := time.Now()
+					if err := reader.Complete(bingLogParams); err != nil {
+						zap.L().Error("commit message failed", zap.Error(err))
+					}
+					metrics.ObserveSince(metrics.ReaderCompleteSeconds.WithLabelValues(uniqueId), start)
+					metrics.SetEventLag(uniqueId, bingLogParams.EventAt)
+				}
+			}
+		}
+	}
+}
+
+// submitUntilHandled 提交 binlog 事件，直到全部子任务执行成功或写入死信队列
+// 保证至少一次: 失败时不提交 reader，阻塞当前 reader 并间隔一段时间后重新提交整个事件
+// notice: 未开启死信队列时，一直失败的事件会阻塞对应的 reader
+func (w *worker) submitUntilHandled(ctx context.Context, reader readers.Reader, binLogParams *types.BinlogParams) error {
+	backoff := submitRetryMinBackoff
+	for {
+		err := w.submitToPoolExec(binLogParams)
+		if err == nil {
+			return nil
+		}
+		logs.Error("submit binlog event failed", err, zap.String("event", binLogParams.EventId),
+			zap.Duration("backoff", backoff))
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-reader.GetCtx().Done():
+			return reader.GetCtx().Err()
+		}
+		if backoff *= 2; backoff > submitRetryMaxBackoff {
+			backoff = submitRetryMaxBackoff
+		}
+	}
+}
+
+// submitToPoolExec 提交任务到携程池执行，等待全部子任务执行结束
+// 返回第一个未被处理的错误 (写入死信队列的错误不会返回)
+func (w *worker) submitToPoolExec(binLogParams *types.BinlogParams) error {
+	swg, ruleKey := types.NewSyncWaitGroup(), binLogParams.Database+"_"+binLogParams.Table
+	defer swg.Recycle()
+
+	w.ruleRwMux.RLock()
+	rules := w.rules.Match(binLogParams.Database, binLogParams.Table)
+	w.ruleRwMux.RUnlock()
+
+	if len(rules) == 0 {
+		zap.L().Info("rule not exists", zap.String("key", ruleKey))
+	}
+
+	for _, rule := range rules {
+		for i, datum := range binLogParams.Data {
+			var old map[string]string
+			if len(binLogParams.Old) > i {
+				old = binLogParams.Old[i]
+			}
+
+			params := types.NewSyncParams(swg, rule, datum, old, binLogParams)
+			params.Nulls, params.OldNulls = binLogParams.GetNulls(i), binLogParams.GetOldNulls(i)
+			// 携程池已满时阻塞等待，只有携程池被关闭才会失败
+			if err := w.h.Submit(params); err != nil {
+				swg.AddErr(err)
+			}
+		}
+	}
+
+	swg.Wait()
+	if errArr := swg.Errors(); len(errArr) > 0 {
+		return errArr[0]
+	}
+
+	return nil
+}
+
+// release 释放处理器并关闭所有读取器
+// 调用时 runners.Runner 已经停止，所有 goroutine 都已经退出
+func (w *worker) release() error {
+	close(w.runnerCloseChan)
+	// 释放处理器
+	w.h.Release()
+	// 关闭所有读取器
+	var lastErr error
+	for _, reader := range w.rs {
+		if err := reader.Close(); err != nil {
+			logs.Error("close reader failed", errors.WithStack(err))
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
@@ -0,0 +1,266 @@
+package nodes
+
+import (
+	"context"
+	"github.com/Junjiayy/hamal/pkg/configs"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
+	"github.com/Junjiayy/hamal/pkg/core/readers"
+	"github.com/Junjiayy/hamal/pkg/core/writers"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTargetType = "worker-test"
+
+type (
+	// testWriter 记录每个主键的写入次数，failures 中的主键写入失败
+	testWriter struct {
+		mux      sync.Mutex
+		written  map[string]int
+		failures map[string]int // 主键剩余失败次数
+		block    chan struct{}  // 不为 nil 时，写入前阻塞等待通道关闭
+	}
+
+	// testReader 第一次读取返回 event，之后阻塞到 reader 关闭
+	testReader struct {
+		readers.ReaderBase
+		event     *types.BinlogParams
+		read      int32
+		completed int32
+	}
+)
+
+func (t *testWriter) Insert(params *types.SyncParams, _ interface{}) error {
+	if t.block != nil {
+		<-t.block
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	primaryKey := params.Data[params.Rule.PrimaryKey]
+	if t.failures[primaryKey] > 0 {
+		t.failures[primaryKey]--
+		return errors.Errorf("write %s failed", primaryKey)
+	}
+	t.written[primaryKey]++
+
+	return nil
+}
+
+func (t *testWriter) Update(params *types.SyncParams, values interface{}) error {
+	return t.Insert(params, values)
+}
+
+func (t *testWriter) Delete(*types.SyncParams) error {
+	return nil
+}
+
+func (t *testWriter) GetDataSource() datasources.DataSource {
+	return datasources.NewDataSourceBase(nil, nil)
+}
+
+func (t *testWriter) getWritten(primaryKey string) int {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	return t.written[primaryKey]
+}
+
+func (r *testReader) Read() (*types.BinlogParams, error) {
+	if atomic.AddInt32(&r.read, 1) == 1 {
+		return r.event, nil
+	}
+	<-r.GetCtx().Done()
+
+	return nil, io.EOF
+}
+
+func (r *testReader) Complete(*types.BinlogParams) error {
+	atomic.AddInt32(&r.completed, 1)
+	return nil
+}
+
+func (r *testReader) Close() error {
+	r.FirstClose()
+	return nil
+}
+
+func newTestWorker(t *testing.T, poolSize int, dlq deadletters.Queue) (*worker, *testWriter) {
+	tw := &testWriter{written: make(map[string]int), failures: make(map[string]int)}
+	writers.SetWriterConstructor(testTargetType, func(datasources.DataSource) writers.Writer {
+		return tw
+	})
+	datasources.SetDataSourceConstructor(testTargetType, tw.GetDataSource)
+
+	redisCli := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	w, err := newWorker(context.Background(), redisCli, &configs.SyncConfig{PoolSize: poolSize}, dlq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.setDataSourceConfigs(map[string]map[string]datasources.DataSourceConfig{
+		testTargetType: {},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	w.setRules(map[string][]*types.SyncRule{
+		"test_orders": {{
+			Database: "test", Table: "orders", PrimaryKey: "id", LockColumns: []string{"id"},
+			Columns: map[string]string{"id": "id"}, Target: "test", TargetType: testTargetType,
+			TargetTable: "orders", SyncType: types.SyncTypeCopy,
+		}},
+	})
+
+	return &w, tw
+}
+
+func newTestEvent(ids ...string) *types.BinlogParams {
+	event := &types.BinlogParams{
+		EventId: "1", Database: "test", Table: "orders", EventType: types.EventTypeInsert,
+		EventAt: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	for _, id := range ids {
+		event.Data = append(event.Data, map[string]string{"id": id})
+	}
+
+	return event
+}
+
+func TestWorker_submitToPoolExec(t *testing.T) {
+	w, tw := newTestWorker(t, 5, nil)
+	defer w.h.Release()
+
+	if err := w.submitToPoolExec(newTestEvent("1", "2", "3")); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if tw.getWritten(id) != 1 {
+			t.Fatalf("record %s should be written once, current: %d", id, tw.getWritten(id))
+		}
+	}
+
+	// 没有规则的事件直接成功
+	if err := w.submitToPoolExec(&types.BinlogParams{Database: "test", Table: "users"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分表通过通配规则匹配
+	rule := &types.SyncRule{
+		Database: "test", Table: "orders_*", PrimaryKey: "id", LockColumns: []string{"id"},
+		Columns: map[string]string{"id": "id"}, Target: "test", TargetType: testTargetType,
+		TargetTable: "orders_$1", SyncType: types.SyncTypeCopy,
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	w.setRules(map[string][]*types.SyncRule{rule.GetRuleKey(): {rule}})
+	event := newTestEvent("4")
+	event.Table = "orders_01"
+	if err := w.submitToPoolExec(event); err != nil {
+		t.Fatal(err)
+	}
+	if tw.getWritten("4") != 1 {
+		t.Fatalf("sharded table record should be written once, current: %d", tw.getWritten("4"))
+	}
+}
+
+func TestWorker_submitToPoolExec_PartialFailure(t *testing.T) {
+	w, tw := newTestWorker(t, 5, nil)
+	defer w.h.Release()
+
+	tw.failures["2"] = 1
+	if err := w.submitToPoolExec(newTestEvent("1", "2", "3")); err == nil {
+		t.Fatal("partial failure should return error")
+	}
+	if tw.getWritten("1") != 1 || tw.getWritten("2") != 0 || tw.getWritten("3") != 1 {
+		t.Fatalf("only failed record should not be written: %v", tw.written)
+	}
+
+	// 开启死信队列后，写入死信队列的任务视为已处理
+	conf := new(deadletters.Config)
+	conf.File.Path = filepath.Join(t.TempDir(), "dead_letters.jsonl")
+	dlq, err := deadletters.NewFileQueue(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, tw = newTestWorker(t, 5, dlq)
+	defer w.h.Release()
+
+	tw.failures["2"] = 1
+	if err := w.submitToPoolExec(newTestEvent("1", "2", "3")); err != nil {
+		t.Fatalf("dead lettered failure should not return error: %v", err)
+	}
+
+	var letters []*deadletters.DeadLetter
+	if err := dlq.Replay(context.Background(), func(letter *deadletters.DeadLetter) error {
+		letters = append(letters, letter)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].Data["id"] != "2" || letters[0].RuleKey != "test_orders" {
+		t.Fatalf("failed record should be dead lettered: %v", letters)
+	}
+}
+
+func TestWorker_submitToPoolExec_PoolFull(t *testing.T) {
+	w, tw := newTestWorker(t, 1, nil)
+	defer w.h.Release()
+
+	tw.block = make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- w.submitToPoolExec(newTestEvent("1", "2", "3"))
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("submit should be blocked while pool is full, err: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(tw.block)
+	if err := <-done; err != nil {
+		t.Fatalf("tasks should not be dropped when pool is full: %v", err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if tw.getWritten(id) != 1 {
+			t.Fatalf("record %s should be written once, current: %d", id, tw.getWritten(id))
+		}
+	}
+}
+
+func TestWorker_listen(t *testing.T) {
+	w, tw := newTestWorker(t, 5, nil)
+	tw.failures["1"] = 1
+	reader := &testReader{
+		ReaderBase: readers.NewReaderBase(new(readers.HttpReaderConfig), context.Background()),
+		event:      newTestEvent("1", "2"),
+	}
+	w.runner.RunWorker(w.listen(reader))
+	defer func() {
+		_ = reader.Close()
+		w.runner.Stop()
+		_ = w.release()
+	}()
+
+	// 第一次提交失败不能确认，重新提交成功后才确认
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(&reader.completed) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if completed := atomic.LoadInt32(&reader.completed); completed != 1 {
+		t.Fatalf("event should be completed once, current: %d", completed)
+	}
+	if tw.getWritten("1") != 1 || tw.getWritten("2") < 1 {
+		t.Fatalf("all records should be written: %v", tw.written)
+	}
+}
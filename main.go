@@ -2,15 +2,22 @@ package main
 
 import (
 	"flag"
+	"github.com/Junjiayy/hamal/internal/admin"
 	"github.com/Junjiayy/hamal/internal/core"
 	"github.com/Junjiayy/hamal/pkg/configs"
 	"github.com/Junjiayy/hamal/pkg/tools"
 	"io/ioutil"
+	"os"
 )
 
 var filePath = flag.String("f", "config.yaml", "Specify the config file")
 
 func main() {
+	// 管理命令: hamal rules push rules.json
+	if len(os.Args) > 1 && admin.IsCommand(os.Args[1]) {
+		admin.Main(os.Args[1:])
+		return
+	}
 	flag.Parse()
 
 	content, err := ioutil.ReadFile(*filePath)
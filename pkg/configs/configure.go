@@ -1,8 +1,26 @@
 package configs
 
+import (
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/core/deadletters"
+	"github.com/Junjiayy/hamal/pkg/core/readers"
+	"github.com/Junjiayy/hamal/pkg/metrics"
+	"github.com/Junjiayy/hamal/pkg/tools"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const ModeCluster = "cluster"       // 集群模式，通过 zookeeper 分配任务和下发配置
+const ModeStandalone = "standalone" // 单机模式，数据源、读取器、同步规则全部从配置文件加载
+
 type (
 	SyncConfig struct {
-		PoolSize    int `json:"pool_size,omitempty" yaml:"pool_size,omitempty" default:"50"` // 协程池大小
+		PoolSize    int    `json:"pool_size,omitempty" yaml:"pool_size,omitempty" default:"50"` // 协程池大小
+		Mode        string `json:"mode,omitempty" yaml:"mode,omitempty" default:"cluster"`      // 运行模式 cluster|standalone
 		RedisConfig struct {
 			Addr     string `json:"addr" yaml:"addr"`
 			Password string `json:"password,omitempty" yaml:"password,omitempty"`
@@ -13,6 +31,154 @@ type (
 			Hosts    []string `json:"hosts" yaml:"hosts"`
 			Username string   `json:"username" yaml:"username"`
 			Password string   `json:"password" yaml:"password"`
-		}
+		} `json:"zookeeper" yaml:"zookeeper"`
+
+		Retry      RetryConfig        `json:"retry,omitempty" yaml:"retry,omitempty"`             // 写入失败重试配置
+		DeadLetter deadletters.Config `json:"dead_letter,omitempty" yaml:"dead_letter,omitempty"` // 死信队列配置
+		Metrics    metrics.Config     `json:"metrics,omitempty" yaml:"metrics,omitempty"`         // 监控指标配置
+
+		// 以下配置只在单机模式下生效，集群模式从 zookeeper 获取
+		DataSources []datasources.DataSourceConfig `json:"datasources,omitempty" yaml:"datasources,omitempty"` // 数据源配置
+		Readers     []ReaderConfig                 `json:"readers,omitempty" yaml:"readers,omitempty"`         // 读取器配置
+		Rules       map[string][]*types.SyncRule   `json:"rules,omitempty" yaml:"rules,omitempty"`             // 同步规则 key 为 database_table
+	}
+
+	// RetryPolicy 重试策略，重试间隔按指数增长，并增加随机抖动
+	RetryPolicy struct {
+		MaxAttempts    int           `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty" default:"3"`           // 最大尝试次数 包含第一次执行
+		InitialBackoff time.Duration `json:"initial_backoff,omitempty" yaml:"initial_backoff,omitempty" default:"100ms"` // 第一次重试的间隔
+		MaxBackoff     time.Duration `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty" default:"2s"`            // 最大重试间隔
+		Multiplier     float64       `json:"multiplier,omitempty" yaml:"multiplier,omitempty" default:"2"`               // 重试间隔增长倍数
+		Jitter         float64       `json:"jitter,omitempty" yaml:"jitter,omitempty" default:"0.2"`                     // 随机抖动比例 0-1
+	}
+
+	// RetryConfig 重试配置 目标类型未配置的字段使用默认策略
+	RetryConfig struct {
+		Default RetryPolicy            `json:"default,omitempty" yaml:"default,omitempty"`
+		Targets map[string]RetryPolicy `json:"targets,omitempty" yaml:"targets,omitempty"` // key 为目标类型 mysql|es
+	}
+
+	// ReaderConfig 配置文件中的读取器配置
+	// yaml 反序列化时通过 name 获取具体的配置类型，再进行实例化
+	ReaderConfig struct {
+		Name   string      `json:"name" yaml:"name"`     // 读取器类型 web|kafka|binlog
+		Params interface{} `json:"params" yaml:"params"` // 读取器具体配置 readers.ReaderConfig
+	}
+
+	innerReaderConfig struct {
+		Name   string      `yaml:"name"`
+		Params interface{} `yaml:"params"`
 	}
 )
+
+// UnmarshalYAML 根据 name 获取到具体的 config 结构体，并重新序列化赋值
+func (r *ReaderConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var innerConfig innerReaderConfig
+	if err := unmarshal(&innerConfig); err != nil {
+		return err
+	}
+	configConstructor := readers.GetReaderConfigConstructor(innerConfig.Name)
+	if configConstructor == nil {
+		return errors.Errorf("reader config constructor not exists: %s", innerConfig.Name)
+	}
+	config := configConstructor()
+	configBytes, err := yaml.Marshal(innerConfig.Params)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := tools.UnmarshalYamlAndBuildDefault(configBytes, config); err != nil {
+		return err
+	}
+	r.Name, r.Params = innerConfig.Name, config
+
+	return nil
+}
+
+// GetPolicy 获取目标类型的重试策略，未配置的字段使用默认策略
+func (r *RetryConfig) GetPolicy(targetType string) RetryPolicy {
+	policy, ok := r.Targets[targetType]
+	if !ok {
+		return r.Default
+	}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = r.Default.MaxAttempts
+	}
+	if policy.InitialBackoff == 0 {
+		policy.InitialBackoff = r.Default.InitialBackoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = r.Default.MaxBackoff
+	}
+	if policy.Multiplier == 0 {
+		policy.Multiplier = r.Default.Multiplier
+	}
+	if policy.Jitter == 0 {
+		policy.Jitter = r.Default.Jitter
+	}
+
+	return policy
+}
+
+// Backoff 获取第 attempt 次执行失败后的重试间隔
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if maxBackoff := float64(p.MaxBackoff); p.MaxBackoff > 0 && backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	if p.Jitter > 0 {
+		// 在 [1-jitter, 1+jitter] 范围内随机，防止大量任务同时重试
+		backoff *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+
+	return time.Duration(backoff)
+}
+
+// MaxDuration 获取全部重试间隔的最大总时长
+func (p *RetryPolicy) MaxDuration() time.Duration {
+	var total float64
+	for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+		backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+		if maxBackoff := float64(p.MaxBackoff); p.MaxBackoff > 0 && backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		total += backoff * (1 + p.Jitter)
+	}
+
+	return time.Duration(total)
+}
+
+// GetReaderConfigs 获取读取器配置，key 为读取器配置唯一id
+func (s *SyncConfig) GetReaderConfigs() (map[string]readers.ReaderConfigByType, error) {
+	configs := make(map[string]readers.ReaderConfigByType, len(s.Readers))
+	for _, reader := range s.Readers {
+		config, ok := reader.Params.(readers.ReaderConfig)
+		if !ok {
+			return nil, errors.Errorf("reader %s params is not a valid reader config", reader.Name)
+		}
+		uniqueId := config.GetUniqueId()
+		if _, ok := configs[uniqueId]; ok {
+			return nil, errors.Errorf("reader %s duplicated, unique id: %s", reader.Name, uniqueId)
+		}
+		configs[uniqueId] = readers.ReaderConfigByType{Type: reader.Name, Config: config}
+	}
+
+	return configs, nil
+}
+
+// GetDataSourceConfigsByType 按数据源类型对数据源配置进行分组
+func (s *SyncConfig) GetDataSourceConfigsByType() (map[string]map[string]datasources.DataSourceConfig, error) {
+	configsByType := make(map[string]map[string]datasources.DataSourceConfig)
+	for _, config := range s.DataSources {
+		configs, ok := configsByType[config.Type]
+		if !ok {
+			configs = make(map[string]datasources.DataSourceConfig)
+			configsByType[config.Type] = configs
+		}
+		if _, ok := configs[config.Name]; ok {
+			return nil, errors.Errorf("datasource %s:%s duplicated", config.Type, config.Name)
+		}
+		configs[config.Name] = config
+	}
+
+	return configsByType, nil
+}
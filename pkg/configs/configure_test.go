@@ -2,12 +2,15 @@ package configs
 
 import (
 	"github.com/Junjiayy/hamal/pkg/core/readers"
+	"github.com/Junjiayy/hamal/pkg/tools"
+	"github.com/Junjiayy/hamal/pkg/types"
 	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"testing"
 )
 
 func TestReaderConfig_UnmarshalYAML(t *testing.T) {
-	readers.RegisterReaderConfigConstructor("web", readers.NewHttpReaderConfigFunc)
+	readers.SetReaderConfigConstructor("web", readers.NewHttpReaderConfigFunc)
 	yamlData := `
 name: "web"
 params:
@@ -27,3 +30,44 @@ params:
 		t.Fatal("ReaderConfig.Params Not a readers.HttpReaderConfig instance")
 	}
 }
+
+func TestSyncConfig_Standalone(t *testing.T) {
+	content, err := ioutil.ReadFile("../../config.yaml.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var conf SyncConfig
+	if err := tools.UnmarshalYamlAndBuildDefault(content, &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Mode != ModeStandalone {
+		t.Fatalf("mode should be %s, current: %s", ModeStandalone, conf.Mode)
+	}
+
+	dbConfigsByType, err := conf.GetDataSourceConfigsByType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dbConfigsByType[types.DataSourceMysql]["test"]; !ok {
+		t.Fatalf("mysql datasource test not exists: %v", dbConfigsByType)
+	}
+
+	readerConfigs, err := conf.GetReaderConfigs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readerConfigs) != 1 {
+		t.Fatalf("reader configs length should be 1, current: %d", len(readerConfigs))
+	}
+
+	rules := conf.Rules["sync_tests_orders"]
+	if len(rules) != 1 || rules[0].TargetType != types.DataSourceMysql || rules[0].TargetTable != "user_trans_records" {
+		t.Fatalf("rules load failed: %v", conf.Rules)
+	}
+	if condition := rules[0].DataConditions[types.ConditionTypeAnd]; len(condition) != 2 || condition[0].Value != "5000" ||
+		condition[0].Type != types.ConditionValueTypeInt || len(condition[1].Values) != 2 {
+		t.Fatalf("rule data conditions load failed: %v", rules[0].DataConditions)
+	}
+}
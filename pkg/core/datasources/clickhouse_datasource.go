@@ -0,0 +1,124 @@
+package datasources
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	clickHouseDefaultBatchSize     = 1000                   // 默认每批最大记录数
+	clickHouseDefaultFlushInterval = 200 * time.Millisecond // 默认每批最长等待时间
+	clickHouseRequestTimeout       = 10 * time.Second       // 单次写入请求超时时间
+)
+
+type (
+	ClickHouseDataSource struct {
+		*DataSourceBase
+	}
+
+	// ClickHouseClient 通过 http 接口写入 clickhouse
+	// 使用 JSONEachRow 格式，字符串形式的数字、时间由 clickhouse 按字段类型解析
+	ClickHouseClient struct {
+		cli           *http.Client
+		endpoint      string
+		database      string
+		username      string
+		password      string
+		BatchSize     int
+		FlushInterval time.Duration
+	}
+
+	// ClickHouseError clickhouse http 接口返回的错误
+	ClickHouseError struct {
+		StatusCode int
+		Message    string
+	}
+)
+
+func NewClickHouseDataSource() DataSource {
+	return &ClickHouseDataSource{
+		NewDataSourceBase(newClickHouseConnectFunc, closeClickHouseConnectFunc),
+	}
+}
+
+// newClickHouseConnectFunc 通过配置创建 clickhouse 客户端函数
+func newClickHouseConnectFunc(conf DataSourceConfig) (interface{}, error) {
+	cli := &ClickHouseClient{
+		cli:      &http.Client{Timeout: clickHouseRequestTimeout},
+		endpoint: fmt.Sprintf("http://%s:%d/", conf.Host, conf.Port),
+		database: conf.Target, username: conf.Username, password: conf.Password,
+		BatchSize: conf.BatchSize, FlushInterval: conf.FlushInterval,
+	}
+	if cli.BatchSize <= 0 {
+		cli.BatchSize = clickHouseDefaultBatchSize
+	}
+	if cli.FlushInterval <= 0 {
+		cli.FlushInterval = clickHouseDefaultFlushInterval
+	}
+
+	return cli, nil
+}
+
+// closeClickHouseConnectFunc 关闭 clickhouse 客户端函数
+func closeClickHouseConnectFunc(cli interface{}) error {
+	cli.(*ClickHouseClient).cli.CloseIdleConnections()
+	return nil
+}
+
+// Insert 批量写入记录，一批记录在 clickhouse 中作为一个数据块写入
+// database 为空时写入配置中的目标库
+func (c *ClickHouseClient) Insert(ctx context.Context, database, table string, rows []map[string]interface{}) error {
+	body := new(bytes.Buffer)
+	encoder := json.NewEncoder(body)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	table = quoteClickHouseIdentifier(table)
+	if database != "" {
+		table = quoteClickHouseIdentifier(database) + "." + table
+	}
+	query := url.Values{"query": {fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table)}}
+	if c.database != "" {
+		query.Set("database", c.database)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"?"+query.Encode(), body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if c.username != "" {
+		req.Header.Set("X-ClickHouse-User", c.username)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return errors.WithStack(&ClickHouseError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))})
+	}
+
+	return nil
+}
+
+func (e *ClickHouseError) Error() string {
+	return fmt.Sprintf("clickhouse: status %d: %s", e.StatusCode, e.Message)
+}
+
+// quoteClickHouseIdentifier 使用反引号转义标识符
+func quoteClickHouseIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
@@ -7,22 +7,37 @@ import (
 	"go.uber.org/zap"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type (
 	DataSourceConfig struct {
 		Name     string `json:"name" yaml:"name"`                             // 连接名称 唯一
-		Type     string `json:"type" yaml:"type"`                             // 配置类型 mysql es
+		Type     string `json:"type" yaml:"type"`                             // 配置类型 mysql es postgres
 		Host     string `json:"host" yaml:"host"`                             // ip
 		Port     int    `json:"port" yaml:"port"`                             // 端口
 		Username string `json:"username,omitempty" yaml:"username,omitempty"` // 账户 可为空
 		Password string `json:"password,omitempty" yaml:"password,omitempty"` // 密码 可为空
-		Target   string `json:"target,omitempty" yaml:"target,omitempty"`     // type 为mysql时未目标库 es时为空
+		Target   string `json:"target,omitempty" yaml:"target,omitempty"`     // type 为mysql|postgres|mongodb时为目标库 redis时为db es时为空
 		Debug    bool   `json:"debug,omitempty" yaml:"debug,omitempty"`
+
+		// 批量写入配置，只对支持批量写入的数据源生效，满足其中一个条件就执行写入
+		BatchSize     int           `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`         // 每批最大记录数
+		FlushInterval time.Duration `json:"flush_interval,omitempty" yaml:"flush_interval,omitempty"` // 每批最长等待时间
+
+		TTL time.Duration `json:"ttl,omitempty" yaml:"ttl,omitempty"` // 写入记录的过期时间 只对缓存类数据源生效 为 0 不过期
+
+		Timeout time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"` // 请求超时时间 webhook|es
+
+		// http 类数据源配置
+		URL     string            `json:"url,omitempty" yaml:"url,omitempty"`         // 基础地址
+		Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"` // 每个请求附加的请求头
+		Secret  string            `json:"secret,omitempty" yaml:"secret,omitempty"`   // 请求体签名密钥 为空不签名
 	}
 
 	DataSource interface {
 		GetDataSource(name string) (interface{}, error)
+		GetConfig(name string) (DataSourceConfig, error)
 		SetConfigs(configs map[string]DataSourceConfig) error
 		Close() error
 	}
@@ -33,6 +48,12 @@ var _datasourceConstructors = make(map[string]func() DataSource) // 数据源构
 func init() {
 	SetDataSourceConstructor(types.DataSourceMysql, NewMysqlDataSource)
 	SetDataSourceConstructor(types.DataSourceElasticSearch, NewElasticSearchDataSource)
+	SetDataSourceConstructor(types.DataSourcePostgres, NewPostgresDataSource)
+	SetDataSourceConstructor(types.DataSourceClickHouse, NewClickHouseDataSource)
+	SetDataSourceConstructor(types.DataSourceRedis, NewRedisDataSource)
+	SetDataSourceConstructor(types.DataSourceKafka, NewKafkaDataSource)
+	SetDataSourceConstructor(types.DataSourceWebhook, NewWebhookDataSource)
+	SetDataSourceConstructor(types.DataSourceMongo, NewMongoDataSource)
 }
 
 // SetDataSourceConstructor 设置数据源构造函数
@@ -127,6 +148,19 @@ func (d *DataSourceBase) GetDataSource(name string) (interface{}, error) {
 	return conn, nil
 }
 
+// GetConfig 获取连接配置
+func (d *DataSourceBase) GetConfig(name string) (DataSourceConfig, error) {
+	d.configRwMux.RLock()
+	defer d.configRwMux.RUnlock()
+
+	conf, ok := d.configs[name]
+	if !ok {
+		return DataSourceConfig{}, errors.Errorf("get not exists connect %s", name)
+	}
+
+	return conf, nil
+}
+
 // Close 关闭当前数据源的所有连接
 func (d *DataSourceBase) Close() error {
 	d.configRwMux.Lock()
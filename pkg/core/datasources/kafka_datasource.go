@@ -0,0 +1,52 @@
+package datasources
+
+import (
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"strings"
+	"time"
+)
+
+const kafkaDefaultBatchTimeout = 10 * time.Millisecond // 默认批量发送等待时间，写入是同步的，不使用 kafka-go 默认的 1s
+
+type KafkaDataSource struct {
+	*DataSourceBase
+}
+
+func NewKafkaDataSource() DataSource {
+	return &KafkaDataSource{
+		NewDataSourceBase(newKafkaConnectFunc, closeKafkaConnectFunc),
+	}
+}
+
+// newKafkaConnectFunc 通过配置创建 kafka 生产者函数
+// host 支持逗号分隔的多个 broker，未指定端口的 broker 使用 port
+// 不指定 topic，发送时由消息指定
+func newKafkaConnectFunc(conf DataSourceConfig) (interface{}, error) {
+	var brokers []string
+	for _, host := range strings.Split(conf.Host, ",") {
+		if host = strings.TrimSpace(host); !strings.Contains(host, ":") {
+			host = fmt.Sprintf("%s:%d", host, conf.Port)
+		}
+		brokers = append(brokers, host)
+	}
+
+	kw := &kafka.Writer{
+		Addr: kafka.TCP(brokers...), Balancer: &kafka.Hash{}, RequiredAcks: kafka.RequireAll,
+		BatchSize: conf.BatchSize, BatchTimeout: conf.FlushInterval,
+	}
+	if kw.BatchTimeout <= 0 {
+		kw.BatchTimeout = kafkaDefaultBatchTimeout
+	}
+	if conf.Username != "" && conf.Password != "" {
+		kw.Transport = &kafka.Transport{SASL: plain.Mechanism{Username: conf.Username, Password: conf.Password}}
+	}
+
+	return kw, nil
+}
+
+// closeKafkaConnectFunc 关闭 kafka 生产者函数
+func closeKafkaConnectFunc(cli interface{}) error {
+	return cli.(*kafka.Writer).Close()
+}
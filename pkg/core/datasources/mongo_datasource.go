@@ -0,0 +1,47 @@
+package datasources
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const mongoConnectTimeout = 5 * time.Second // 连接超时时间
+
+type MongoDataSource struct {
+	*DataSourceBase
+}
+
+func NewMongoDataSource() DataSource {
+	return &MongoDataSource{
+		NewDataSourceBase(newMongoConnectFunc, closeMongoConnectFunc),
+	}
+}
+
+// newMongoConnectFunc 通过配置创建 mongodb 连接函数，返回 target 对应的 *mongo.Database
+func newMongoConnectFunc(conf DataSourceConfig) (interface{}, error) {
+	clientOptions := options.Client().ApplyURI(fmt.Sprintf("mongodb://%s:%d", conf.Host, conf.Port)).
+		SetConnectTimeout(mongoConnectTimeout)
+	if conf.Username != "" && conf.Password != "" {
+		clientOptions.SetAuth(options.Credential{Username: conf.Username, Password: conf.Password})
+	}
+
+	timeout, cancelFunc := context.WithTimeout(context.Background(), mongoConnectTimeout)
+	defer cancelFunc()
+	cli, err := mongo.Connect(timeout, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return cli.Database(conf.Target), nil
+}
+
+// closeMongoConnectFunc 关闭 mongodb 连接函数
+func closeMongoConnectFunc(cli interface{}) error {
+	timeout, cancelFunc := context.WithTimeout(context.Background(), mongoConnectTimeout)
+	defer cancelFunc()
+
+	return cli.(*mongo.Database).Client().Disconnect(timeout)
+}
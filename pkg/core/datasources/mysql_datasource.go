@@ -19,7 +19,8 @@ func NewMysqlDataSource() DataSource {
 
 // newMysqlConnectFunc 通过配置创建 mysql 连接函数
 func newMysqlConnectFunc(conf DataSourceConfig) (interface{}, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
+	// clientFoundRows 使更新的影响行数为匹配的行数，数据没有变化的更新不会被当作记录不存在
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local&clientFoundRows=true",
 		conf.Username, conf.Password, conf.Host, conf.Port, conf.Target)
 	connectConfig := &gorm.Config{Logger: logger.Default.LogMode(logger.Info)}
 	if conf.Debug {
@@ -0,0 +1,40 @@
+package datasources
+
+import (
+	"fmt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type PostgresDataSource struct {
+	*DataSourceBase
+}
+
+func NewPostgresDataSource() DataSource {
+	return &PostgresDataSource{
+		NewDataSourceBase(newPostgresConnectFunc, closePostgresConnectFunc),
+	}
+}
+
+// newPostgresConnectFunc 通过配置创建 postgres 连接函数
+func newPostgresConnectFunc(conf DataSourceConfig) (interface{}, error) {
+	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable TimeZone=Local",
+		conf.Host, conf.Port, conf.Username, conf.Password, conf.Target)
+	connectConfig := &gorm.Config{Logger: logger.Default.LogMode(logger.Info)}
+	if conf.Debug {
+		connectConfig.Logger = logger.Default.LogMode(logger.Warn)
+	}
+
+	return gorm.Open(postgres.Open(dsn), connectConfig)
+}
+
+// closePostgresConnectFunc 关闭 postgres 连接函数
+func closePostgresConnectFunc(cli interface{}) error {
+	sqlDb, err := cli.(*gorm.DB).DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDb.Close()
+}
@@ -0,0 +1,51 @@
+package datasources
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"strconv"
+	"time"
+)
+
+type (
+	RedisDataSource struct {
+		*DataSourceBase
+	}
+
+	// RedisClient redis 客户端 和 写入记录的过期时间
+	RedisClient struct {
+		*redis.Client
+		TTL time.Duration
+	}
+)
+
+func NewRedisDataSource() DataSource {
+	return &RedisDataSource{
+		NewDataSourceBase(newRedisConnectFunc, closeRedisConnectFunc),
+	}
+}
+
+// newRedisConnectFunc 通过配置创建 redis 客户端函数，target 为 db 编号
+func newRedisConnectFunc(conf DataSourceConfig) (interface{}, error) {
+	var db int
+	if conf.Target != "" {
+		var err error
+		if db, err = strconv.Atoi(conf.Target); err != nil {
+			return nil, errors.Errorf("redis target must be db number, current: %s", conf.Target)
+		}
+	}
+
+	return &RedisClient{
+		Client: redis.NewClient(&redis.Options{
+			Addr: fmt.Sprintf("%s:%d", conf.Host, conf.Port), DB: db,
+			Username: conf.Username, Password: conf.Password,
+		}),
+		TTL: conf.TTL,
+	}, nil
+}
+
+// closeRedisConnectFunc 关闭 redis 客户端函数
+func closeRedisConnectFunc(cli interface{}) error {
+	return cli.(*RedisClient).Close()
+}
@@ -0,0 +1,110 @@
+package datasources
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	webhookDefaultTimeout  = 3 * time.Second     // 默认请求超时时间
+	WebhookSignatureHeader = "X-Hamal-Signature" // 签名请求头 格式 sha256=hex(hmac_sha256(secret, body))
+)
+
+type (
+	WebhookDataSource struct {
+		*DataSourceBase
+	}
+
+	// WebhookClient 通过 http 回调推送变更
+	WebhookClient struct {
+		cli     *http.Client
+		baseURL string
+		headers map[string]string
+		secret  string
+	}
+
+	// WebhookError 回调返回非 2xx 状态码
+	WebhookError struct {
+		StatusCode int
+		Body       string
+	}
+)
+
+func NewWebhookDataSource() DataSource {
+	return &WebhookDataSource{
+		NewDataSourceBase(newWebhookConnectFunc, closeWebhookConnectFunc),
+	}
+}
+
+// newWebhookConnectFunc 通过配置创建回调客户端函数
+func newWebhookConnectFunc(conf DataSourceConfig) (interface{}, error) {
+	if conf.URL == "" {
+		return nil, errors.Errorf("webhook %s url is required", conf.Name)
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = webhookDefaultTimeout
+	}
+
+	return &WebhookClient{
+		cli:     &http.Client{Timeout: timeout},
+		baseURL: strings.TrimRight(conf.URL, "/"),
+		headers: conf.Headers, secret: conf.Secret,
+	}, nil
+}
+
+// closeWebhookConnectFunc 关闭回调客户端函数
+func closeWebhookConnectFunc(cli interface{}) error {
+	cli.(*WebhookClient).cli.CloseIdleConnections()
+	return nil
+}
+
+// Post 发送 json 请求体到 baseURL/path，配置了密钥时附加签名请求头
+func (c *WebhookClient) Post(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/"+strings.TrimLeft(path, "/"),
+		bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookBody(c.secret, body))
+	}
+
+	resp, err := c.cli.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return errors.WithStack(&WebhookError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(respBody))})
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+// SignWebhookBody 计算请求体签名，接收方使用相同的密钥校验
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *WebhookError) Error() string {
+	return fmt.Sprintf("webhook: status %d: %s", e.StatusCode, e.Body)
+}
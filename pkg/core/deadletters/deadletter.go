@@ -0,0 +1,96 @@
+package deadletters
+
+import (
+	"context"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type (
+	// DeadLetter 同步失败的任务，记录重放需要的全部信息
+	DeadLetter struct {
+		BinlogParams  *types.BinlogParams `json:"binlog_params"`       // 原始 binlog 数据
+		RuleKey       string              `json:"rule_key"`            // 规则key database_table
+		Target        string              `json:"target"`              // 规则目标 type:connect(.db).table，用于在同一个规则key下找到对应规则
+		RealEventType string              `json:"real_event_type"`     // 真实执行同步的事件类型
+		Data          map[string]string   `json:"data"`                // 同步失败的记录
+		Old           map[string]string   `json:"old,omitempty"`       // 同步失败的记录 更新前数据
+		Nulls         map[string]bool     `json:"nulls,omitempty"`     // 同步失败的记录 值为 null 的字段
+		OldNulls      map[string]bool     `json:"old_nulls,omitempty"` // 同步失败的记录 更新前值为 null 的字段
+		Error         string              `json:"error"`               // 失败原因
+		FailedAt      int64               `json:"failed_at"`           // 失败时间 毫秒
+	}
+
+	// Queue 死信队列
+	Queue interface {
+		Push(letter *DeadLetter) error
+		// Replay 按写入顺序读取死信交给 fn 处理，fn 返回 nil 时确认并移除死信
+		// fn 返回错误时停止重放，未处理的死信保留在队列中
+		Replay(ctx context.Context, fn func(letter *DeadLetter) error) error
+		Close() error
+	}
+
+	// Config 死信队列配置 Type 为空时不开启死信队列
+	Config struct {
+		Type  string `json:"type,omitempty" yaml:"type,omitempty"` // 死信队列类型 kafka|redis|file
+		Kafka struct {
+			Brokers  []string `json:"brokers" yaml:"brokers"`
+			Topic    string   `json:"topic" yaml:"topic" default:"hamal-dead-letters"`
+			Group    string   `json:"group" yaml:"group" default:"hamal-dead-letters-replay"` // 重放时使用的消费组
+			Username string   `json:"username,omitempty" yaml:"username,omitempty"`
+			Password string   `json:"password,omitempty" yaml:"password,omitempty"`
+		} `json:"kafka,omitempty" yaml:"kafka,omitempty"`
+		Redis struct {
+			Stream string `json:"stream" yaml:"stream" default:"hamal:dead-letters"` // 使用全局 redis 连接
+			MaxLen int64  `json:"max_len" yaml:"max_len" default:"100000"`           // stream 最大长度，超过后丢弃最老的死信
+		} `json:"redis,omitempty" yaml:"redis,omitempty"`
+		File struct {
+			Path string `json:"path" yaml:"path" default:"dead_letters.jsonl"`
+		} `json:"file,omitempty" yaml:"file,omitempty"`
+	}
+
+	QueueConstructor func(conf *Config, redisCli *redis.Client) (Queue, error)
+)
+
+var _queueConstructors = make(map[string]QueueConstructor) // 死信队列构造函数 映射表
+
+func init() {
+	SetQueueConstructor(types.DeadLetterKafka, NewKafkaQueue)
+	SetQueueConstructor(types.DeadLetterRedis, NewRedisQueue)
+	SetQueueConstructor(types.DeadLetterFile, NewFileQueue)
+}
+
+// SetQueueConstructor 注册死信队列构造函数
+func SetQueueConstructor(name string, fn QueueConstructor) {
+	_queueConstructors[name] = fn
+}
+
+// GetQueueConstructor 获取死信队列构造函数
+func GetQueueConstructor(name string) QueueConstructor {
+	return _queueConstructors[name]
+}
+
+// NewQueue 根据配置创建死信队列，未配置类型时返回 nil
+func NewQueue(conf *Config, redisCli *redis.Client) (Queue, error) {
+	if conf.Type == "" {
+		return nil, nil
+	}
+	constructor := GetQueueConstructor(conf.Type)
+	if constructor == nil {
+		return nil, errors.Errorf("unsupported dead letter queue type: %s", conf.Type)
+	}
+
+	return constructor(conf, redisCli)
+}
+
+// NewDeadLetter 根据同步参数创建死信
+func NewDeadLetter(params *types.SyncParams, err error) *DeadLetter {
+	return &DeadLetter{
+		BinlogParams: params.GetBingLogParams(), RuleKey: params.Rule.GetRuleKey(),
+		Target: params.Rule.GetFullTarget(), RealEventType: params.RealEventType,
+		Data: params.Data, Old: params.Old, Nulls: params.Nulls, OldNulls: params.OldNulls, Error: err.Error(),
+		FailedAt: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
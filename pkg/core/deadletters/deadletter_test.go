@@ -0,0 +1,91 @@
+package deadletters
+
+import (
+	"context"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"path/filepath"
+	"testing"
+)
+
+func newTestLetters() []*DeadLetter {
+	letters := make([]*DeadLetter, 0, 3)
+	for _, id := range []string{"1", "2", "3"} {
+		letters = append(letters, &DeadLetter{
+			BinlogParams: &types.BinlogParams{EventId: id, Database: "test", Table: "orders",
+				EventType: types.EventTypeInsert, Data: []map[string]string{{"id": id}}},
+			RuleKey: "test_orders", Target: "es:test.orders", RealEventType: types.EventTypeInsert,
+			Data: map[string]string{"id": id}, Error: "timeout",
+		})
+	}
+
+	return letters
+}
+
+// testQueueReplay 写入三条死信，第二条重放失败时停止，再次重放时从第二条开始
+func testQueueReplay(t *testing.T, q Queue) {
+	for _, letter := range newTestLetters() {
+		if err := q.Push(letter); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replayed []string
+	failedErr := errors.New("failed")
+	err := q.Replay(context.Background(), func(letter *DeadLetter) error {
+		if letter.BinlogParams.EventId == "2" {
+			return failedErr
+		}
+		replayed = append(replayed, letter.Data["id"])
+		return nil
+	})
+	if !errors.Is(err, failedErr) || len(replayed) != 1 || replayed[0] != "1" {
+		t.Fatalf("replay should stop at second letter, replayed: %v, err: %v", replayed, err)
+	}
+
+	replayed = nil
+	err = q.Replay(context.Background(), func(letter *DeadLetter) error {
+		replayed = append(replayed, letter.Data["id"])
+		return nil
+	})
+	if err != nil || len(replayed) != 2 || replayed[0] != "2" || replayed[1] != "3" {
+		t.Fatalf("replay remaining letters failed, replayed: %v, err: %v", replayed, err)
+	}
+
+	err = q.Replay(context.Background(), func(letter *DeadLetter) error {
+		t.Fatalf("queue should be empty, letter: %+v", letter)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileQueue_Replay(t *testing.T) {
+	conf := new(Config)
+	conf.File.Path = filepath.Join(t.TempDir(), "dead_letters.jsonl")
+	q, err := NewFileQueue(conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	testQueueReplay(t, q)
+}
+
+func TestRedisQueue_Replay(t *testing.T) {
+	s := miniredis.RunT(t)
+	redisCli := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer redisCli.Close()
+
+	conf := new(Config)
+	conf.Redis.Stream, conf.Redis.MaxLen = "hamal:dead-letters", 100
+	q, err := NewRedisQueue(conf, redisCli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testQueueReplay(t, q)
+}
@@ -0,0 +1,133 @@
+package deadletters
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileQueue 本地 jsonl 文件死信队列，每行一条死信
+// notice: 只保证单进程内的并发安全，重放时不要让其他进程同时写入同一个文件
+type FileQueue struct {
+	path string
+	file *os.File
+	mux  *sync.Mutex
+}
+
+func NewFileQueue(conf *Config, _ *redis.Client) (Queue, error) {
+	q := &FileQueue{path: conf.File.Path, mux: new(sync.Mutex)}
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (f *FileQueue) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	f.file = file
+
+	return nil
+}
+
+func (f *FileQueue) Push(letter *DeadLetter) error {
+	content, err := json.Marshal(letter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	_, err = f.file.Write(append(content, '\n'))
+
+	return errors.WithStack(err)
+}
+
+func (f *FileQueue) Replay(ctx context.Context, fn func(letter *DeadLetter) error) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var (
+		lines     = bytes.Split(bytes.TrimRight(content, "\n"), []byte("\n"))
+		replayed  int
+		replayErr error
+	)
+	if len(content) == 0 {
+		lines = nil
+	}
+	for ; replayed < len(lines); replayed++ {
+		if replayErr = ctx.Err(); replayErr != nil {
+			break
+		}
+		letter := new(DeadLetter)
+		if replayErr = errors.WithStack(json.Unmarshal(lines[replayed], letter)); replayErr != nil {
+			break
+		}
+		if replayErr = fn(letter); replayErr != nil {
+			break
+		}
+	}
+
+	if replayed > 0 {
+		// 只保留未重放成功的死信
+		if err := f.rewrite(lines[replayed:]); err != nil {
+			return err
+		}
+	}
+
+	return replayErr
+}
+
+// rewrite 通过临时文件原子替换死信文件
+func (f *FileQueue) rewrite(lines [][]byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		_, _ = writer.Write(line)
+		_ = writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmp.Close()
+		return errors.WithStack(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := f.file.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		_ = f.open()
+		return errors.WithStack(err)
+	}
+
+	return f.open()
+}
+
+func (f *FileQueue) Close() error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	return errors.WithStack(f.file.Close())
+}
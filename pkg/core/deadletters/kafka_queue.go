@@ -0,0 +1,91 @@
+package deadletters
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"time"
+)
+
+// KafkaQueue kafka topic 死信队列，消息 key 为规则key
+// 重放时使用独立的消费组，处理成功后提交 offset
+type KafkaQueue struct {
+	kw     *kafka.Writer
+	conf   *Config
+	dialer *kafka.Dialer
+}
+
+const (
+	kafkaQueueTimeout    = 3 * time.Second
+	kafkaReplayIdleLimit = 5 * time.Second // 重放时超过这个时间没有读取到消息，认为死信已经全部处理
+)
+
+func NewKafkaQueue(conf *Config, _ *redis.Client) (Queue, error) {
+	if len(conf.Kafka.Brokers) == 0 {
+		return nil, errors.New("kafka dead letter queue requires brokers")
+	}
+
+	q := &KafkaQueue{conf: conf}
+	kw := &kafka.Writer{
+		Addr: kafka.TCP(conf.Kafka.Brokers...), Topic: conf.Kafka.Topic,
+		Balancer: &kafka.Hash{}, RequiredAcks: kafka.RequireAll,
+	}
+	if conf.Kafka.Username != "" && conf.Kafka.Password != "" {
+		mechanism := plain.Mechanism{Username: conf.Kafka.Username, Password: conf.Kafka.Password}
+		kw.Transport = &kafka.Transport{SASL: mechanism}
+		q.dialer = &kafka.Dialer{SASLMechanism: mechanism}
+	}
+	q.kw = kw
+
+	return q, nil
+}
+
+func (k *KafkaQueue) Push(letter *DeadLetter) error {
+	content, err := json.Marshal(letter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	timeout, cancelFunc := context.WithTimeout(context.Background(), kafkaQueueTimeout)
+	defer cancelFunc()
+
+	err = k.kw.WriteMessages(timeout, kafka.Message{Key: []byte(letter.RuleKey), Value: content})
+
+	return errors.WithStack(err)
+}
+
+func (k *KafkaQueue) Replay(ctx context.Context, fn func(letter *DeadLetter) error) error {
+	kr := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: k.conf.Kafka.Brokers, GroupID: k.conf.Kafka.Group,
+		Topic: k.conf.Kafka.Topic, Dialer: k.dialer,
+	})
+	defer kr.Close()
+
+	for {
+		fetchCtx, cancelFunc := context.WithTimeout(ctx, kafkaReplayIdleLimit)
+		message, err := kr.FetchMessage(fetchCtx)
+		cancelFunc()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+
+		letter := new(DeadLetter)
+		if err := json.Unmarshal(message.Value, letter); err != nil {
+			return errors.WithMessagef(err, "decode dead letter %d-%d failed", message.Partition, message.Offset)
+		}
+		if err := fn(letter); err != nil {
+			return err
+		}
+		if err := kr.CommitMessages(ctx, message); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+func (k *KafkaQueue) Close() error {
+	return errors.WithStack(k.kw.Close())
+}
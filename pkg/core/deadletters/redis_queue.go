@@ -0,0 +1,78 @@
+package deadletters
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// RedisQueue redis stream 死信队列，死信 json 保存在消息的 letter 字段中
+type RedisQueue struct {
+	cli    *redis.Client
+	stream string
+	maxLen int64
+}
+
+const (
+	redisLetterField  = "letter"
+	redisReplayCount  = 100 // 重放时每次读取的死信数量
+	redisQueueTimeout = time.Second
+)
+
+func NewRedisQueue(conf *Config, redisCli *redis.Client) (Queue, error) {
+	if redisCli == nil {
+		return nil, errors.New("redis dead letter queue requires redis client")
+	}
+
+	return &RedisQueue{cli: redisCli, stream: conf.Redis.Stream, maxLen: conf.Redis.MaxLen}, nil
+}
+
+func (r *RedisQueue) Push(letter *DeadLetter) error {
+	content, err := json.Marshal(letter)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	timeout, cancelFunc := context.WithTimeout(context.Background(), redisQueueTimeout)
+	defer cancelFunc()
+
+	err = r.cli.XAdd(timeout, &redis.XAddArgs{
+		Stream: r.stream, MaxLen: r.maxLen, Approx: true,
+		Values: map[string]interface{}{redisLetterField: content},
+	}).Err()
+
+	return errors.WithStack(err)
+}
+
+func (r *RedisQueue) Replay(ctx context.Context, fn func(letter *DeadLetter) error) error {
+	for {
+		// 处理成功的死信都会被删除，所以每次都从头读取
+		messages, err := r.cli.XRangeN(ctx, r.stream, "-", "+", redisReplayCount).Result()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		for _, message := range messages {
+			letter := new(DeadLetter)
+			content, _ := message.Values[redisLetterField].(string)
+			if err := json.Unmarshal([]byte(content), letter); err != nil {
+				return errors.WithMessagef(err, "decode dead letter %s failed", message.ID)
+			}
+			if err := fn(letter); err != nil {
+				return err
+			}
+			if err := r.cli.XDel(ctx, r.stream, message.ID).Err(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+}
+
+func (r *RedisQueue) Close() error {
+	// 使用全局 redis 连接，不需要关闭
+	return nil
+}
@@ -0,0 +1,587 @@
+package readers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Junjiayy/hamal/pkg/metrics"
+	"github.com/Junjiayy/hamal/pkg/tools"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// BinlogReader 作为 mysql 从库连接主库，直接解析 ROW 格式的 binlog 事件
+	BinlogReader struct {
+		ReaderBase
+		syncer    *replication.BinlogSyncer
+		streamer  *replication.BinlogStreamer
+		schemas   *binlogSchemas
+		store     *binlogPositionStore
+		current   binlogPosition // 当前读取到的位点
+		committed binlogPosition // 最后一个已提交事务结束的位点，也是下一个事务的开始位点
+		inTxn     bool
+		pending   *replication.BinlogEvent // 解析失败的行变更事件，重试成功之前不读取后续事件，位点不会越过它
+		emitted   uint64                   // 已经返回给调用方的事件数量
+		completed uint64                   // 调用方已经确认完成的事件数量
+	}
+
+	// binlogPosition binlog 位点，GTID 不为空时优先使用 GTID 同步
+	binlogPosition struct {
+		Name string `json:"name"`
+		Pos  uint32 `json:"pos"`
+		GTID string `json:"gtid,omitempty"`
+	}
+
+	// binlogPositionStore 本地文件位点存储，Complete 成功代表位点已经落盘
+	binlogPositionStore struct {
+		path  string
+		saved binlogPosition
+	}
+
+	// binlogSchemas 表字段名缓存
+	// binlog_row_metadata=FULL 时直接使用 TableMapEvent 中的字段名，否则从 information_schema 查询
+	binlogSchemas struct {
+		conf    *BinlogReaderConfig
+		conn    *client.Conn
+		columns map[string][]string
+		mux     sync.Mutex
+	}
+)
+
+const (
+	binlogTimeFormat    = "2006-01-02 15:04:05"
+	binlogRetryInterval = time.Second // 行变更事件解析失败后的重试间隔
+)
+
+func NewBinlogReaderFunc(conf ReaderConfig, wg *sync.WaitGroup, parent context.Context) (Reader, error) {
+	config, ok := conf.(*BinlogReaderConfig)
+	if !ok {
+		return nil, configAssertErr
+	}
+
+	reader := &BinlogReader{
+		ReaderBase: NewReaderBase(config, parent),
+		schemas:    &binlogSchemas{conf: config, columns: make(map[string][]string)},
+		store:      &binlogPositionStore{path: config.GetPositionFile()},
+	}
+
+	reader.syncer = replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID: config.ServerId, Flavor: config.Flavor, Host: config.Host,
+		Port: config.Port, User: config.Username, Password: config.Password,
+		Charset: config.Charset, HeartbeatPeriod: config.HeartbeatPeriod,
+		ReadTimeout: config.ReadTimeout, MaxReconnectAttempts: config.MaxReconnectAttempts,
+	})
+
+	if err := reader.start(); err != nil {
+		reader.close()
+		return nil, err
+	}
+
+	return reader, nil
+}
+
+func NewBinlogReaderConfigFunc() interface{} {
+	return &BinlogReaderConfig{}
+}
+
+// start 根据 已保存位点 > 配置位点 > 主库当前位点 的顺序开始同步
+func (b *BinlogReader) start() error {
+	conf := b.conf.(*BinlogReaderConfig)
+	pos, ok, err := b.store.load()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		pos = binlogPosition{Name: conf.BinlogFile, Pos: conf.BinlogPos, GTID: conf.GTID}
+		if pos.Name == "" && pos.GTID == "" {
+			if pos, err = b.schemas.masterPosition(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if pos.GTID != "" {
+		gtidSet, err := mysql.ParseGTIDSet(conf.Flavor, pos.GTID)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		b.streamer, err = b.syncer.StartSyncGTID(gtidSet)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	} else if b.streamer, err = b.syncer.StartSync(mysql.Position{
+		Name: pos.Name, Pos: pos.Pos,
+	}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b.current, b.committed = pos, pos
+
+	return nil
+}
+
+// Read 读取下一个行变更或 ddl 事件，其他事件只用于维护位点
+func (b *BinlogReader) Read() (*types.BinlogParams, error) {
+	if b.pending != nil {
+		select {
+		case <-b.ctx.Done():
+			return nil, io.EOF
+		case <-time.After(binlogRetryInterval):
+		}
+
+		return b.readRowsEvent(b.pending, b.pending.Event.(*replication.RowsEvent))
+	}
+
+	for {
+		ev, err := b.streamer.GetEvent(b.ctx)
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return nil, io.EOF
+			}
+
+			return nil, errors.WithStack(err)
+		}
+
+		if ev.Header.LogPos > 0 {
+			b.current.Pos = ev.Header.LogPos
+		}
+
+		switch e := ev.Event.(type) {
+		case *replication.RotateEvent:
+			b.current.Name, b.current.Pos = string(e.NextLogName), uint32(e.Position)
+			if !b.inTxn {
+				b.committed.Name, b.committed.Pos = b.current.Name, b.current.Pos
+			}
+		case *replication.XIDEvent:
+			if err := b.commit(e.GSet); err != nil {
+				return nil, err
+			}
+		case *replication.QueryEvent:
+			switch query := strings.TrimSpace(string(e.Query)); strings.ToUpper(query) {
+			case "BEGIN":
+				b.inTxn = true
+			case "COMMIT":
+				// 非事务引擎没有 XIDEvent, 使用 COMMIT 语句提交
+				if err := b.commit(e.GSet); err != nil {
+					return nil, err
+				}
+			default:
+				// ddl 语句单独作为一个事务，返回给调用方确认后提交位点
+				b.inTxn, b.committed = false, b.current
+				b.committed.GTID = gtidSetString(e.GSet, b.committed.GTID)
+				b.schemas.invalidate(string(e.Schema))
+
+				return b.newBinlogParams(ev, string(e.Schema), "", ddlEventType(query), true), nil
+			}
+		case *replication.RowsEvent:
+			return b.readRowsEvent(ev, e)
+		}
+	}
+}
+
+// readRowsEvent 解析行变更事件，失败时保留事件等待下次 Read 重试
+// 不能跳过事件，否则后续的 XIDEvent 会把位点提交到丢失的行之后
+func (b *BinlogReader) readRowsEvent(ev *replication.BinlogEvent, e *replication.RowsEvent) (*types.BinlogParams, error) {
+	params, err := b.decodeRowsEvent(ev, e)
+	if err != nil {
+		metrics.ReaderDecodeErrors.WithLabelValues(b.conf.GetUniqueId()).Inc()
+		b.pending, b.inTxn = ev, true
+		return nil, err
+	}
+	b.pending = nil
+
+	return params, nil
+}
+
+// commit 事务提交，如果这个事务之前返回的事件都已经确认完成，直接保存位点
+// Read 和 Complete 都由同一个协程顺序调用，所以不需要考虑并发
+func (b *BinlogReader) commit(gtidSet mysql.GTIDSet) error {
+	b.inTxn, b.committed = false, b.current
+	b.committed.GTID = gtidSetString(gtidSet, b.committed.GTID)
+	if b.emitted == b.completed {
+		return b.store.save(b.committed)
+	}
+
+	return nil
+}
+
+// decodeRowsEvent 解析行变更事件
+func (b *BinlogReader) decodeRowsEvent(ev *replication.BinlogEvent, e *replication.RowsEvent) (*types.BinlogParams, error) {
+	var eventType string
+	switch ev.Header.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		eventType = types.EventTypeInsert
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		eventType = types.EventTypeUpdate
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		eventType = types.EventTypeDelete
+	default:
+		return nil, errors.Errorf("unsupported rows event type %s", ev.Header.EventType)
+	}
+
+	database, table := string(e.Table.Schema), string(e.Table.Table)
+	columns, err := b.schemas.getColumns(e.Table)
+	if err != nil {
+		return nil, err
+	}
+	unsignedMap := e.Table.UnsignedMap()
+
+	params := b.newBinlogParams(ev, database, table, eventType, false)
+	if eventType == types.EventTypeUpdate {
+		// 更新事件的行成对出现: 更新前, 更新后, old 只保留被更新的字段
+		for i := 0; i+1 < len(e.Rows); i += 2 {
+			before := decodeBinlogRow(columns, e.Rows[i], unsignedMap)
+			after := decodeBinlogRow(columns, e.Rows[i+1], unsignedMap)
+			params.Data = append(params.Data, after)
+			setBinlogRowNulls(params, len(params.Data)-1, columns, e.Rows[i+1])
+			params.Old = append(params.Old, diffOldColumns(params, len(params.Data)-1, before, after,
+				binlogRowNulls(columns, e.Rows[i])))
+		}
+	} else {
+		for _, row := range e.Rows {
+			params.Data = append(params.Data, decodeBinlogRow(columns, row, unsignedMap))
+			setBinlogRowNulls(params, len(params.Data)-1, columns, row)
+		}
+	}
+	for i, columnType := range e.Table.ColumnType {
+		if i < len(columns) {
+			params.SetColumnType(columns[i], binlogColumnType(columnType))
+		}
+	}
+
+	return params, nil
+}
+
+func (b *BinlogReader) newBinlogParams(ev *replication.BinlogEvent, database, table, eventType string, isDdl bool) *types.BinlogParams {
+	b.emitted++
+
+	return &types.BinlogParams{
+		EventId:  fmt.Sprintf("%s:%d", b.current.Name, ev.Header.LogPos),
+		Database: database, Table: table, EventType: eventType, IsDdl: isDdl,
+		EventAt: int64(ev.Header.Timestamp) * 1000, Source: b.committed,
+	}
+}
+
+// Complete 保存事件所属事务的开始位点，重启后从这个位点重新同步，保证至少一次
+func (b *BinlogReader) Complete(params *types.BinlogParams) error {
+	pos, ok := params.Source.(binlogPosition)
+	if !ok {
+		return errors.Errorf("binlog position assert failed, event: %s", params.EventId)
+	}
+	b.completed++
+
+	return b.store.save(pos)
+}
+
+func (b *BinlogReader) Close() error {
+	if b.FirstClose() {
+		return b.close()
+	}
+
+	return nil
+}
+
+func (b *BinlogReader) close() error {
+	b.syncer.Close()
+
+	return b.schemas.close()
+}
+
+// decodeBinlogRow 把 binlog 行数据转换为 字段:值 格式
+func decodeBinlogRow(columns []string, row []interface{}, unsignedMap map[int]bool) map[string]string {
+	record := make(map[string]string, len(columns))
+	for i, value := range row {
+		if i >= len(columns) {
+			break
+		}
+		record[columns[i]] = formatBinlogValue(value, unsignedMap[i])
+	}
+
+	return record
+}
+
+// setBinlogRowNulls 记录第 i 条记录值为 null 的字段
+func setBinlogRowNulls(params *types.BinlogParams, i int, columns []string, row []interface{}) {
+	for column := range binlogRowNulls(columns, row) {
+		params.SetNull(i, column)
+	}
+}
+
+// binlogRowNulls 获取 binlog 行数据中值为 null 的字段
+func binlogRowNulls(columns []string, row []interface{}) map[string]bool {
+	nulls := make(map[string]bool)
+	for j, value := range row {
+		if j < len(columns) && value == nil {
+			nulls[columns[j]] = true
+		}
+	}
+
+	return nulls
+}
+
+// binlogColumnType 把 binlog 的字段类型转换为字段类型
+// binlog 中没有字段长度，tinyint(1) 作为整数
+func binlogColumnType(columnType byte) string {
+	switch columnType {
+	case mysql.MYSQL_TYPE_TINY, mysql.MYSQL_TYPE_SHORT, mysql.MYSQL_TYPE_INT24, mysql.MYSQL_TYPE_LONG,
+		mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_YEAR, mysql.MYSQL_TYPE_BIT:
+		return types.ColumnTypeInt
+	case mysql.MYSQL_TYPE_DECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL:
+		return types.ColumnTypeDecimal
+	case mysql.MYSQL_TYPE_FLOAT, mysql.MYSQL_TYPE_DOUBLE:
+		return types.ColumnTypeFloat
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE, mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2,
+		mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIMESTAMP2, mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_TIME2:
+		return types.ColumnTypeTime
+	case mysql.MYSQL_TYPE_JSON:
+		return types.ColumnTypeJson
+	}
+
+	return types.ColumnTypeString
+}
+
+// formatBinlogValue 格式化 binlog 字段值, 和 canal 的字符串格式保持一致
+func formatBinlogValue(value interface{}, unsigned bool) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int8:
+		if unsigned {
+			return strconv.FormatUint(uint64(uint8(v)), 10)
+		}
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		if unsigned {
+			return strconv.FormatUint(uint64(uint16(v)), 10)
+		}
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		if unsigned {
+			return strconv.FormatUint(uint64(uint32(v)), 10)
+		}
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		if unsigned {
+			return strconv.FormatUint(uint64(v), 10)
+		}
+		return strconv.FormatInt(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(binlogTimeFormat)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func gtidSetString(gtidSet mysql.GTIDSet, defaultValue string) string {
+	if gtidSet == nil {
+		return defaultValue
+	}
+
+	return gtidSet.String()
+}
+
+// getColumns 获取表字段名列表，顺序和 binlog 行数据一致
+func (s *binlogSchemas) getColumns(table *replication.TableMapEvent) ([]string, error) {
+	if len(table.ColumnName) > 0 {
+		return table.ColumnNameString(), nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	key := string(table.Schema) + "." + string(table.Table)
+	if columns, ok := s.columns[key]; ok && len(columns) == int(table.ColumnCount) {
+		return columns, nil
+	}
+
+	result, err := s.execute(fmt.Sprintf("SELECT COLUMN_NAME FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = '%s' AND TABLE_NAME = '%s' ORDER BY ORDINAL_POSITION",
+		mysql.Escape(string(table.Schema)), mysql.Escape(string(table.Table))))
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, result.RowNumber())
+	for i := 0; i < result.RowNumber(); i++ {
+		column, err := result.GetString(i, 0)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) != int(table.ColumnCount) {
+		return nil, errors.Errorf("%s columns count mismatch, binlog: %d, schema: %d",
+			key, table.ColumnCount, len(columns))
+	}
+	s.columns[key] = columns
+
+	return columns, nil
+}
+
+// masterPosition 获取主库当前的 binlog 位点
+func (s *binlogSchemas) masterPosition() (binlogPosition, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	result, err := s.execute("SHOW MASTER STATUS")
+	if err != nil {
+		return binlogPosition{}, err
+	}
+	if result.RowNumber() == 0 {
+		return binlogPosition{}, errors.New("binlog is not enabled on master")
+	}
+
+	name, _ := result.GetString(0, 0)
+	pos, err := result.GetUint(0, 1)
+	if err != nil {
+		return binlogPosition{}, errors.WithStack(err)
+	}
+
+	return binlogPosition{Name: name, Pos: uint32(pos)}, nil
+}
+
+// invalidate ddl 之后清空对应库的字段缓存
+func (s *binlogSchemas) invalidate(database string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for key := range s.columns {
+		if strings.HasPrefix(key, database+".") {
+			delete(s.columns, key)
+		}
+	}
+}
+
+// execute 执行查询，连接失败后下次调用重新连接
+func (s *binlogSchemas) execute(query string) (*mysql.Result, error) {
+	if s.conn == nil {
+		conn, err := client.Connect(fmt.Sprintf("%s:%d", s.conf.Host, s.conf.Port),
+			s.conf.Username, s.conf.Password, "")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		s.conn = conn
+	}
+
+	result, err := s.conn.Execute(query)
+	if err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		return nil, errors.WithStack(err)
+	}
+
+	return result, nil
+}
+
+func (s *binlogSchemas) close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.conn != nil {
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// load 读取已保存的位点，文件不存在时返回 false
+func (s *binlogPositionStore) load() (binlogPosition, bool, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return binlogPosition{}, false, nil
+		}
+		return binlogPosition{}, false, errors.WithStack(err)
+	}
+
+	var pos binlogPosition
+	if err := json.Unmarshal(content, &pos); err != nil {
+		return binlogPosition{}, false, errors.WithStack(err)
+	}
+	s.saved = pos
+
+	return pos, true, nil
+}
+
+// save 保存位点，先写临时文件再重命名，防止写入中断导致位点文件损坏
+func (s *binlogPositionStore) save(pos binlogPosition) error {
+	if pos == s.saved {
+		return nil
+	}
+
+	content, err := json.Marshal(pos)
+	if err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return errors.WithStack(err)
+	}
+	s.saved = pos
+
+	return nil
+}
+
+type BinlogReaderConfig struct {
+	Host                 string        `json:"host" yaml:"host" default:"127.0.0.1"`
+	Port                 uint16        `json:"port" yaml:"port" default:"3306"`
+	Username             string        `json:"username" yaml:"username"`
+	Password             string        `json:"password,omitempty" yaml:"password,omitempty"`
+	ServerId             uint32        `json:"server_id" yaml:"server_id" default:"1001"`                    // 伪装从库的 server_id, 集群内唯一
+	Flavor               string        `json:"flavor,omitempty" yaml:"flavor,omitempty" default:"mysql"`     // mysql|mariadb
+	Charset              string        `json:"charset,omitempty" yaml:"charset,omitempty" default:"utf8mb4"` // 连接字符集
+	BinlogFile           string        `json:"binlog_file,omitempty" yaml:"binlog_file,omitempty"`           // 首次启动的 binlog 文件, 为空时从主库当前位点开始
+	BinlogPos            uint32        `json:"binlog_pos,omitempty" yaml:"binlog_pos,omitempty"`             // 首次启动的 binlog 位置
+	GTID                 string        `json:"gtid,omitempty" yaml:"gtid,omitempty"`                         // 首次启动的 GTID 集合, 不为空时优先使用
+	PositionFile         string        `json:"position_file,omitempty" yaml:"position_file,omitempty"`       // 位点保存文件, 为空时使用 binlog_{unique_id}.position
+	HeartbeatPeriod      time.Duration `json:"heartbeat_period,omitempty" yaml:"heartbeat_period,omitempty" default:"30s"`
+	ReadTimeout          time.Duration `json:"read_timeout,omitempty" yaml:"read_timeout,omitempty" default:"90s"`
+	MaxReconnectAttempts int           `json:"max_reconnect_attempts,omitempty" yaml:"max_reconnect_attempts,omitempty" default:"10"`
+}
+
+// GetPositionFile 获取位点保存文件路径
+func (b *BinlogReaderConfig) GetPositionFile() string {
+	if b.PositionFile != "" {
+		return b.PositionFile
+	}
+
+	return fmt.Sprintf("binlog_%s.position", b.GetUniqueId())
+}
+
+func (b *BinlogReaderConfig) GetUniqueId() string {
+	return tools.Hash32(fmt.Sprintf("%s-%d-%s-%d", b.Host, b.Port, b.Username, b.ServerId))
+}
+
+func (b *BinlogReaderConfig) Equal(config ReaderConfig) bool {
+	newConfig, ok := config.(*BinlogReaderConfig)
+	if ok {
+		ok = reflect.DeepEqual(b, newConfig)
+	}
+
+	return ok
+}
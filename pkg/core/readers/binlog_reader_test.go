@@ -0,0 +1,434 @@
+package readers
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/server"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type (
+	// fakeReplicationServer 按脚本返回 binlog 事件的 mysql 主库
+	fakeReplicationServer struct {
+		server.EmptyReplicationHandler
+		listener net.Listener
+		events   [][]byte
+		columns  map[string][]string
+		pos      uint32
+		mux      sync.Mutex
+	}
+
+	fakeColumn struct {
+		name      string
+		colType   byte
+		meta      []byte
+		unsigned  bool
+		encodeVal func(value interface{}) []byte
+	}
+)
+
+const (
+	fakeBinlogFile    = "mysql-bin.000001"
+	fakeEventAt       = 1709026288
+	fakeTableIdOrders = 1
+	fakeTableIdUsers  = 2
+)
+
+func newFakeReplicationServer(t *testing.T) *fakeReplicationServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeReplicationServer{
+		listener: listener, pos: 4,
+		columns: map[string][]string{"test.orders": {"id", "order_sn", "price"}},
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				c, err := server.NewConn(conn, "root", "", s)
+				if err != nil {
+					return
+				}
+				for c.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeReplicationServer) port() uint16 {
+	return uint16(s.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (s *fakeReplicationServer) HandleQuery(query string) (*mysql.Result, error) {
+	var (
+		resultset *mysql.Resultset
+		err       error
+	)
+
+	switch {
+	case strings.HasPrefix(query, "SHOW GLOBAL VARIABLES"):
+		resultset, err = mysql.BuildSimpleTextResultset([]string{"Variable_name", "Value"},
+			[][]interface{}{{"binlog_checksum", "NONE"}})
+	case query == "SHOW MASTER STATUS":
+		resultset, err = mysql.BuildSimpleTextResultset([]string{"File", "Position"},
+			[][]interface{}{{fakeBinlogFile, 4}})
+	case strings.Contains(query, "information_schema.COLUMNS"):
+		var rows [][]interface{}
+		s.mux.Lock()
+		for _, column := range s.columns["test.orders"] {
+			rows = append(rows, []interface{}{column})
+		}
+		s.mux.Unlock()
+		resultset, err = mysql.BuildSimpleTextResultset([]string{"COLUMN_NAME"}, rows)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &mysql.Result{Resultset: resultset}, nil
+}
+
+func (s *fakeReplicationServer) setColumns(key string, columns []string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.columns[key] = columns
+}
+
+func (s *fakeReplicationServer) HandleRegisterSlave(data []byte) error {
+	return nil
+}
+
+func (s *fakeReplicationServer) HandleBinlogDump(pos mysql.Position) (*replication.BinlogStreamer, error) {
+	streamer := replication.NewBinlogStreamer()
+	for _, event := range s.events {
+		if err := streamer.AddEventToStreamer(&replication.BinlogEvent{RawData: event}); err != nil {
+			return nil, err
+		}
+	}
+
+	return streamer, nil
+}
+
+// addEvent 追加事件，logPos 为 0 的事件不占用位点 (假的 rotate 事件)
+func (s *fakeReplicationServer) addEvent(eventType replication.EventType, body []byte, fake bool) uint32 {
+	size := uint32(replication.EventHeaderSize + len(body))
+	logPos := uint32(0)
+	if !fake {
+		s.pos += size
+		logPos = s.pos
+	}
+
+	header := make([]byte, replication.EventHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:], fakeEventAt)
+	header[4] = byte(eventType)
+	binary.LittleEndian.PutUint32(header[5:], 1)
+	binary.LittleEndian.PutUint32(header[9:], size)
+	binary.LittleEndian.PutUint32(header[13:], logPos)
+	s.events = append(s.events, append(header, body...))
+
+	return logPos
+}
+
+func (s *fakeReplicationServer) addRotate() {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint64(body, 4)
+	s.addEvent(replication.ROTATE_EVENT, append(body, fakeBinlogFile...), true)
+}
+
+func (s *fakeReplicationServer) addFormatDescription() {
+	body := make([]byte, 2+50+4)
+	binary.LittleEndian.PutUint16(body, 4)
+	copy(body[2:], "5.7.30-log")
+	body = append(body, replication.EventHeaderSize)
+	// 所有事件的 post header 长度，不为 6 时 table id 使用 6 字节
+	body = append(body, make([]byte, 40)...)
+	// checksum 算法: OFF, 以及 4 字节 checksum
+	body = append(body, 0, 0, 0, 0, 0)
+	s.addEvent(replication.FORMAT_DESCRIPTION_EVENT, body, true)
+}
+
+func (s *fakeReplicationServer) addQuery(schema, query string) uint32 {
+	body := make([]byte, 13)
+	body[8] = byte(len(schema))
+	body = append(body, schema...)
+	body = append(body, 0)
+
+	return s.addEvent(replication.QUERY_EVENT, append(body, query...), false)
+}
+
+func (s *fakeReplicationServer) addXid() uint32 {
+	return s.addEvent(replication.XID_EVENT, make([]byte, 8), false)
+}
+
+func (s *fakeReplicationServer) addTableMap(tableId uint64, schema, table string, columns []fakeColumn, withNames bool) {
+	body := putUint48(nil, tableId)
+	body = append(body, 0, 0, byte(len(schema)))
+	body = append(body, schema...)
+	body = append(body, 0, byte(len(table)))
+	body = append(body, table...)
+	body = append(body, 0, byte(len(columns)))
+
+	var meta []byte
+	for _, column := range columns {
+		body = append(body, column.colType)
+		meta = append(meta, column.meta...)
+	}
+	body = append(body, byte(len(meta)))
+	body = append(body, meta...)
+	body = append(body, 0) // null bitmap
+
+	// 可选元数据: 符号位 和 字段名
+	var signedness byte
+	numericIndex := 0
+	for _, column := range columns {
+		if column.colType == mysql.MYSQL_TYPE_LONG || column.colType == mysql.MYSQL_TYPE_LONGLONG {
+			if column.unsigned {
+				signedness |= 1 << uint(7-numericIndex)
+			}
+			numericIndex++
+		}
+	}
+	body = append(body, replication.TABLE_MAP_OPT_META_SIGNEDNESS, 1, signedness)
+
+	if withNames {
+		var names []byte
+		for _, column := range columns {
+			names = append(names, byte(len(column.name)))
+			names = append(names, column.name...)
+		}
+		body = append(body, replication.TABLE_MAP_OPT_META_COLUMN_NAME, byte(len(names)))
+		body = append(body, names...)
+	}
+
+	s.addEvent(replication.TABLE_MAP_EVENT, body, false)
+}
+
+func (s *fakeReplicationServer) addRows(eventType replication.EventType, tableId uint64, columns []fakeColumn, rows ...[]interface{}) {
+	body := putUint48(nil, tableId)
+	// flags: statement end, extra data length: 2
+	body = append(body, 1, 0, 2, 0, byte(len(columns)), 0xff)
+	if eventType == replication.UPDATE_ROWS_EVENTv2 {
+		body = append(body, 0xff)
+	}
+
+	for _, row := range rows {
+		body = append(body, 0) // null bitmap
+		for i, value := range row {
+			body = append(body, columns[i].encodeVal(value)...)
+		}
+	}
+
+	s.addEvent(eventType, body, false)
+}
+
+func putUint48(dest []byte, value uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, value)
+
+	return append(dest, buf[:6]...)
+}
+
+var fakeOrderColumns = []fakeColumn{
+	{name: "id", colType: mysql.MYSQL_TYPE_LONGLONG, encodeVal: func(value interface{}) []byte {
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, uint64(value.(int)))
+		return buf
+	}},
+	{name: "order_sn", colType: mysql.MYSQL_TYPE_VARCHAR, meta: []byte{0xff, 0}, encodeVal: func(value interface{}) []byte {
+		return append([]byte{byte(len(value.(string)))}, value.(string)...)
+	}},
+	{name: "price", colType: mysql.MYSQL_TYPE_LONG, unsigned: true, encodeVal: func(value interface{}) []byte {
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, uint32(value.(int)))
+		return buf
+	}},
+}
+
+func readBinlogParams(t *testing.T, reader Reader) *types.BinlogParams {
+	params, err := reader.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return params
+}
+
+func loadSavedPosition(t *testing.T, path string) binlogPosition {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pos binlogPosition
+	if err := json.Unmarshal(content, &pos); err != nil {
+		t.Fatal(err)
+	}
+
+	return pos
+}
+
+func TestBinlogReader_Read(t *testing.T) {
+	s := newFakeReplicationServer(t)
+	s.addRotate()
+	s.addFormatDescription()
+	s.addQuery("test", "BEGIN")
+	s.addTableMap(fakeTableIdOrders, "test", "orders", fakeOrderColumns, false)
+	s.addRows(replication.WRITE_ROWS_EVENTv2, fakeTableIdOrders, fakeOrderColumns,
+		[]interface{}{1, "sn001", 5000}, []interface{}{2, "sn002", 3000000000})
+	firstCommitPos := s.addXid()
+	s.addQuery("test", "BEGIN")
+	s.addTableMap(fakeTableIdOrders, "test", "orders", fakeOrderColumns, false)
+	s.addRows(replication.UPDATE_ROWS_EVENTv2, fakeTableIdOrders, fakeOrderColumns,
+		[]interface{}{1, "sn001", 5000}, []interface{}{1, "sn001", 4500})
+	s.addXid()
+	ddlPos := s.addQuery("test", "ALTER TABLE orders ADD COLUMN remark varchar(32)")
+	s.addQuery("test", "BEGIN")
+	s.addTableMap(fakeTableIdUsers, "test", "users", fakeOrderColumns, true)
+	s.addRows(replication.DELETE_ROWS_EVENTv2, fakeTableIdUsers, fakeOrderColumns,
+		[]interface{}{3, "sn003", 100})
+
+	positionFile := filepath.Join(t.TempDir(), "binlog.position")
+	conf := &BinlogReaderConfig{
+		Host: "127.0.0.1", Port: s.port(), Username: "root", ServerId: 1001,
+		Flavor: mysql.MySQLFlavor, PositionFile: positionFile,
+	}
+	reader, err := NewBinlogReaderFunc(conf, new(sync.WaitGroup), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// 插入事件，字段名通过 information_schema 获取
+	params := readBinlogParams(t, reader)
+	if params.EventType != types.EventTypeInsert || params.Database != "test" || params.Table != "orders" {
+		t.Fatalf("insert event decode failed: %+v", params)
+	}
+	if len(params.Data) != 2 || params.Data[0]["order_sn"] != "sn001" || params.Data[0]["price"] != "5000" {
+		t.Fatalf("insert rows decode failed: %v", params.Data)
+	}
+	if params.Data[1]["price"] != "3000000000" {
+		t.Fatalf("unsigned column decode failed, expect: 3000000000, actual: %s", params.Data[1]["price"])
+	}
+	if params.ColumnTypes["price"] != types.ColumnTypeInt || params.ColumnTypes["order_sn"] != types.ColumnTypeString {
+		t.Fatalf("column types decode failed: %v", params.ColumnTypes)
+	}
+	if params.EventAt != fakeEventAt*1000 {
+		t.Fatalf("event time error, expect: %d, actual: %d", fakeEventAt*1000, params.EventAt)
+	}
+	if err := reader.Complete(params); err != nil {
+		t.Fatal(err)
+	}
+
+	// 更新事件，old 只包含被修改的字段
+	params = readBinlogParams(t, reader)
+	if params.EventType != types.EventTypeUpdate || len(params.Data) != 1 || len(params.Old) != 1 {
+		t.Fatalf("update event decode failed: %+v", params)
+	}
+	if params.Data[0]["price"] != "4500" || len(params.Old[0]) != 1 || params.Old[0]["price"] != "5000" {
+		t.Fatalf("update rows decode failed, data: %v, old: %v", params.Data, params.Old)
+	}
+	if pos := params.Source.(binlogPosition); pos.Name != fakeBinlogFile || pos.Pos != firstCommitPos {
+		t.Fatalf("update event should start after first commit %d, actual: %+v", firstCommitPos, pos)
+	}
+	if err := reader.Complete(params); err != nil {
+		t.Fatal(err)
+	}
+
+	// ddl 事件不处理，确认后保存 ddl 之后的位点
+	params = readBinlogParams(t, reader)
+	if !params.IsDdl || params.EventType != "alter" {
+		t.Fatalf("ddl event decode failed: %+v", params)
+	}
+	if err := reader.Complete(params); err != nil {
+		t.Fatal(err)
+	}
+	if pos := loadSavedPosition(t, positionFile); pos.Pos != ddlPos {
+		t.Fatalf("saved position error, expect: %d, actual: %d", ddlPos, pos.Pos)
+	}
+
+	// 删除事件，字段名来自 TableMapEvent 元数据，未确认前不保存位点
+	params = readBinlogParams(t, reader)
+	if params.EventType != types.EventTypeDelete || params.Table != "users" ||
+		len(params.Data) != 1 || params.Data[0]["id"] != "3" {
+		t.Fatalf("delete event decode failed: %+v", params)
+	}
+	if pos := loadSavedPosition(t, positionFile); pos.Pos != ddlPos {
+		t.Fatalf("position should not be saved before complete, expect: %d, actual: %d", ddlPos, pos.Pos)
+	}
+}
+
+func TestBinlogReader_ReadDecodeFailed(t *testing.T) {
+	s := newFakeReplicationServer(t)
+	s.addRotate()
+	s.addFormatDescription()
+	s.addQuery("test", "BEGIN")
+	s.addTableMap(fakeTableIdOrders, "test", "orders", fakeOrderColumns, false)
+	s.addRows(replication.WRITE_ROWS_EVENTv2, fakeTableIdOrders, fakeOrderColumns,
+		[]interface{}{1, "sn001", 5000})
+	firstCommitPos := s.addXid()
+	s.addQuery("test", "BEGIN")
+	// 第二个事务的字段数量和 information_schema 不一致，getColumns 失败
+	s.addTableMap(fakeTableIdUsers, "test", "orders", fakeOrderColumns[:2], false)
+	s.addRows(replication.WRITE_ROWS_EVENTv2, fakeTableIdUsers, fakeOrderColumns[:2],
+		[]interface{}{2, "sn002"})
+	s.addXid()
+
+	positionFile := filepath.Join(t.TempDir(), "binlog.position")
+	conf := &BinlogReaderConfig{
+		Host: "127.0.0.1", Port: s.port(), Username: "root", ServerId: 1001,
+		Flavor: mysql.MySQLFlavor, PositionFile: positionFile,
+	}
+	reader, err := NewBinlogReaderFunc(conf, new(sync.WaitGroup), context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	params := readBinlogParams(t, reader)
+	if err := reader.Complete(params); err != nil {
+		t.Fatal(err)
+	}
+
+	// 解析失败不能跳过事件，后面的 XIDEvent 不会被读取，位点停留在第一个事务
+	for i := 0; i < 2; i++ {
+		if _, err := reader.Read(); err == nil {
+			t.Fatalf("read %d should fail with columns count mismatch", i)
+		}
+		if pos := loadSavedPosition(t, positionFile); pos.Pos != firstCommitPos {
+			t.Fatalf("saved position should not move, expect: %d, actual: %d", firstCommitPos, pos.Pos)
+		}
+	}
+
+	// 表结构恢复后重试同一个事件
+	s.setColumns("test.orders", []string{"id", "order_sn"})
+	params = readBinlogParams(t, reader)
+	if params.EventType != types.EventTypeInsert || len(params.Data) != 1 || params.Data[0]["order_sn"] != "sn002" {
+		t.Fatalf("retry event decode failed: %+v", params)
+	}
+	if pos := params.Source.(binlogPosition); pos.Pos != firstCommitPos {
+		t.Fatalf("retry event should start after first commit %d, actual: %+v", firstCommitPos, pos)
+	}
+}
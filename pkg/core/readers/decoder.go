@@ -0,0 +1,373 @@
+package readers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+type (
+	// MessageDecoder 消息解码器，把不同格式的变更消息转换为 BinlogParams
+	// 返回 nil, nil 代表消息不需要处理 (墓碑消息、事务元数据等)
+	MessageDecoder func(value []byte) (*types.BinlogParams, error)
+
+	// debeziumEnvelope debezium 变更消息, schemas.enable=true 时包裹在 payload 中
+	debeziumEnvelope struct {
+		Schema  *debeziumSchema        `json:"schema"`
+		Payload *debeziumEnvelope      `json:"payload"`
+		Before  map[string]interface{} `json:"before"`
+		After   map[string]interface{} `json:"after"`
+		Op      string                 `json:"op"`
+		TsMs    int64                  `json:"ts_ms"`
+		Source  struct {
+			Db    string `json:"db"`
+			Table string `json:"table"`
+			TsMs  int64  `json:"ts_ms"`
+		} `json:"source"`
+		Ddl          string `json:"ddl"`
+		DatabaseName string `json:"databaseName"`
+		Status       string `json:"status"` // 事务元数据消息 BEGIN|END
+	}
+
+	// debeziumSchema debezium 消息的字段定义，schemas.enable=true 时才有
+	debeziumSchema struct {
+		Field      string            `json:"field"`
+		Name       string            `json:"name"`
+		Fields     []*debeziumSchema `json:"fields"`
+		Parameters map[string]string `json:"parameters"`
+	}
+
+	// maxwellMessage maxwell 变更消息
+	maxwellMessage struct {
+		Database string                 `json:"database"`
+		Table    string                 `json:"table"`
+		Type     string                 `json:"type"`
+		Ts       int64                  `json:"ts"`
+		Xid      int64                  `json:"xid"`
+		Data     map[string]interface{} `json:"data"`
+		Old      map[string]interface{} `json:"old"`
+		Sql      string                 `json:"sql"`
+	}
+)
+
+var _messageDecoders = make(map[string]MessageDecoder) // 消息解码器 映射表
+
+const debeziumDecimalSchema = "org.apache.kafka.connect.data.Decimal" // decimal.handling.mode=precise 的字段类型
+
+func init() {
+	SetMessageDecoder(types.MessageFormatCanal, DecodeCanalMessage)
+	SetMessageDecoder(types.MessageFormatDebezium, DecodeDebeziumMessage)
+	SetMessageDecoder(types.MessageFormatMaxwell, DecodeMaxwellMessage)
+}
+
+// SetMessageDecoder 注册消息解码器
+func SetMessageDecoder(format string, fn MessageDecoder) {
+	_messageDecoders[format] = fn
+}
+
+// GetMessageDecoder 获取消息解码器
+func GetMessageDecoder(format string) MessageDecoder {
+	return _messageDecoders[format]
+}
+
+// DecodeCanalMessage 解析 canal flat message
+func DecodeCanalMessage(value []byte) (*types.BinlogParams, error) {
+	binLogParams := new(types.BinlogParams)
+	if err := json.Unmarshal(value, binLogParams); err != nil {
+		return nil, err
+	}
+
+	return binLogParams, nil
+}
+
+// DecodeDebeziumMessage 解析 debezium 变更消息
+// before/after 为完整的行数据，更新事件的 old 只保留被修改的字段
+// decimal.handling.mode=precise 时需要开启 schemas.enable，否则无法区分 decimal 和字符串
+// 没有 before 的更新事件按插入处理，mysql 目标需要开启 upsert_on_insert 或批量模式
+func DecodeDebeziumMessage(value []byte) (*types.BinlogParams, error) {
+	if len(bytes.TrimSpace(value)) == 0 || bytes.Equal(value, []byte("null")) {
+		// 删除事件之后的墓碑消息，用于 kafka 日志压缩
+		return nil, nil
+	}
+
+	var envelope debeziumEnvelope
+	if err := unmarshalUseNumber(value, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Payload != nil {
+		schema := envelope.Schema
+		envelope = *envelope.Payload
+		// precise 模式的 decimal 为 base64 编码的字节，需要根据 schema 中的精度解码
+		scales, err := schema.decimalScales()
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range []map[string]interface{}{envelope.Before, envelope.After} {
+			if err := decodeDebeziumDecimals(row, scales); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if envelope.Status != "" {
+		// 事务元数据消息不处理
+		return nil, nil
+	}
+
+	params := &types.BinlogParams{
+		Database: envelope.Source.Db, Table: envelope.Source.Table,
+		EventAt: envelope.Source.TsMs,
+	}
+	if params.EventAt == 0 {
+		params.EventAt = envelope.TsMs
+	}
+
+	if envelope.Ddl != "" {
+		if params.Database == "" {
+			params.Database = envelope.DatabaseName
+		}
+		params.IsDdl, params.EventType = true, ddlEventType(envelope.Ddl)
+
+		return params, nil
+	}
+
+	switch envelope.Op {
+	case "c", "r": // r: 快照读取，按插入处理
+		params.EventType = types.EventTypeInsert
+		params.Data = []map[string]string{stringifyRow(envelope.After)}
+		setRowTypes(params, 0, envelope.After)
+	case "u":
+		if envelope.Before == nil {
+			// 没有开启完整的 before 镜像时无法判断修改的字段，按插入处理，写入全部字段
+			params.EventType = types.EventTypeInsert
+			params.Data = []map[string]string{stringifyRow(envelope.After)}
+			setRowTypes(params, 0, envelope.After)
+			break
+		}
+		before, after := stringifyRow(envelope.Before), stringifyRow(envelope.After)
+		params.EventType, params.Data = types.EventTypeUpdate, []map[string]string{after}
+		setRowTypes(params, 0, envelope.After)
+		params.Old = []map[string]string{diffOldColumns(params, 0, before, after, rowNulls(envelope.Before))}
+	case "d":
+		params.EventType = types.EventTypeDelete
+		params.Data = []map[string]string{stringifyRow(envelope.Before)}
+		setRowTypes(params, 0, envelope.Before)
+	case "t":
+		// truncate 不同步，作为 ddl 处理
+		params.IsDdl, params.EventType = true, "truncate"
+	default:
+		return nil, errors.Errorf("unsupported debezium op: %s", envelope.Op)
+	}
+
+	return params, nil
+}
+
+// decimalScales 获取 before/after 中 decimal 字段的精度，没有 schema 时返回 nil
+func (s *debeziumSchema) decimalScales() (map[string]int, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	scales := make(map[string]int)
+	for _, row := range s.Fields {
+		if row.Field != "before" && row.Field != "after" {
+			continue
+		}
+		for _, field := range row.Fields {
+			if field.Name != debeziumDecimalSchema {
+				continue
+			}
+			scale, err := strconv.Atoi(field.Parameters["scale"])
+			if err != nil {
+				return nil, errors.Errorf("invalid debezium decimal scale of %s: %q", field.Field, field.Parameters["scale"])
+			}
+			scales[field.Field] = scale
+		}
+	}
+
+	return scales, nil
+}
+
+// decodeDebeziumDecimals 把 base64 编码的 decimal 字段解码为数字
+// 字节为非标度值的大端补码，值 = 非标度值 * 10^-scale
+func decodeDebeziumDecimals(row map[string]interface{}, scales map[string]int) error {
+	for column, scale := range scales {
+		encoded, ok := row[column].(string)
+		if !ok {
+			continue
+		}
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return errors.Wrapf(err, "decode debezium decimal %s", column)
+		}
+
+		unscaled := new(big.Int).SetBytes(content)
+		if len(content) > 0 && content[0]&0x80 != 0 {
+			unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(content)*8)))
+		}
+		row[column] = json.Number(formatUnscaledDecimal(unscaled, scale))
+	}
+
+	return nil
+}
+
+// formatUnscaledDecimal 按精度格式化非标度值，例如 450050 精度 2 为 4500.50
+func formatUnscaledDecimal(unscaled *big.Int, scale int) string {
+	digits := new(big.Int).Abs(unscaled).String()
+	if scale > 0 {
+		if len(digits) <= scale {
+			digits = strings.Repeat("0", scale-len(digits)+1) + digits
+		}
+		digits = digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	}
+	if unscaled.Sign() < 0 {
+		digits = "-" + digits
+	}
+
+	return digits
+}
+
+// DecodeMaxwellMessage 解析 maxwell 变更消息
+// maxwell 的 old 只包含被修改的字段，和 canal 一致
+func DecodeMaxwellMessage(value []byte) (*types.BinlogParams, error) {
+	var message maxwellMessage
+	if err := unmarshalUseNumber(value, &message); err != nil {
+		return nil, err
+	}
+
+	params := &types.BinlogParams{
+		Database: message.Database, Table: message.Table, EventAt: message.Ts * 1000,
+	}
+
+	switch message.Type {
+	case types.EventTypeInsert, "bootstrap-insert": // bootstrap-insert: 全量初始化数据，按插入处理
+		params.EventType = types.EventTypeInsert
+	case types.EventTypeUpdate, types.EventTypeDelete:
+		params.EventType = message.Type
+	case "bootstrap-start", "bootstrap-complete":
+		return nil, nil
+	default:
+		if message.Sql == "" {
+			return nil, errors.Errorf("unsupported maxwell type: %s", message.Type)
+		}
+		// ddl 类型格式为 table-create table-alter database-drop 等
+		params.IsDdl, params.EventType = true, ddlEventType(message.Sql)
+
+		return params, nil
+	}
+
+	params.Data = []map[string]string{stringifyRow(message.Data)}
+	setRowTypes(params, 0, message.Data)
+	if params.EventType == types.EventTypeUpdate {
+		params.Old = []map[string]string{stringifyRow(message.Old)}
+		for column := range rowNulls(message.Old) {
+			params.SetOldNull(0, column)
+		}
+	}
+
+	return params, nil
+}
+
+// diffOldColumns 比较第 i 条记录更新前后的数据，只保留被修改字段的旧值，更新后的 null 字段需要先记录
+// null 和空字符串之间的修改字符串相同，根据 null 字段判断，更新前为 null 的字段记录到 OldNulls
+func diffOldColumns(params *types.BinlogParams, i int, before, after map[string]string,
+	beforeNulls map[string]bool) map[string]string {
+	old, afterNulls := make(map[string]string), params.GetNulls(i)
+	for column, value := range before {
+		if afterValue, ok := after[column]; !ok || afterValue != value || beforeNulls[column] != afterNulls[column] {
+			old[column] = value
+			if beforeNulls[column] {
+				params.SetOldNull(i, column)
+			}
+		}
+	}
+
+	return old
+}
+
+// rowNulls 获取 json 解析后的行数据中值为 null 的字段
+func rowNulls(row map[string]interface{}) map[string]bool {
+	nulls := make(map[string]bool)
+	for column, value := range row {
+		if value == nil {
+			nulls[column] = true
+		}
+	}
+
+	return nulls
+}
+
+// stringifyRow 把 json 解析后的行数据转换为字符串格式
+func stringifyRow(row map[string]interface{}) map[string]string {
+	record := make(map[string]string, len(row))
+	for column, value := range row {
+		record[column] = stringifyValue(value)
+	}
+
+	return record
+}
+
+// setRowTypes 根据 json 值推断第 i 条记录的字段类型，并记录值为 null 的字段
+// 带小数点或指数的数字作为 decimal，保留原始精度
+func setRowTypes(params *types.BinlogParams, i int, row map[string]interface{}) {
+	for column, value := range row {
+		columnType := types.ColumnTypeString
+		switch v := value.(type) {
+		case nil:
+			params.SetNull(i, column)
+			continue
+		case string:
+		case json.Number:
+			if columnType = types.ColumnTypeInt; strings.ContainsAny(v.String(), ".eE") {
+				columnType = types.ColumnTypeDecimal
+			}
+		case bool:
+			columnType = types.ColumnTypeBool
+		default:
+			columnType = types.ColumnTypeJson
+		}
+		params.SetColumnType(column, columnType)
+	}
+}
+
+func stringifyValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		// 对象和数组保持 json 格式
+		content, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(content)
+	}
+}
+
+func unmarshalUseNumber(value []byte, dest interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+
+	return errors.WithStack(decoder.Decode(dest))
+}
+
+// ddlEventType ddl 语句的第一个关键字作为事件类型, 例如: alter create
+func ddlEventType(query string) string {
+	if fields := strings.Fields(query); len(fields) > 0 {
+		return strings.ToLower(fields[0])
+	}
+
+	return ""
+}
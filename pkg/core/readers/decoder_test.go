@@ -0,0 +1,132 @@
+package readers
+
+import (
+	"github.com/Junjiayy/hamal/pkg/types"
+	"testing"
+)
+
+func TestDecodeCanalMessage(t *testing.T) {
+	params, err := DecodeCanalMessage([]byte(`{"data":[{"id":"1","price":"5000"}],"database":"test",
+		"table":"orders","ts":1709026288000,"type":"INSERT","isDdl":false,"old":null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.EventType != types.EventTypeInsert || params.Data[0]["price"] != "5000" {
+		t.Fatalf("canal message decode failed: %+v", params)
+	}
+}
+
+func TestDecodeDebeziumMessage(t *testing.T) {
+	params, err := DecodeDebeziumMessage([]byte(`{"schema":{},"payload":{
+		"before":{"id":1,"price":5000,"status":"paid","paid":true,"memo":null},
+		"after":{"id":1,"price":4500.5,"status":"paid","paid":true,"memo":"","extra":{"a":1},"remark":null},
+		"source":{"db":"test","table":"orders","ts_ms":1709026288000},"op":"u","ts_ms":1709026289000}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.EventType != types.EventTypeUpdate || params.Database != "test" ||
+		params.Table != "orders" || params.EventAt != 1709026288000 {
+		t.Fatalf("debezium update decode failed: %+v", params)
+	}
+	if data := params.Data[0]; data["price"] != "4500.5" || data["paid"] != "true" || data["extra"] != `{"a":1}` {
+		t.Fatalf("debezium values decode failed: %v", data)
+	}
+	// null 更新为空字符串同样是被修改的字段
+	if old := params.Old[0]; len(old) != 2 || old["price"] != "5000" || old["memo"] != "" {
+		t.Fatalf("debezium old should only contain updated columns: %v", old)
+	}
+	if oldNulls := params.GetOldNulls(0); len(oldNulls) != 1 || !oldNulls["memo"] {
+		t.Fatalf("debezium old nulls error: %v", oldNulls)
+	}
+	// 字段类型根据 json 值推断，null 字段单独记录
+	if columnTypes := params.ColumnTypes; columnTypes["id"] != types.ColumnTypeInt ||
+		columnTypes["price"] != types.ColumnTypeDecimal || columnTypes["paid"] != types.ColumnTypeBool ||
+		columnTypes["extra"] != types.ColumnTypeJson || columnTypes["status"] != types.ColumnTypeString {
+		t.Fatalf("debezium column types error: %v", columnTypes)
+	}
+	if nulls := params.GetNulls(0); len(nulls) != 1 || !nulls["remark"] {
+		t.Fatalf("debezium nulls error: %v", nulls)
+	}
+
+	params, err = DecodeDebeziumMessage([]byte(`{"before":{"id":2},"after":null,
+		"source":{"db":"test","table":"orders","ts_ms":1709026288000},"op":"d"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.EventType != types.EventTypeDelete || params.Data[0]["id"] != "2" {
+		t.Fatalf("debezium delete decode failed: %+v", params)
+	}
+
+	// 没有 before 的更新事件按插入处理
+	params, err = DecodeDebeziumMessage([]byte(`{"before":null,"after":{"id":3,"status":"paid"},
+		"source":{"db":"test","table":"orders","ts_ms":1709026288000},"op":"u"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.EventType != types.EventTypeInsert || params.Old != nil || params.Data[0]["status"] != "paid" {
+		t.Fatalf("debezium update without before decode failed: %+v", params)
+	}
+
+	// precise 模式的 decimal 根据 schema 解码, Bt4C: 450050, +SH+: -450050
+	params, err = DecodeDebeziumMessage([]byte(`{"schema":{"type":"struct","fields":[
+		{"type":"struct","field":"before","fields":[{"type":"bytes","field":"price",
+			"name":"org.apache.kafka.connect.data.Decimal","parameters":{"scale":"2"}}]},
+		{"type":"struct","field":"after","fields":[{"type":"bytes","field":"price",
+			"name":"org.apache.kafka.connect.data.Decimal","parameters":{"scale":"2"}}]}]},
+		"payload":{"before":{"id":4,"price":"+SH+"},"after":{"id":4,"price":"Bt4C"},
+		"source":{"db":"test","table":"orders","ts_ms":1709026288000},"op":"u"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Data[0]["price"] != "4500.50" || params.Old[0]["price"] != "-4500.50" ||
+		params.ColumnTypes["price"] != types.ColumnTypeDecimal {
+		t.Fatalf("debezium precise decimal decode failed: %+v", params)
+	}
+
+	params, err = DecodeDebeziumMessage([]byte(`{"source":{"db":"test","ts_ms":1709026288000},
+		"databaseName":"test","ddl":"ALTER TABLE orders ADD COLUMN remark varchar(32)"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !params.IsDdl || params.EventType != "alter" {
+		t.Fatalf("debezium ddl decode failed: %+v", params)
+	}
+
+	// 墓碑消息 和 事务元数据消息 不处理
+	for _, message := range []string{"", "null", `{"status":"BEGIN","id":"1"}`} {
+		if params, err = DecodeDebeziumMessage([]byte(message)); err != nil || params != nil {
+			t.Fatalf("debezium message %q should be skipped, params: %+v, err: %v", message, params, err)
+		}
+	}
+}
+
+func TestDecodeMaxwellMessage(t *testing.T) {
+	params, err := DecodeMaxwellMessage([]byte(`{"database":"test","table":"orders","type":"update",
+		"ts":1709026288,"xid":940752,"commit":true,"data":{"id":1,"price":4500,"remark":"x"},"old":{"price":5000,"remark":null}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if params.EventType != types.EventTypeUpdate || params.EventAt != 1709026288000 {
+		t.Fatalf("maxwell update decode failed: %+v", params)
+	}
+	if params.Data[0]["price"] != "4500" || params.Old[0]["price"] != "5000" || !params.GetOldNulls(0)["remark"] {
+		t.Fatalf("maxwell values decode failed, data: %v, old: %v", params.Data, params.Old)
+	}
+
+	params, err = DecodeMaxwellMessage([]byte(`{"database":"test","table":"orders","type":"table-alter",
+		"ts":1709026288,"sql":"ALTER TABLE orders ADD COLUMN remark varchar(32)"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !params.IsDdl || params.EventType != "alter" {
+		t.Fatalf("maxwell ddl decode failed: %+v", params)
+	}
+
+	if params, err = DecodeMaxwellMessage([]byte(`{"database":"test","table":"orders",
+		"type":"bootstrap-start","ts":1709026288}`)); err != nil || params != nil {
+		t.Fatalf("maxwell bootstrap-start should be skipped, params: %+v, err: %v", params, err)
+	}
+}
@@ -2,6 +2,7 @@ package readers
 
 import (
 	"context"
+	"github.com/Junjiayy/hamal/pkg/metrics"
 	"github.com/Junjiayy/hamal/pkg/tools"
 	"github.com/Junjiayy/hamal/pkg/types"
 	"github.com/gin-gonic/gin"
@@ -50,6 +51,7 @@ func NewHttpReaderConfigFunc() interface{} {
 func (h *HttpReader) httpAcceptHandle(ctx *gin.Context) {
 	binLogParams := new(types.BinlogParams)
 	if err := ctx.ShouldBindJSON(binLogParams); err != nil {
+		metrics.ReaderDecodeErrors.WithLabelValues(h.conf.GetUniqueId()).Inc()
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"code": http.StatusBadRequest, "message": err.Error(),
 		})
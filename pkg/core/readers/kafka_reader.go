@@ -2,10 +2,11 @@ package readers
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"github.com/Junjiayy/hamal/pkg/metrics"
 	"github.com/Junjiayy/hamal/pkg/tools"
 	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
 	"github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/sasl/plain"
 	"reflect"
@@ -16,7 +17,8 @@ import (
 
 type KafkaReader struct {
 	ReaderBase
-	kr *kafka.Reader
+	kr      *kafka.Reader
+	decoder MessageDecoder
 }
 
 func NewKafkaReaderFunc(conf ReaderConfig, wg *sync.WaitGroup, parent context.Context) (Reader, error) {
@@ -24,6 +26,10 @@ func NewKafkaReaderFunc(conf ReaderConfig, wg *sync.WaitGroup, parent context.Co
 	if !ok {
 		return nil, configAssertErr
 	}
+	decoder := GetMessageDecoder(config.Format)
+	if decoder == nil {
+		return nil, errors.Errorf("unsupported kafka message format: %s", config.Format)
+	}
 	var dialer *kafka.Dialer
 	if config.Username != "" && config.Password != "" {
 		dialer = &kafka.Dialer{SASLMechanism: plain.Mechanism{Username: config.Username, Password: config.Password}}
@@ -38,23 +44,38 @@ func NewKafkaReaderFunc(conf ReaderConfig, wg *sync.WaitGroup, parent context.Co
 
 	return &KafkaReader{
 		kr:         kafka.NewReader(readerConfig),
+		decoder:    decoder,
 		ReaderBase: NewReaderBase(config, parent),
 	}, nil
 }
 
 func (k *KafkaReader) Read() (*types.BinlogParams, error) {
-	message, err := k.kr.FetchMessage(k.ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	binLogParams := new(types.BinlogParams)
-	if err := json.Unmarshal(message.Value, binLogParams); err != nil {
-		return nil, err
+	for {
+		message, err := k.kr.FetchMessage(k.ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		binLogParams, err := k.decoder(message.Value)
+		if err != nil {
+			metrics.ReaderDecodeErrors.WithLabelValues(k.conf.GetUniqueId()).Inc()
+			return nil, err
+		}
+		if binLogParams == nil {
+			// 不需要处理的消息直接提交，Read 和 Complete 顺序调用，之前的消息都已经处理完成
+			if err := k.kr.CommitMessages(k.ctx, message); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if binLogParams.EventId == "" {
+			binLogParams.EventId = fmt.Sprintf("%s-%d-%d", message.Topic, message.Partition, message.Offset)
+		}
+		binLogParams.Source = message
+
+		return binLogParams, nil
 	}
-	binLogParams.Source = message
-
-	return binLogParams, nil
 }
 
 func (k *KafkaReader) Complete(params *types.BinlogParams) error {
@@ -82,6 +103,7 @@ type KafkaReaderConfig struct {
 	MaxWait        time.Duration `json:"max_wait,omitempty" yaml:"max_wait,omitempty" default:"1s"`
 	CommitInterval time.Duration `json:"commit_interval,omitempty" yaml:"commit_interval,omitempty" default:"1s"`
 	QueueCapacity  int           `json:"queue_capacity,omitempty" yaml:"queue_capacity,omitempty" default:"1000"`
+	Format         string        `json:"format,omitempty" yaml:"format,omitempty" default:"canal"` // 消息格式 canal|debezium|maxwell
 }
 
 func NewKafkaReaderConfigFunc() interface{} {
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"github.com/Junjiayy/hamal/pkg/tools"
 	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
 	"sync"
 	"sync/atomic"
 )
@@ -48,6 +49,8 @@ func init() {
 	SetReaderConfigConstructor(types.ReaderTypeWeb, NewHttpReaderConfigFunc)
 	SetReaderConstructor(types.ReaderTypeKafka, NewKafkaReaderFunc)
 	SetReaderConfigConstructor(types.ReaderTypeKafka, NewKafkaReaderConfigFunc)
+	SetReaderConstructor(types.ReaderTypeBinlog, NewBinlogReaderFunc)
+	SetReaderConfigConstructor(types.ReaderTypeBinlog, NewBinlogReaderConfigFunc)
 }
 
 // UnmarshalJSON 根据type 获取到具体到 config 结构体，并重新序列化赋值
@@ -57,6 +60,9 @@ func (r *ReaderConfigByType) UnmarshalJSON(bytes []byte) error {
 		return err
 	}
 	configConstructor := GetReaderConfigConstructor(innerConfig.Type)
+	if configConstructor == nil {
+		return errors.Errorf("reader config constructor not exists: %s", innerConfig.Type)
+	}
 	config := configConstructor()
 	configBytes, err := json.Marshal(innerConfig.Config)
 	if err != nil {
@@ -70,6 +76,11 @@ func (r *ReaderConfigByType) UnmarshalJSON(bytes []byte) error {
 	return nil
 }
 
+// MarshalJSON 序列化为 type 和 config 格式，和 UnmarshalJSON 对应
+func (r ReaderConfigByType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(innerReaderConfigByType{Type: r.Type, Config: r.Config})
+}
+
 // SetReaderConstructor 注册读取器构造函数
 func SetReaderConstructor(name string, fn ReaderConstructor) {
 	_readerConstructors[name] = fn
@@ -120,4 +131,4 @@ func (r *ReaderBase) FirstClose() bool {
 	}
 
 	return false
-}
\ No newline at end of file
+}
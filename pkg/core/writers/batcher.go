@@ -0,0 +1,92 @@
+package writers
+
+import (
+	"sync"
+	"time"
+)
+
+// batcher 合并多个协程的写入请求批量执行
+// 记录数达到 size 或 第一条记录等待超过 interval 时执行 flush，add 阻塞到所在批次执行结束
+// 同步任务在携程池中并发执行，一批记录来自不同的任务，每个任务都能拿到自己记录的执行结果
+type batcher struct {
+	size     int
+	interval time.Duration
+	flush    func(items []interface{}) []error // 返回每条记录的错误，nil 代表全部成功
+	mux      sync.Mutex
+	items    []interface{}
+	dones    []chan error
+	timer    *time.Timer
+}
+
+func newBatcher(size int, interval time.Duration, flush func(items []interface{}) []error) *batcher {
+	return &batcher{size: size, interval: interval, flush: flush}
+}
+
+// add 添加一条记录到当前批次，等待批次执行结束后返回这条记录的错误
+func (b *batcher) add(item interface{}) error {
+	done := make(chan error, 1)
+
+	b.mux.Lock()
+	b.items, b.dones = append(b.items, item), append(b.dones, done)
+	if len(b.items) >= b.size {
+		items, dones := b.take()
+		b.mux.Unlock()
+		b.execute(items, dones)
+	} else {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.interval, b.flushPending)
+		}
+		b.mux.Unlock()
+	}
+
+	return <-done
+}
+
+// flushPending 执行当前等待中的批次，由定时器调用
+func (b *batcher) flushPending() {
+	b.mux.Lock()
+	if len(b.items) == 0 {
+		b.mux.Unlock()
+		return
+	}
+	items, dones := b.take()
+	b.mux.Unlock()
+
+	b.execute(items, dones)
+}
+
+// take 取出当前批次，调用时需要持有锁
+func (b *batcher) take() ([]interface{}, []chan error) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	items, dones := b.items, b.dones
+	b.items, b.dones = nil, nil
+
+	return items, dones
+}
+
+func (b *batcher) execute(items []interface{}, dones []chan error) {
+	errArr := b.flush(items)
+	for i, done := range dones {
+		var err error
+		if errArr != nil {
+			err = errArr[i]
+		}
+		done <- err
+	}
+}
+
+// batchErrors 整批执行失败时，每条记录都返回同一个错误
+func batchErrors(size int, err error) []error {
+	if err == nil {
+		return nil
+	}
+	errArr := make([]error, size)
+	for i := range errArr {
+		errArr[i] = err
+	}
+
+	return errArr
+}
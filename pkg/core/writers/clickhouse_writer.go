@@ -0,0 +1,113 @@
+package writers
+
+import (
+	"context"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+const (
+	clickHouseVersionColumn = "version"    // ReplacingMergeTree 版本字段，取事件时间
+	clickHouseDeletedColumn = "is_deleted" // 删除标识字段 1 代表已删除
+)
+
+// ClickHouseWriter 写入 ReplacingMergeTree 表
+// 新增、更新 和 删除都转换为写入一条完整的记录，通过版本字段保留最新的记录，删除写入 is_deleted = 1 的墓碑记录
+// 目标表需要包含 version 和 is_deleted 字段，例如 ENGINE = ReplacingMergeTree(version)
+type ClickHouseWriter struct {
+	*writer
+	batchers map[string]*clickHouseBatcher // key 为 连接名.库.表
+	mux      sync.Mutex
+}
+
+// clickHouseBatcher 同一个连接同一张表的批量写入器
+type clickHouseBatcher struct {
+	*batcher
+	cli *datasources.ClickHouseClient
+}
+
+func NewClickHouseWriter(source datasources.DataSource) Writer {
+	return &ClickHouseWriter{
+		writer:   &writer{dataSources: source},
+		batchers: make(map[string]*clickHouseBatcher),
+	}
+}
+
+func (w *ClickHouseWriter) Insert(params *types.SyncParams, _ interface{}) error {
+	return w.write(params, false)
+}
+
+func (w *ClickHouseWriter) Update(params *types.SyncParams, _ interface{}) error {
+	return w.write(params, false)
+}
+
+func (w *ClickHouseWriter) Delete(params *types.SyncParams) error {
+	return w.write(params, true)
+}
+
+// write 写入一条完整的记录，更新事件只包含被修改的字段，所以不使用 values，直接通过 Data 构建记录
+func (w *ClickHouseWriter) write(params *types.SyncParams, deleted bool) error {
+	if params.Rule.SyncType != types.SyncTypeCopy {
+		return errors.New("clickhouse writer only support copy")
+	}
+	b, err := w.getBatcher(&params.Rule)
+	if err != nil {
+		return err
+	}
+
+	return b.add(newClickHouseRow(params, deleted))
+}
+
+// getBatcher 获取批量写入器，连接变更后重新创建
+func (w *ClickHouseWriter) getBatcher(rule *types.SyncRule) (*clickHouseBatcher, error) {
+	cliInter, err := w.dataSources.GetDataSource(rule.Target)
+	if err != nil {
+		return nil, err
+	}
+	cli := cliInter.(*datasources.ClickHouseClient)
+	key := rule.Target + "." + rule.TargetDatabase + "." + rule.TargetTable
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if b, ok := w.batchers[key]; ok && b.cli == cli {
+		return b, nil
+	}
+
+	database, table := rule.TargetDatabase, rule.TargetTable
+	b := &clickHouseBatcher{cli: cli}
+	b.batcher = newBatcher(cli.BatchSize, cli.FlushInterval, func(items []interface{}) []error {
+		rows := make([]map[string]interface{}, 0, len(items))
+		for _, item := range items {
+			rows = append(rows, item.(map[string]interface{}))
+		}
+
+		return batchErrors(len(items), cli.Insert(context.Background(), database, table, rows))
+	})
+	w.batchers[key] = b
+
+	return b, nil
+}
+
+// newClickHouseRow 构建写入的记录，包含全部映射字段 (转换后)、额外参数、版本 和 删除标识
+// 来源数据中不存在的字段不写入，使用 clickhouse 的默认值
+func newClickHouseRow(params *types.SyncParams, deleted bool) map[string]interface{} {
+	values := getAllUpdateValues(params).(map[string]interface{})
+	for local, target := range params.Rule.Columns {
+		if _, ok := params.Data[local]; !ok {
+			delete(values, target)
+		}
+	}
+	row := make(map[string]interface{}, len(values)+2)
+	for column, value := range values {
+		row[column] = sqlValue(value)
+	}
+
+	row[clickHouseVersionColumn], row[clickHouseDeletedColumn] = params.GetBingLogParams().EventAt, 0
+	if deleted {
+		row[clickHouseDeletedColumn] = 1
+	}
+
+	return row
+}
@@ -0,0 +1,122 @@
+package writers
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	var (
+		mux     sync.Mutex
+		batches [][]interface{}
+	)
+	b := newBatcher(3, 50*time.Millisecond, func(items []interface{}) []error {
+		mux.Lock()
+		defer mux.Unlock()
+		batches = append(batches, items)
+
+		errArr := make([]error, len(items))
+		for i, item := range items {
+			if item.(int) == 4 {
+				errArr[i] = net.ErrClosed
+			}
+		}
+		return errArr
+	})
+
+	var wg sync.WaitGroup
+	errArr := make([]error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errArr[i] = b.add(i)
+		}(i)
+	}
+	wg.Wait()
+
+	// 前 3 条记录达到批次大小立即执行，剩余 2 条等待超时后执行
+	if len(batches) != 2 || len(batches[0]) != 3 || len(batches[1]) != 2 {
+		t.Fatalf("batches error: %v", batches)
+	}
+	for i, err := range errArr {
+		if (i == 4) != (err != nil) {
+			t.Fatalf("record %d error mismatch: %v", i, err)
+		}
+	}
+}
+
+func TestClickHouseWriter(t *testing.T) {
+	var (
+		mux  sync.Mutex
+		rows []map[string]interface{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if query := r.URL.Query().Get("query"); query != "INSERT INTO `orders` FORMAT JSONEachRow" {
+			http.Error(w, "unexpected query "+query, http.StatusBadRequest)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			row := make(map[string]interface{})
+			_ = json.Unmarshal(scanner.Bytes(), &row)
+			rows = append(rows, row)
+		}
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	dataSource := datasources.NewClickHouseDataSource()
+	if err := dataSource.SetConfigs(map[string]datasources.DataSourceConfig{
+		"test": {Name: "test", Host: host, Port: portNum, Target: "reports", BatchSize: 2,
+			FlushInterval: 10 * time.Millisecond},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cw := NewClickHouseWriter(dataSource)
+
+	rule := types.SyncRule{
+		Target: "test", TargetTable: "orders", PrimaryKey: "id", SyncType: types.SyncTypeCopy,
+		Columns:           map[string]string{"id": "id", "price": "trans_price"},
+		TargetExtraParams: map[string]string{"source": "hamal"},
+	}
+	binLog := &types.BinlogParams{EventAt: 1709885119000}
+	params := &types.SyncParams{Rule: rule, Data: map[string]string{"id": "1", "price": "5000"}}
+	params.SetBinLogParams(binLog)
+
+	if err := cw.Update(params, map[string]string{"trans_price": "5000"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Delete(params); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 {
+		t.Fatalf("should insert 2 rows, current: %v", rows)
+	}
+	// 更新写入完整记录，删除写入墓碑记录
+	if rows[0]["id"] != "1" || rows[0]["trans_price"] != "5000" || rows[0]["source"] != "hamal" ||
+		rows[0]["version"] != float64(1709885119000) || rows[0]["is_deleted"] != float64(0) {
+		t.Fatalf("update row error: %v", rows[0])
+	}
+	if rows[1]["id"] != "1" || rows[1]["is_deleted"] != float64(1) {
+		t.Fatalf("delete row error: %v", rows[1])
+	}
+
+	params.Rule.SyncType = types.SyncTypeJoin
+	if err := cw.Insert(params, nil); err == nil {
+		t.Fatal("clickhouse writer should not support join")
+	}
+}
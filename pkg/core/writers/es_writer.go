@@ -7,45 +7,90 @@ import (
 	"github.com/olivere/elastic/v7"
 	"github.com/pkg/errors"
 	"strings"
+	"sync"
 	"time"
 )
 
+// ElasticSearchWriter 写入 es
+// 数据源配置的 batch_size 大于 1 时开启批量模式，同一个连接的写入合并为 _bulk 请求
+// 规则配置了 version_column 时使用事件时间作为 external_gte 版本写入完整文档，过期的事件返回版本冲突并被忽略
+// 版本写入会替换整个文档，同一个索引不能同时配置 join 和 inner 规则，见 types.ValidateRules
 type ElasticSearchWriter struct {
 	*writer
+	bulkers map[string]*esBulker // key 为连接名称
+	mux     sync.Mutex
 }
 
+type (
+	// esBulker 同一个连接的批量写入器
+	esBulker struct {
+		*batcher
+		cli *elastic.Client
+	}
+
+	// esBulkItem 批量模式下的一个操作
+	esBulkItem struct {
+		req       elastic.BulkableRequest
+		versioned bool // 使用外部版本，版本冲突代表事件已过期
+	}
+)
+
 func NewElasticSearchWriter(source datasources.DataSource) Writer {
-	return &ElasticSearchWriter{&writer{dataSources: source}}
+	return &ElasticSearchWriter{writer: &writer{dataSources: source}}
 }
 
 const (
 	updateInnerJoinScriptTpl = "if(ctx._source.:key == null) { ctx._source.:key = [params.value] } else if(!ctx._source.:key.contains(params.value)) { ctx._source.:key.add(params.value) }"
 	deleteInnerJoinScriptTpl = "if(ctx._source.:key != null && ctx._source.:key.contains(params.value)) { ctx._source.:key.remove(ctx._source.:key.indexOf(params.value)) }"
 	removeFieldScriptTpl     = "if(ctx._source.:key != null) {ctx._source.remove(':key')}"
+
+	esDefaultTimeout       = 1 * time.Second        // 默认请求超时时间
+	esDefaultFlushInterval = 100 * time.Millisecond // 批量模式默认每批最长等待时间
+	// esVersionType 事件时间单位为毫秒，但 binlog 事件头的时间只精确到秒 (binlog reader、maxwell、debezium 的 source.ts_ms)
+	// 同一秒内的多次修改版本相同，使用 external_gte 允许相同版本覆盖，否则同一秒内的后续修改会被丢弃
+	esVersionType = "external_gte"
 )
 
 func (e *ElasticSearchWriter) Insert(params *types.SyncParams, values interface{}) error {
-	cliInter, err := e.dataSources.GetDataSource(params.Rule.Target)
+	cli, conf, err := e.getCli(params)
 	if err != nil {
 		return err
 	}
 
-	cli, primaryKeyValue := cliInter.(*elastic.Client), params.Data[params.Rule.PrimaryKey]
-	updateService := elastic.NewUpdateService(cli).Index(params.Rule.TargetTable).Id(primaryKeyValue)
-	timeout, cancelFunc := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancelFunc()
+	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
+	if isEsVersioned(params) {
+		return e.index(cli, &conf, params)
+	}
 
+	var script *elastic.Script
 	if params.Rule.SyncType == types.SyncTypeInner {
+		joinValue := params.GetJoinValue()
 		scriptStr := strings.ReplaceAll(updateInnerJoinScriptTpl, ":key", params.Rule.JoinFieldName)
-		updateService.Script(elastic.NewScriptInline(scriptStr).Param("value", values))
+		script = elastic.NewScriptInline(scriptStr).Param("value", joinValue)
 		values = map[string]interface{}{
-			params.Rule.JoinFieldName:                   []string{values.(string)},
-			params.Rule.Columns[params.Rule.PrimaryKey]: primaryKeyValue,
+			params.Rule.JoinFieldName:                   []interface{}{joinValue},
+			params.Rule.Columns[params.Rule.PrimaryKey]: params.GetValue(params.Rule.PrimaryKey),
 		}
+	}
+
+	if conf.BatchSize > 1 {
+		req := elastic.NewBulkUpdateRequest().Index(params.Rule.TargetTable).Id(primaryKeyValue)
+		if script != nil {
+			req.Script(script)
+		} else {
+			req.Doc(values)
+		}
+		return e.getBulker(params.Rule.Target, cli, &conf).add(&esBulkItem{req: req.Upsert(values)})
+	}
+
+	updateService := elastic.NewUpdateService(cli).Index(params.Rule.TargetTable).Id(primaryKeyValue)
+	if script != nil {
+		updateService.Script(script)
 	} else {
 		updateService.Doc(values)
 	}
-
+	timeout, cancelFunc := context.WithTimeout(context.Background(), esTimeout(&conf))
+	defer cancelFunc()
 	if _, err = updateService.Upsert(values).Do(timeout); err != nil {
 		return errors.WithStack(err)
 	}
@@ -59,36 +104,165 @@ func (e *ElasticSearchWriter) Update(params *types.SyncParams, values interface{
 }
 
 func (e *ElasticSearchWriter) Delete(params *types.SyncParams) error {
-	cliInter, err := e.dataSources.GetDataSource(params.Rule.Target)
+	cli, conf, err := e.getCli(params)
 	if err != nil {
 		return err
 	}
-	cli, primaryKeyValue := cliInter.(*elastic.Client), params.Data[params.Rule.PrimaryKey]
-	timeout, cancelFunc := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancelFunc()
-
-	if params.Rule.SyncType == types.SyncTypeCopy {
-		_, err := elastic.NewDeleteService(cli).Index(params.Rule.TargetTable).
-			Id(primaryKeyValue).Do(timeout)
-		return errors.WithStack(err)
-	}
-
-	updateService := elastic.NewUpdateService(cli).Index(params.Rule.TargetTable).Id(primaryKeyValue)
+	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
 
 	var script *elastic.Script
 	switch params.Rule.SyncType {
 	case types.SyncTypeInner:
-		updateValue := params.Data[params.GetJoinColumn()]
 		scriptStr := strings.ReplaceAll(deleteInnerJoinScriptTpl, ":key", params.Rule.JoinFieldName)
-		script = elastic.NewScriptInline(scriptStr).Param("value", updateValue)
+		script = elastic.NewScriptInline(scriptStr).Param("value", params.GetJoinValue())
 	case types.SyncTypeJoin:
 		scriptStr := strings.ReplaceAll(removeFieldScriptTpl, ":key", params.Rule.JoinFieldName)
 		script = elastic.NewScriptInline(scriptStr)
 	}
 
+	versioned := isEsVersioned(params)
+	if conf.BatchSize > 1 {
+		var req elastic.BulkableRequest
+		if params.Rule.SyncType != types.SyncTypeCopy {
+			req = elastic.NewBulkUpdateRequest().Index(params.Rule.TargetTable).Id(primaryKeyValue).Script(script)
+		} else if deleteReq := elastic.NewBulkDeleteRequest().Index(params.Rule.TargetTable).
+			Id(primaryKeyValue); versioned {
+			req = deleteReq.Version(params.GetVersion()).VersionType(esVersionType)
+		} else {
+			req = deleteReq
+		}
+		return e.getBulker(params.Rule.Target, cli, &conf).add(&esBulkItem{req: req, versioned: versioned})
+	}
+
+	timeout, cancelFunc := context.WithTimeout(context.Background(), esTimeout(&conf))
+	defer cancelFunc()
+
+	if params.Rule.SyncType == types.SyncTypeCopy {
+		deleteService := elastic.NewDeleteService(cli).Index(params.Rule.TargetTable).Id(primaryKeyValue)
+		if versioned {
+			deleteService.Version(params.GetVersion()).VersionType(esVersionType)
+		}
+		if _, err := deleteService.Do(timeout); err != nil && !(versioned && elastic.IsConflict(err)) {
+			return errors.WithStack(err)
+		}
+		return nil
+	}
+
+	updateService := elastic.NewUpdateService(cli).Index(params.Rule.TargetTable).Id(primaryKeyValue)
 	if _, err := updateService.Script(script).Do(timeout); err != nil {
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
+
+// index 使用外部版本写入完整文档，更新事件只包含被修改的字段，所以使用全部映射字段
+func (e *ElasticSearchWriter) index(cli *elastic.Client, conf *datasources.DataSourceConfig,
+	params *types.SyncParams) error {
+	primaryKeyValue, values := params.Data[params.Rule.PrimaryKey], getAllUpdateValues(params)
+	if conf.BatchSize > 1 {
+		req := elastic.NewBulkIndexRequest().Index(params.Rule.TargetTable).Id(primaryKeyValue).
+			Version(params.GetVersion()).VersionType(esVersionType).Doc(values)
+		return e.getBulker(params.Rule.Target, cli, conf).add(&esBulkItem{req: req, versioned: true})
+	}
+
+	timeout, cancelFunc := context.WithTimeout(context.Background(), esTimeout(conf))
+	defer cancelFunc()
+	_, err := elastic.NewIndexService(cli).Index(params.Rule.TargetTable).Id(primaryKeyValue).
+		Version(params.GetVersion()).VersionType(esVersionType).BodyJson(values).Do(timeout)
+	if err != nil && !elastic.IsConflict(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// isEsVersioned 是否使用外部版本写入，只支持 copy
+func isEsVersioned(params *types.SyncParams) bool {
+	return params.Rule.VersionColumn != "" && params.Rule.SyncType == types.SyncTypeCopy
+}
+
+// getCli 获取 es 客户端 和 连接配置
+func (e *ElasticSearchWriter) getCli(params *types.SyncParams) (*elastic.Client, datasources.DataSourceConfig, error) {
+	cliInter, err := e.dataSources.GetDataSource(params.Rule.Target)
+	if err != nil {
+		return nil, datasources.DataSourceConfig{}, err
+	}
+	conf, err := e.dataSources.GetConfig(params.Rule.Target)
+	if err != nil {
+		return nil, datasources.DataSourceConfig{}, err
+	}
+
+	return cliInter.(*elastic.Client), conf, nil
+}
+
+// getBulker 获取连接的批量写入器，连接变更后重新创建
+func (e *ElasticSearchWriter) getBulker(name string, cli *elastic.Client, conf *datasources.DataSourceConfig) *esBulker {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if b, ok := e.bulkers[name]; ok && b.cli == cli {
+		return b
+	}
+	if e.bulkers == nil {
+		e.bulkers = make(map[string]*esBulker)
+	}
+
+	flushInterval, timeout := conf.FlushInterval, esTimeout(conf)
+	if flushInterval <= 0 {
+		flushInterval = esDefaultFlushInterval
+	}
+	b := &esBulker{cli: cli}
+	b.batcher = newBatcher(conf.BatchSize, flushInterval, func(items []interface{}) []error {
+		return flushEsBulk(cli, timeout, items)
+	})
+	e.bulkers[name] = b
+
+	return b
+}
+
+// flushEsBulk 执行 _bulk 请求，把每个操作的结果映射回对应的记录
+// 响应中 items 的顺序和请求中操作的顺序一致
+func flushEsBulk(cli *elastic.Client, timeout time.Duration, items []interface{}) []error {
+	bulkService := cli.Bulk()
+	for _, item := range items {
+		bulkService.Add(item.(*esBulkItem).req)
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), timeout)
+	defer cancelFunc()
+
+	resp, err := bulkService.Do(ctx)
+	if err != nil {
+		return batchErrors(len(items), errors.WithStack(err))
+	} else if len(resp.Items) != len(items) {
+		return batchErrors(len(items), errors.Errorf("bulk response items %d mismatch requests %d",
+			len(resp.Items), len(items)))
+	}
+
+	var errArr []error
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Error == nil && result.Status < 300 {
+				continue
+			} else if result.Status == 409 && items[i].(*esBulkItem).versioned {
+				// 外部版本冲突，事件已过期
+				continue
+			}
+			if errArr == nil {
+				errArr = make([]error, len(items))
+			}
+			// 转换为 elastic.Error，和非批量模式一样由 isElasticSearchRetryableErr 判断是否可以重试
+			errArr[i] = errors.WithStack(&elastic.Error{Status: result.Status, Details: result.Error})
+		}
+	}
+
+	return errArr
+}
+
+// esTimeout 获取请求超时时间
+func esTimeout(conf *datasources.DataSourceConfig) time.Duration {
+	if conf.Timeout > 0 {
+		return conf.Timeout
+	}
+
+	return esDefaultTimeout
+}
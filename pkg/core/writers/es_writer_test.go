@@ -1,13 +1,22 @@
 package writers
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/Junjiayy/hamal/pkg/core/datasources"
 	"github.com/Junjiayy/hamal/pkg/types"
 	"github.com/olivere/elastic/v7"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 var (
@@ -29,7 +38,7 @@ func initEsWriter(t *testing.T) {
 		cli.(*elastic.Client).DeleteByQuery("test_user_trans_records").
 			Query(elastic.NewMatchAllQuery()).Do(context.Background())
 
-		ew = ElasticSearchWriter{&writer{dataSources: dataSource}}
+		ew = ElasticSearchWriter{writer: &writer{dataSources: dataSource}}
 	})
 }
 
@@ -84,3 +93,135 @@ func TestElasticSearchWriter_Insert(t *testing.T) {
 		t.Fatalf("_id != id, _id: %s, id: %v", firstHit.Id, insertRecord["id"])
 	}
 }
+
+func TestElasticSearchWriter_Bulk(t *testing.T) {
+	var (
+		mux      sync.Mutex
+		requests int
+		actions  []map[string]map[string]interface{}
+	)
+	// 模拟 es _bulk 接口，id 为 2 的记录返回 429
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/_bulk" {
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		requests++
+
+		var items []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			action := make(map[string]map[string]interface{})
+			_ = json.Unmarshal(scanner.Bytes(), &action)
+			for name, meta := range action {
+				if name != "update" && name != "delete" {
+					continue
+				}
+				actions = append(actions, action)
+				status := 200
+				if meta["_id"] == "2" {
+					status = http.StatusTooManyRequests
+				}
+				items = append(items, fmt.Sprintf(`{%q:{"_id":%q,"status":%d}}`, name, meta["_id"], status))
+				if name == "update" {
+					scanner.Scan() // 跳过 update 的请求体
+				}
+			}
+		}
+		_, _ = fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	dataSource := datasources.NewElasticSearchDataSource()
+	if err := dataSource.SetConfigs(map[string]datasources.DataSourceConfig{
+		"test": {Name: "test", Host: host, Port: portNum, BatchSize: 3, FlushInterval: time.Second},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	bw := NewElasticSearchWriter(dataSource)
+
+	var wg sync.WaitGroup
+	errArr := make([]error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			params := &types.SyncParams{
+				Rule: types.SyncRule{
+					Target: "test", PrimaryKey: "id", TargetTable: "orders", SyncType: types.SyncTypeCopy,
+				},
+				Data: map[string]string{"id": strconv.Itoa(i + 1)},
+			}
+			if i == 2 {
+				errArr[i] = bw.Delete(params)
+			} else {
+				errArr[i] = bw.Insert(params, map[string]string{"id": strconv.Itoa(i + 1)})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 达到批次大小立即执行，3 个操作合并为一个 _bulk 请求
+	if requests != 1 || len(actions) != 3 {
+		t.Fatalf("should send one bulk request with 3 actions, requests: %d, actions: %v", requests, actions)
+	}
+	// 只有失败的记录返回错误，并且可以重试
+	if errArr[0] != nil || errArr[2] != nil {
+		t.Fatalf("successful records should not return error: %v", errArr)
+	}
+	if errArr[1] == nil || !IsRetryableErr(types.DataSourceElasticSearch, errArr[1]) {
+		t.Fatalf("too many requests should be retryable error: %v", errArr[1])
+	}
+}
+
+func TestElasticSearchWriter_Version(t *testing.T) {
+	var query url.Values
+	var body map[string]interface{}
+	// 模拟 es 已存在更新版本的文档，写入返回版本冲突
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/orders/_doc/1" {
+			_, _ = w.Write([]byte(`{}`))
+			return
+		}
+		query = r.URL.Query()
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":{"type":"version_conflict_engine_exception"},"status":409}`))
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	dataSource := datasources.NewElasticSearchDataSource()
+	if err := dataSource.SetConfigs(map[string]datasources.DataSourceConfig{
+		"test": {Name: "test", Host: host, Port: portNum},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	vw := NewElasticSearchWriter(dataSource)
+
+	rule := &types.SyncRule{
+		Target: "test", PrimaryKey: "id", TargetTable: "orders", SyncType: types.SyncTypeCopy,
+		Columns: map[string]string{"id": "id", "price": "trans_price"}, VersionColumn: "version",
+	}
+	params := types.NewSyncParams(types.NewSyncWaitGroup(), rule, map[string]string{"id": "1", "price": "4500"},
+		map[string]string{"price": "5000"}, &types.BinlogParams{EventType: types.EventTypeUpdate, EventAt: 1709885119000})
+
+	// 过期的事件不返回错误
+	if err := vw.Update(params, params.GetUpdateValues([]string{"price"})); err != nil {
+		t.Fatalf("stale event should be ignored: %v", err)
+	}
+	if query.Get("version") != "1709885119000" || query.Get("version_type") != "external_gte" {
+		t.Fatalf("index request should use external version: %v", query)
+	}
+	// 使用外部版本时写入完整文档
+	if body["id"] != "1" || body["trans_price"] != "4500" || body["version"] != float64(1709885119000) {
+		t.Fatalf("index request should write full document: %v", body)
+	}
+}
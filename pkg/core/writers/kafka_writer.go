@@ -0,0 +1,84 @@
+package writers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+const kafkaWriteTimeout = 3 * time.Second // 单次发送超时时间
+
+type (
+	// KafkaWriter 把映射后的变更事件发送到 TargetTable 同名的 topic
+	// 消息 key 为加锁字段的值，同一条记录的变更发送到同一个分区，保证下游消费顺序
+	KafkaWriter struct {
+		*writer
+	}
+
+	// KafkaMessage 发送到 kafka 的变更事件
+	KafkaMessage struct {
+		EventType  string      `json:"event_type"`  // 真实执行的事件类型 insert|update|delete
+		Database   string      `json:"database"`    // 来源库
+		Table      string      `json:"table"`       // 来源表
+		PrimaryKey string      `json:"primary_key"` // 主键值
+		EventAt    int64       `json:"event_at"`    // 事件时间 毫秒
+		Data       interface{} `json:"data"`        // 映射后的数据，格式和 SyncParams.GetUpdateValues 一致
+	}
+)
+
+func NewKafkaWriter(source datasources.DataSource) Writer {
+	return &KafkaWriter{&writer{dataSources: source}}
+}
+
+func (w *KafkaWriter) Insert(params *types.SyncParams, values interface{}) error {
+	return w.publish(params, values)
+}
+
+func (w *KafkaWriter) Update(params *types.SyncParams, values interface{}) error {
+	return w.publish(params, values)
+}
+
+// Delete 发送删除事件，数据为被删除记录的全部映射字段
+func (w *KafkaWriter) Delete(params *types.SyncParams) error {
+	return w.publish(params, getAllUpdateValues(params))
+}
+
+func (w *KafkaWriter) publish(params *types.SyncParams, values interface{}) error {
+	cliInter, err := w.dataSources.GetDataSource(params.Rule.Target)
+	if err != nil {
+		return err
+	}
+	message, err := newKafkaMessage(params, values)
+	if err != nil {
+		return err
+	}
+	timeout, cancelFunc := context.WithTimeout(context.Background(), kafkaWriteTimeout)
+	defer cancelFunc()
+
+	return errors.WithStack(cliInter.(*kafka.Writer).WriteMessages(timeout, message))
+}
+
+// newKafkaMessage 构建 kafka 消息，topic 为 TargetTable，key 为加锁字段的值
+func newKafkaMessage(params *types.SyncParams, values interface{}) (kafka.Message, error) {
+	var eventAt int64
+	if binLog := params.GetBingLogParams(); binLog != nil {
+		eventAt = binLog.EventAt
+	}
+	database, table := params.GetSource()
+	content, err := json.Marshal(&KafkaMessage{
+		EventType: params.RealEventType, Database: database, Table: table,
+		PrimaryKey: params.Data[params.Rule.PrimaryKey], EventAt: eventAt, Data: values,
+	})
+	if err != nil {
+		return kafka.Message{}, errors.WithStack(err)
+	}
+
+	return kafka.Message{
+		Topic: params.Rule.TargetTable, Key: []byte(params.GetIdentifyId()), Value: content,
+		Headers: []kafka.Header{{Key: "event_type", Value: []byte(params.RealEventType)}},
+	}, nil
+}
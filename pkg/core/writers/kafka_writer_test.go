@@ -0,0 +1,55 @@
+package writers
+
+import (
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/segmentio/kafka-go"
+	"testing"
+)
+
+func TestNewKafkaMessage(t *testing.T) {
+	rule := &types.SyncRule{
+		Database: "test", Table: "orders_*", PrimaryKey: "id", LockColumns: []string{"user_id", "id"},
+		TargetTable: "orders_changes", SyncType: types.SyncTypeCopy,
+		Columns: map[string]string{"id": "id", "user_id": "uid", "price": "trans_price"},
+	}
+	binLog := &types.BinlogParams{
+		Database: "test", Table: "orders_3", EventType: types.EventTypeUpdate, EventAt: 1709885119000,
+	}
+	params := types.NewSyncParams(types.NewSyncWaitGroup(), rule,
+		map[string]string{"id": "1", "user_id": "2", "price": "4500"}, map[string]string{"price": "5000"}, binLog)
+	params.RealEventType = types.EventTypeInsert
+
+	message, err := newKafkaMessage(params, params.GetUpdateValues([]string{"price"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Topic != "orders_changes" || string(message.Key) != "2-1" {
+		t.Fatalf("message topic or key error: %s %s", message.Topic, message.Key)
+	}
+
+	var content struct {
+		KafkaMessage
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(message.Value, &content); err != nil {
+		t.Fatal(err)
+	}
+	// 事件类型为真实执行的事件类型，通配规则使用实际的来源表名
+	if content.EventType != types.EventTypeInsert || content.PrimaryKey != "1" || content.EventAt != 1709885119000 ||
+		content.Database != "test" || content.Table != "orders_3" || content.Data["trans_price"] != "4500" {
+		t.Fatalf("message content error: %s", message.Value)
+	}
+}
+
+func TestIsKafkaRetryableErr(t *testing.T) {
+	if !IsRetryableErr(types.DataSourceKafka, kafka.LeaderNotAvailable) {
+		t.Fatal("leader not available should be retryable")
+	}
+	if IsRetryableErr(types.DataSourceKafka, kafka.MessageSizeTooLarge) {
+		t.Fatal("message too large should not be retryable")
+	}
+	if IsRetryableErr(types.DataSourceKafka, kafka.WriteErrors{nil, kafka.MessageSizeTooLarge}) {
+		t.Fatal("write errors should not be retryable if any error is not retryable")
+	}
+}
@@ -0,0 +1,147 @@
+package writers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const mongoWriteTimeout = time.Second // 单次写入超时时间
+
+// MongoWriter 写入 mongodb 文档，文档通过映射后的主键字段定位，全部写入都是 upsert
+// copy 使用 $set 写入字段，join 使用 $set 写入子文档的字段，inner 使用 $addToSet 和 $pull 维护数组
+type MongoWriter struct {
+	*writer
+}
+
+func NewMongoWriter(source datasources.DataSource) Writer {
+	return &MongoWriter{&writer{dataSources: source}}
+}
+
+func (w *MongoWriter) Insert(params *types.SyncParams, values interface{}) error {
+	collection, err := w.getCollection(params)
+	if err != nil {
+		return err
+	}
+	update, err := buildMongoUpsert(params, values)
+	if err != nil {
+		return err
+	}
+	timeout, cancelFunc := context.WithTimeout(context.Background(), mongoWriteTimeout)
+	defer cancelFunc()
+
+	_, err = collection.UpdateOne(timeout, mongoFilter(params), update, options.Update().SetUpsert(true))
+
+	return errors.WithStack(err)
+}
+
+func (w *MongoWriter) Update(params *types.SyncParams, values interface{}) error {
+	// types.SyncTypeJoin 不存在 update 事件
+	return w.Insert(params, values)
+}
+
+// Delete 和 ElasticSearchWriter.Delete 一致
+// copy 删除文档，join 删除子文档字段，inner 从数组中移除
+func (w *MongoWriter) Delete(params *types.SyncParams) error {
+	collection, err := w.getCollection(params)
+	if err != nil {
+		return err
+	}
+	timeout, cancelFunc := context.WithTimeout(context.Background(), mongoWriteTimeout)
+	defer cancelFunc()
+
+	switch params.Rule.SyncType {
+	case types.SyncTypeCopy:
+		_, err = collection.DeleteOne(timeout, mongoFilter(params))
+	case types.SyncTypeJoin:
+		_, err = collection.UpdateOne(timeout, mongoFilter(params),
+			bson.M{"$unset": bson.M{params.Rule.JoinFieldName: ""}})
+	case types.SyncTypeInner:
+		_, err = collection.UpdateOne(timeout, mongoFilter(params),
+			bson.M{"$pull": bson.M{params.Rule.JoinFieldName: mongoValue(params.GetJoinValue())}})
+	default:
+		return errors.Errorf("mongodb writer unsupported sync type: %s", params.Rule.SyncType)
+	}
+
+	return errors.WithStack(err)
+}
+
+// getCollection 获取目标集合，配置了 TargetDatabase 时使用该库，否则使用数据源配置的库
+func (w *MongoWriter) getCollection(params *types.SyncParams) (*mongo.Collection, error) {
+	cliInter, err := w.dataSources.GetDataSource(params.Rule.Target)
+	if err != nil {
+		return nil, err
+	}
+	db := cliInter.(*mongo.Database)
+	if params.Rule.TargetDatabase != "" {
+		db = db.Client().Database(params.Rule.TargetDatabase)
+	}
+
+	return db.Collection(params.Rule.TargetTable), nil
+}
+
+// mongoFilter 通过映射后的主键字段定位文档，主键值的类型和写入的字段一致
+func mongoFilter(params *types.SyncParams) bson.M {
+	return bson.M{params.Rule.Columns[params.Rule.PrimaryKey]: mongoValue(params.GetValue(params.Rule.PrimaryKey))}
+}
+
+// mongoValue 转换为 bson 支持的值，decimal 使用 Decimal128 保留精度，json 字段写入为子文档或数组
+func mongoValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if decimal, err := primitive.ParseDecimal128(v.String()); err == nil {
+			return decimal
+		}
+		return v.String()
+	case json.RawMessage:
+		var parsed interface{}
+		if err := json.Unmarshal(v, &parsed); err == nil {
+			return parsed
+		}
+		return string(v)
+	}
+
+	return value
+}
+
+// buildMongoUpsert 构建 upsert 的更新文档
+// join 的每个字段单独 $set 到子文档，更新事件只包含被修改的字段，不能覆盖整个子文档
+func buildMongoUpsert(params *types.SyncParams, values interface{}) (bson.M, error) {
+	switch params.Rule.SyncType {
+	case types.SyncTypeCopy:
+		mapValues, ok := toMapValues(values)
+		if !ok {
+			return nil, errors.New("mongodb copy values type must be map[string]interface{}")
+		}
+		set := make(bson.M, len(mapValues))
+		for column, value := range mapValues {
+			set[column] = mongoValue(value)
+		}
+		return bson.M{"$set": set}, nil
+	case types.SyncTypeJoin:
+		mapValues, ok := values.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("mongodb join values type must be map[string]interface{}")
+		}
+		record, ok := toMapValues(mapValues[params.Rule.JoinFieldName])
+		if !ok {
+			return nil, errors.New("mongodb join record type must be map[string]interface{}")
+		}
+		set := make(bson.M, len(record))
+		for column, value := range record {
+			set[params.Rule.JoinFieldName+"."+column] = mongoValue(value)
+		}
+		return bson.M{"$set": set}, nil
+	case types.SyncTypeInner:
+		return bson.M{"$addToSet": bson.M{params.Rule.JoinFieldName: mongoValue(params.GetJoinValue())}}, nil
+	}
+
+	return nil, errors.Errorf("mongodb writer unsupported sync type: %s", params.Rule.SyncType)
+}
@@ -0,0 +1,71 @@
+package writers
+
+import (
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"testing"
+)
+
+func TestBuildMongoUpsert(t *testing.T) {
+	params := &types.SyncParams{
+		Rule: types.SyncRule{
+			PrimaryKey: "user_id", SyncType: types.SyncTypeJoin, JoinFieldName: "last_order",
+			Columns: map[string]string{"user_id": "_id", "price": "price", "id": "order_ids"},
+		},
+		Data: map[string]string{"user_id": "1", "price": "4500", "id": "10"},
+	}
+
+	if filter := mongoFilter(params); !reflect.DeepEqual(filter, bson.M{"_id": "1"}) {
+		t.Fatalf("filter error: %v", filter)
+	}
+
+	// join 只更新子文档中被修改的字段
+	update, err := buildMongoUpsert(params, params.GetUpdateValues([]string{"price"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(update, bson.M{"$set": bson.M{"last_order.price": "4500"}}) {
+		t.Fatalf("join update error: %v", update)
+	}
+
+	params.Rule.SyncType, params.Rule.JoinFieldName = types.SyncTypeInner, "order_ids"
+	if update, _ = buildMongoUpsert(params, params.GetUpdateValues(nil)); !reflect.DeepEqual(update,
+		bson.M{"$addToSet": bson.M{"order_ids": "10"}}) {
+		t.Fatalf("inner update error: %v", update)
+	}
+
+	params.Rule.SyncType = types.SyncTypeCopy
+	if update, _ = buildMongoUpsert(params, map[string]string{"price": "4500"}); !reflect.DeepEqual(update,
+		bson.M{"$set": bson.M{"price": "4500"}}) {
+		t.Fatalf("copy update error: %v", update)
+	}
+
+	// decimal 写入为 Decimal128，json 写入为子文档
+	price, _ := primitive.ParseDecimal128("45.00")
+	update, _ = buildMongoUpsert(params, map[string]interface{}{
+		"price": json.Number("45.00"), "attrs": json.RawMessage(`{"a":"b"}`), "remark": nil,
+	})
+	if !reflect.DeepEqual(update, bson.M{"$set": bson.M{"price": price, "attrs": map[string]interface{}{"a": "b"},
+		"remark": nil}}) {
+		t.Fatalf("typed copy update error: %v", update)
+	}
+}
+
+func TestIsMongoRetryableErr(t *testing.T) {
+	duplicateErr := mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}
+	if !IsRetryableErr(types.DataSourceMongo, errors.WithStack(duplicateErr)) {
+		t.Fatal("duplicate key of concurrent upsert should be retryable")
+	}
+	labeledErr := mongo.CommandError{Code: 91, Labels: []string{"RetryableWriteError"}}
+	if !IsRetryableErr(types.DataSourceMongo, labeledErr) {
+		t.Fatal("retryable write error should be retryable")
+	}
+	if IsRetryableErr(types.DataSourceMongo, mongo.CommandError{Code: 2}) {
+		t.Fatal("bad value should not be retryable")
+	}
+}
@@ -5,20 +5,55 @@ import (
 	"github.com/Junjiayy/hamal/pkg/types"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// MysqlWriter 写入 mysql，只支持 copy
+// 数据源配置的 batch_size 大于 1 时开启批量模式，同一个连接的写入合并到一个事务中执行，插入和更新都是 upsert
+// 非批量模式下规则的 upsert_on_insert 和 no_rows_affected 控制主键冲突和记录不存在时的行为
+// 规则配置了 version_column 时，只有事件时间不早于目标记录版本的写入才会生效
 type MysqlWriter struct {
 	*writer
+	batchers map[string]*mysqlBatcher // key 为连接名称
+	mux      sync.Mutex
 }
 
-var syncTypeErr = errors.New("mysql writer only support copy")
+type (
+	// mysqlBatcher 同一个连接的批量写入器
+	mysqlBatcher struct {
+		*batcher
+		cli *gorm.DB
+	}
+
+	// mysqlBatchItem 批量模式下的一条写入记录
+	mysqlBatchItem struct {
+		table           string
+		primaryColumn   string
+		primaryKeyValue string
+		deleted         bool                   // true 为删除，否则为 upsert
+		values          map[string]interface{} // upsert 写入的字段，包含主键字段和版本字段
+		versionColumn   string                 // 版本字段，为空代表不校验版本
+		version         int64                  // 删除时校验的版本号
+	}
+)
+
+const mysqlDefaultFlushInterval = 50 * time.Millisecond // 批量模式默认每批最长等待时间
+
+var (
+	syncTypeErr = errors.New("mysql writer only support copy")
+	// ErrNoRowsAffected 更新或删除没有影响任何记录，规则的 no_rows_affected 为 error 时返回
+	ErrNoRowsAffected = errors.New("mysql no rows affected")
+)
 
 func NewMysqlWriter(source datasources.DataSource) Writer {
-	return &MysqlWriter{&writer{dataSources: source}}
+	return &MysqlWriter{writer: &writer{dataSources: source}}
 }
 
 func (w *MysqlWriter) Insert(params *types.SyncParams, values interface{}) error {
-	strMapValues, ok := values.(map[string]string)
+	mapValues, ok := toMapValues(values)
 	if !ok {
 		return errors.New("mysql writer only support copy, so values type must be map[string]interface{}")
 	}
@@ -33,13 +68,22 @@ func (w *MysqlWriter) Insert(params *types.SyncParams, values interface{}) error
 	}
 
 	cli := cliInter.(*gorm.DB)
-	tx := cli.Table(params.Rule.TargetTable).Create(strMpaToInterMap(strMapValues))
+	if b, err := w.getBatcher(params.Rule.Target, cli); err != nil {
+		return err
+	} else if b != nil {
+		return b.add(newMysqlUpsertItem(params, mapValues))
+	}
+	if params.Rule.UpsertOnInsert {
+		// 主键已存在时更新，重放的事件不会因为主键冲突失败
+		return w.upsert(cli, params, mapValues)
+	}
+	tx := cli.Table(params.Rule.TargetTable).Create(sqlValues(mapValues))
 
 	return tx.Error
 }
 
 func (w *MysqlWriter) Update(params *types.SyncParams, values interface{}) error {
-	strMapValues, ok := values.(map[string]string)
+	mapValues, ok := toMapValues(values)
 	if !ok {
 		return errors.New("mysql writer only support copy, so values type must be map[string]interface{}")
 	}
@@ -56,10 +100,26 @@ func (w *MysqlWriter) Update(params *types.SyncParams, values interface{}) error
 	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
 	primaryColumn := params.Rule.Columns[params.Rule.PrimaryKey]
 	cli := cliInter.(*gorm.DB)
-	tx := cli.Table(params.Rule.TargetTable).Where(primaryColumn, primaryKeyValue).
-		Updates(strMpaToInterMap(strMapValues))
+	if b, err := w.getBatcher(params.Rule.Target, cli); err != nil {
+		return err
+	} else if b != nil {
+		return b.add(newMysqlUpsertItem(params, mapValues))
+	}
+	tx := mysqlVersionScope(cli.Table(params.Rule.TargetTable).Where(primaryColumn, primaryKeyValue), params).
+		Updates(sqlValues(mapValues))
+	if tx.Error != nil || tx.RowsAffected > 0 {
+		return tx.Error
+	}
 
-	return tx.Error
+	switch params.Rule.NoRowsAffected {
+	case types.NoRowsAffectedError:
+		return w.noRowsAffected(cli, params, types.EventTypeUpdate)
+	case types.NoRowsAffectedInsert:
+		// 更新事件只包含被修改的字段，补写时使用全部映射字段
+		return w.upsert(cli, params, getAllUpdateValues(params).(map[string]interface{}))
+	}
+
+	return nil
 }
 
 func (w *MysqlWriter) Delete(params *types.SyncParams) error {
@@ -75,12 +135,248 @@ func (w *MysqlWriter) Delete(params *types.SyncParams) error {
 	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
 	primaryColumn := params.Rule.Columns[params.Rule.PrimaryKey]
 	cli := cliInter.(*gorm.DB)
-	tx := cli.Table(params.Rule.TargetTable).Where(primaryColumn, primaryKeyValue).
+	if b, err := w.getBatcher(params.Rule.Target, cli); err != nil {
+		return err
+	} else if b != nil {
+		return b.add(&mysqlBatchItem{
+			table: params.Rule.TargetTable, primaryColumn: primaryColumn, primaryKeyValue: primaryKeyValue,
+			deleted: true, versionColumn: params.Rule.VersionColumn, version: params.GetVersion(),
+		})
+	}
+	tx := mysqlVersionScope(cli.Table(params.Rule.TargetTable).Where(primaryColumn, primaryKeyValue), params).
 		Delete(nil)
+	if tx.Error == nil && tx.RowsAffected == 0 && params.Rule.NoRowsAffected == types.NoRowsAffectedError {
+		return w.noRowsAffected(cli, params, types.EventTypeDelete)
+	}
 
 	return tx.Error
 }
 
+// noRowsAffected 影响行数为 0 时返回 ErrNoRowsAffected
+// 配置了版本字段时，记录存在说明是过期的事件，不作为错误
+func (w *MysqlWriter) noRowsAffected(cli *gorm.DB, params *types.SyncParams, eventType string) error {
+	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
+	primaryColumn := params.Rule.Columns[params.Rule.PrimaryKey]
+	if params.Rule.VersionColumn != "" {
+		var count int64
+		if err := cli.Table(params.Rule.TargetTable).Where(primaryColumn, primaryKeyValue).
+			Count(&count).Error; err != nil {
+			return err
+		} else if count > 0 {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrNoRowsAffected, "%s %s %s=%s", eventType, params.Rule.TargetTable,
+		primaryColumn, primaryKeyValue)
+}
+
+// mysqlVersionScope 配置了版本字段时，只更新版本不大于事件时间的记录
+// 事件时间单位为毫秒，但 binlog 事件头的时间只精确到秒，同一秒内的多次修改版本相同
+// 使用 < 比较会丢弃同一秒内的后续修改，所以使用 <= 比较，重复的事件会再次写入相同的数据
+func mysqlVersionScope(tx *gorm.DB, params *types.SyncParams) *gorm.DB {
+	if params.Rule.VersionColumn == "" {
+		return tx
+	}
+
+	return tx.Where(mysqlVersionCondition(params.Rule.VersionColumn), params.GetVersion())
+}
+
+// mysqlVersionCondition 版本校验条件，版本字段为空的历史记录可以直接覆盖
+func mysqlVersionCondition(versionColumn string) string {
+	column := quoteMysqlIdentifier(versionColumn)
+
+	return "(" + column + " IS NULL OR " + column + " <= ?)"
+}
+
+// upsert 写入一条记录，主键已存在时更新
+func (w *MysqlWriter) upsert(cli *gorm.DB, params *types.SyncParams, values map[string]interface{}) error {
+	sql, args := buildMysqlUpsertSql([]*mysqlBatchItem{newMysqlUpsertItem(params, values)})
+
+	return cli.Exec(sql, args...).Error
+}
+
+// getBatcher 获取连接的批量写入器，未开启批量模式时返回 nil，连接变更后重新创建
+func (w *MysqlWriter) getBatcher(name string, cli *gorm.DB) (*mysqlBatcher, error) {
+	conf, err := w.dataSources.GetConfig(name)
+	if err != nil {
+		return nil, err
+	} else if conf.BatchSize <= 1 {
+		return nil, nil
+	}
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if b, ok := w.batchers[name]; ok && b.cli == cli {
+		return b, nil
+	}
+	if w.batchers == nil {
+		w.batchers = make(map[string]*mysqlBatcher)
+	}
+
+	flushInterval := conf.FlushInterval
+	if flushInterval <= 0 {
+		flushInterval = mysqlDefaultFlushInterval
+	}
+	b := &mysqlBatcher{cli: cli}
+	b.batcher = newBatcher(conf.BatchSize, flushInterval, func(items []interface{}) []error {
+		return flushMysqlBatch(cli, items)
+	})
+	w.batchers[name] = b
+
+	return b, nil
+}
+
+// newMysqlUpsertItem 创建 upsert 记录，更新事件的字段只包含被修改的字段，需要补充主键字段
+func newMysqlUpsertItem(params *types.SyncParams, values map[string]interface{}) *mysqlBatchItem {
+	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
+	primaryColumn := params.Rule.Columns[params.Rule.PrimaryKey]
+	if primaryColumn == "" {
+		primaryColumn = params.Rule.PrimaryKey
+	}
+	itemValues := make(map[string]interface{}, len(values)+1)
+	for column, value := range values {
+		itemValues[column] = sqlValue(value)
+	}
+	itemValues[primaryColumn] = params.GetValue(params.Rule.PrimaryKey)
+
+	return &mysqlBatchItem{
+		table: params.Rule.TargetTable, primaryColumn: primaryColumn,
+		primaryKeyValue: primaryKeyValue, values: itemValues, versionColumn: params.Rule.VersionColumn,
+	}
+}
+
+// flushMysqlBatch 在一个事务中执行一批写入
+// 同一张表字段相同的 upsert 合并为一条 INSERT ... ON DUPLICATE KEY UPDATE，同一张表的删除合并为一条 DELETE ... IN
+// 同一条记录的写入持有记录锁，一批中不会出现同一条记录的多次写入，合并后的执行顺序不影响结果
+func flushMysqlBatch(cli *gorm.DB, items []interface{}) []error {
+	var keys []string
+	groups := make(map[string][]*mysqlBatchItem)
+	for _, item := range items {
+		batchItem := item.(*mysqlBatchItem)
+		key := "delete\x00" + batchItem.table + "\x00" + batchItem.primaryColumn + "\x00" + batchItem.versionColumn
+		if !batchItem.deleted {
+			key = "upsert\x00" + batchItem.table + "\x00" + strings.Join(sortedColumns(batchItem.values), ",") +
+				"\x00" + batchItem.versionColumn
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], batchItem)
+	}
+
+	err := cli.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			var sql string
+			var args []interface{}
+			if group := groups[key]; group[0].deleted {
+				sql, args = buildMysqlDeleteSql(group)
+			} else {
+				sql, args = buildMysqlUpsertSql(group)
+			}
+			if err := tx.Exec(sql, args...).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return batchErrors(len(items), errors.WithStack(err))
+	}
+
+	return nil
+}
+
+// buildMysqlUpsertSql 构建多行 INSERT ... ON DUPLICATE KEY UPDATE，同一组记录的字段相同
+// 配置了版本字段时，每个字段只在新版本不小于记录版本时更新，版本字段最后赋值，保证前面的字段比较的是旧版本
+func buildMysqlUpsertSql(items []*mysqlBatchItem) (string, []interface{}) {
+	columns := sortedColumns(items[0].values)
+	versionColumn := items[0].versionColumn
+	if _, ok := items[0].values[versionColumn]; !ok {
+		versionColumn = ""
+	}
+	quotedColumns, updates := make([]string, len(columns)), make([]string, 0, len(columns))
+	for i, column := range columns {
+		quotedColumns[i] = quoteMysqlIdentifier(column)
+		if column != items[0].primaryColumn && column != versionColumn {
+			updates = append(updates, mysqlUpsertAssignment(quotedColumns[i], versionColumn))
+		}
+	}
+	if versionColumn != "" {
+		updates = append(updates, mysqlUpsertAssignment(quoteMysqlIdentifier(versionColumn), versionColumn))
+	}
+	if len(updates) == 0 {
+		// 只有主键字段时，重复的记录保持不变
+		primaryColumn := quoteMysqlIdentifier(items[0].primaryColumn)
+		updates = append(updates, primaryColumn+"="+primaryColumn)
+	}
+
+	placeholder := "(" + strings.TrimRight(strings.Repeat("?,", len(columns)), ",") + ")"
+	rows, args := make([]string, len(items)), make([]interface{}, 0, len(items)*len(columns))
+	for i, item := range items {
+		rows[i] = placeholder
+		for _, column := range columns {
+			args = append(args, item.values[column])
+		}
+	}
+
+	return "INSERT INTO " + quoteMysqlIdentifier(items[0].table) + " (" + strings.Join(quotedColumns, ",") +
+		") VALUES " + strings.Join(rows, ",") + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ","), args
+}
+
+// mysqlUpsertAssignment 构建 ON DUPLICATE KEY UPDATE 的单个字段赋值
+func mysqlUpsertAssignment(quotedColumn, versionColumn string) string {
+	if versionColumn == "" {
+		return quotedColumn + "=VALUES(" + quotedColumn + ")"
+	}
+	version := quoteMysqlIdentifier(versionColumn)
+
+	return quotedColumn + "=IF(" + version + " IS NULL OR " + version + " <= VALUES(" + version + "),VALUES(" +
+		quotedColumn + ")," + quotedColumn + ")"
+}
+
+// buildMysqlDeleteSql 构建 DELETE ... WHERE pk IN (...)
+// 配置了版本字段时，每条记录单独校验版本
+func buildMysqlDeleteSql(items []*mysqlBatchItem) (string, []interface{}) {
+	if items[0].versionColumn != "" {
+		primaryColumn := quoteMysqlIdentifier(items[0].primaryColumn)
+		condition := "(" + primaryColumn + " = ? AND " + mysqlVersionCondition(items[0].versionColumn) + ")"
+		conditions, args := make([]string, len(items)), make([]interface{}, 0, len(items)*2)
+		for i, item := range items {
+			conditions[i] = condition
+			args = append(args, item.primaryKeyValue, item.version)
+		}
+
+		return "DELETE FROM " + quoteMysqlIdentifier(items[0].table) + " WHERE " +
+			strings.Join(conditions, " OR "), args
+	}
+
+	args := make([]interface{}, len(items))
+	for i, item := range items {
+		args[i] = item.primaryKeyValue
+	}
+
+	return "DELETE FROM " + quoteMysqlIdentifier(items[0].table) + " WHERE " +
+		quoteMysqlIdentifier(items[0].primaryColumn) + " IN (" +
+		strings.TrimRight(strings.Repeat("?,", len(items)), ",") + ")", args
+}
+
+// sortedColumns 获取排序后的字段名，保证生成的 sql 稳定
+func sortedColumns(values map[string]interface{}) []string {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	return columns
+}
+
+// quoteMysqlIdentifier 使用反引号转义表名和字段名
+func quoteMysqlIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
 func strMpaToInterMap(sources map[string]string) map[string]interface{} {
 	res := make(map[string]interface{}, len(sources))
 	for key, value := range sources {
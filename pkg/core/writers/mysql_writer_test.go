@@ -27,7 +27,7 @@ func initMysqlWriter(t *testing.T) {
 		dataSource.(*datasources.MysqlDataSource).SetConfigs(configs)
 		source, _ := dataSource.GetDataSource("test")
 		source.(*gorm.DB).Exec("TRUNCATE test_user_trans_records")
-		mw = MysqlWriter{&writer{dataSources: dataSource}}
+		mw = MysqlWriter{writer: &writer{dataSources: dataSource}}
 	})
 }
 
@@ -163,3 +163,64 @@ func TestMysqlWriter_Delete(t *testing.T) {
 			recordCount)
 	}
 }
+
+func TestBuildMysqlBatchSql(t *testing.T) {
+	rule := types.SyncRule{
+		PrimaryKey: "order_sn", TargetTable: "test_user_trans_records", SyncType: types.SyncTypeCopy,
+		Columns: map[string]string{"order_sn": "original_order_sn"},
+	}
+	items := []*mysqlBatchItem{
+		newMysqlUpsertItem(&types.SyncParams{Rule: rule, Data: map[string]string{"order_sn": "1"}},
+			map[string]interface{}{"trans_price": "5000"}),
+		newMysqlUpsertItem(&types.SyncParams{Rule: rule, Data: map[string]string{"order_sn": "2"}},
+			map[string]interface{}{"trans_price": "4500"}),
+	}
+
+	sql, args := buildMysqlUpsertSql(items)
+	if sql != "INSERT INTO `test_user_trans_records` (`original_order_sn`,`trans_price`) VALUES (?,?),(?,?) "+
+		"ON DUPLICATE KEY UPDATE `trans_price`=VALUES(`trans_price`)" {
+		t.Fatalf("upsert sql error: %s", sql)
+	}
+	if len(args) != 4 || args[0] != "1" || args[1] != "5000" || args[2] != "2" || args[3] != "4500" {
+		t.Fatalf("upsert args error: %v", args)
+	}
+
+	sql, args = buildMysqlDeleteSql(items)
+	if sql != "DELETE FROM `test_user_trans_records` WHERE `original_order_sn` IN (?,?)" {
+		t.Fatalf("delete sql error: %s", sql)
+	}
+	if len(args) != 2 || args[0] != "1" || args[1] != "2" {
+		t.Fatalf("delete args error: %v", args)
+	}
+}
+
+func TestBuildMysqlVersionedSql(t *testing.T) {
+	rule := types.SyncRule{
+		PrimaryKey: "id", TargetTable: "orders", SyncType: types.SyncTypeCopy,
+		Columns: map[string]string{"id": "id", "price": "price"}, VersionColumn: "version",
+	}
+	binLog := &types.BinlogParams{EventType: types.EventTypeUpdate, EventAt: 1709885119000}
+	params := types.NewSyncParams(types.NewSyncWaitGroup(), &rule, map[string]string{"id": "1", "price": "4500"},
+		map[string]string{"price": "5000"}, binLog)
+
+	item := newMysqlUpsertItem(params, params.GetUpdateValues([]string{"price"}).(map[string]interface{}))
+	sql, args := buildMysqlUpsertSql([]*mysqlBatchItem{item})
+	// 版本字段最后赋值
+	if sql != "INSERT INTO `orders` (`id`,`price`,`version`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE "+
+		"`price`=IF(`version` IS NULL OR `version` <= VALUES(`version`),VALUES(`price`),`price`),"+
+		"`version`=IF(`version` IS NULL OR `version` <= VALUES(`version`),VALUES(`version`),`version`)" {
+		t.Fatalf("versioned upsert sql error: %s", sql)
+	}
+	if len(args) != 3 || args[2] != int64(1709885119000) {
+		t.Fatalf("versioned upsert args error: %v", args)
+	}
+
+	sql, args = buildMysqlDeleteSql([]*mysqlBatchItem{
+		{table: "orders", primaryColumn: "id", primaryKeyValue: "1", deleted: true, versionColumn: "version", version: 1},
+		{table: "orders", primaryColumn: "id", primaryKeyValue: "2", deleted: true, versionColumn: "version", version: 2},
+	})
+	if sql != "DELETE FROM `orders` WHERE (`id` = ? AND (`version` IS NULL OR `version` <= ?)) OR "+
+		"(`id` = ? AND (`version` IS NULL OR `version` <= ?))" || len(args) != 4 {
+		t.Fatalf("versioned delete sql error: %s %v", sql, args)
+	}
+}
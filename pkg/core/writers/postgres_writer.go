@@ -0,0 +1,202 @@
+package writers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+type PostgresWriter struct {
+	*writer
+}
+
+const (
+	// join 字段作为 jsonb 对象，新值合并到已有对象中
+	upsertJoinSqlTpl = "INSERT INTO :table (:pk, :field) VALUES (?, ?::jsonb) ON CONFLICT (:pk) DO UPDATE SET " +
+		":field = COALESCE(:table.:field, '{}'::jsonb) || EXCLUDED.:field"
+	// inner 字段作为 jsonb 数组，值不存在时追加
+	upsertInnerSqlTpl = "INSERT INTO :table (:pk, :field) VALUES (?, jsonb_build_array(?::jsonb)) ON CONFLICT (:pk) DO UPDATE SET " +
+		":field = CASE WHEN :table.:field @> EXCLUDED.:field THEN :table.:field ELSE COALESCE(:table.:field, '[]'::jsonb) || EXCLUDED.:field END"
+	deleteInnerSqlTpl = "UPDATE :table SET :field = (SELECT COALESCE(jsonb_agg(e), '[]'::jsonb) FROM jsonb_array_elements(:field) e " +
+		"WHERE e <> ?::jsonb) WHERE :pk = ? AND :field IS NOT NULL"
+	deleteJoinSqlTpl = "UPDATE :table SET :field = NULL WHERE :pk = ?"
+)
+
+func NewPostgresWriter(source datasources.DataSource) Writer {
+	return &PostgresWriter{&writer{dataSources: source}}
+}
+
+// Insert copy 通过 ON CONFLICT 按主键 upsert
+// join 合并到目标记录的 jsonb 对象字段，inner 追加到目标记录的 jsonb 数组字段
+func (w *PostgresWriter) Insert(params *types.SyncParams, values interface{}) error {
+	cli, err := w.getCli(params)
+	if err != nil {
+		return err
+	}
+	table, primaryColumn := postgresTable(&params.Rule), params.Rule.Columns[params.Rule.PrimaryKey]
+	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
+
+	var tx *gorm.DB
+	switch params.Rule.SyncType {
+	case types.SyncTypeCopy:
+		mapValues, ok := toMapValues(values)
+		if !ok {
+			return errors.New("postgres copy values type must be map[string]interface{}")
+		}
+		sql, args := buildUpsertSql(table, primaryColumn, mapValues)
+		tx = cli.Exec(sql, args...)
+	case types.SyncTypeJoin:
+		mapValues, ok := values.(map[string]interface{})
+		if !ok {
+			return errors.New("postgres join values type must be map[string]interface{}")
+		}
+		record, err := json.Marshal(mapValues[params.Rule.JoinFieldName])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		tx = cli.Exec(buildJsonbSql(upsertJoinSqlTpl, table, primaryColumn, params.Rule.JoinFieldName),
+			primaryKeyValue, string(record))
+	case types.SyncTypeInner:
+		value, err := json.Marshal(params.GetJoinValue())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		tx = cli.Exec(buildJsonbSql(upsertInnerSqlTpl, table, primaryColumn, params.Rule.JoinFieldName),
+			primaryKeyValue, string(value))
+	default:
+		return errors.Errorf("postgres writer unsupported sync type: %s", params.Rule.SyncType)
+	}
+
+	return errors.WithStack(tx.Error)
+}
+
+// Update copy 按主键更新被修改的字段，join 和 inner 与 Insert 一致
+func (w *PostgresWriter) Update(params *types.SyncParams, values interface{}) error {
+	if params.Rule.SyncType != types.SyncTypeCopy {
+		return w.Insert(params, values)
+	}
+	mapValues, ok := toMapValues(values)
+	if !ok {
+		return errors.New("postgres copy values type must be map[string]interface{}")
+	}
+	cli, err := w.getCli(params)
+	if err != nil {
+		return err
+	}
+
+	primaryColumn := params.Rule.Columns[params.Rule.PrimaryKey]
+	sql, args := buildUpdateSql(postgresTable(&params.Rule), primaryColumn, mapValues)
+	tx := cli.Exec(sql, append(args, params.Data[params.Rule.PrimaryKey])...)
+
+	return errors.WithStack(tx.Error)
+}
+
+// Delete copy 删除目标记录，join 清空 jsonb 对象字段，inner 从 jsonb 数组中移除
+func (w *PostgresWriter) Delete(params *types.SyncParams) error {
+	cli, err := w.getCli(params)
+	if err != nil {
+		return err
+	}
+	table, primaryColumn := postgresTable(&params.Rule), params.Rule.Columns[params.Rule.PrimaryKey]
+	primaryKeyValue := params.Data[params.Rule.PrimaryKey]
+
+	var tx *gorm.DB
+	switch params.Rule.SyncType {
+	case types.SyncTypeCopy:
+		tx = cli.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table, quoteIdentifier(primaryColumn)),
+			primaryKeyValue)
+	case types.SyncTypeJoin:
+		tx = cli.Exec(buildJsonbSql(deleteJoinSqlTpl, table, primaryColumn, params.Rule.JoinFieldName),
+			primaryKeyValue)
+	case types.SyncTypeInner:
+		value, err := json.Marshal(params.GetJoinValue())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		tx = cli.Exec(buildJsonbSql(deleteInnerSqlTpl, table, primaryColumn, params.Rule.JoinFieldName),
+			string(value), primaryKeyValue)
+	default:
+		return errors.Errorf("postgres writer unsupported sync type: %s", params.Rule.SyncType)
+	}
+
+	return errors.WithStack(tx.Error)
+}
+
+func (w *PostgresWriter) getCli(params *types.SyncParams) (*gorm.DB, error) {
+	cliInter, err := w.dataSources.GetDataSource(params.Rule.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	return cliInter.(*gorm.DB), nil
+}
+
+// buildUpsertSql 构建 INSERT ... ON CONFLICT (主键) DO UPDATE 语句，字段按名称排序保证语句稳定
+func buildUpsertSql(table, primaryColumn string, values map[string]interface{}) (string, []interface{}) {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	quotedColumns, placeholders := make([]string, 0, len(columns)), make([]string, 0, len(columns))
+	updates, args := make([]string, 0, len(columns)), make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		quoted := quoteIdentifier(column)
+		quotedColumns, placeholders = append(quotedColumns, quoted), append(placeholders, "?")
+		args = append(args, sqlValue(values[column]))
+		if column != primaryColumn {
+			updates = append(updates, quoted+" = EXCLUDED."+quoted)
+		}
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) ", table,
+		strings.Join(quotedColumns, ", "), strings.Join(placeholders, ", "), quoteIdentifier(primaryColumn))
+	if len(updates) == 0 {
+		return sql + "DO NOTHING", args
+	}
+
+	return sql + "DO UPDATE SET " + strings.Join(updates, ", "), args
+}
+
+// buildUpdateSql 构建按主键更新的 UPDATE 语句，主键值作为最后一个参数由调用方追加
+func buildUpdateSql(table, primaryColumn string, values map[string]interface{}) (string, []interface{}) {
+	columns := make([]string, 0, len(values))
+	for column := range values {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	sets, args := make([]string, 0, len(columns)), make([]interface{}, 0, len(columns)+1)
+	for _, column := range columns {
+		sets, args = append(sets, quoteIdentifier(column)+" = ?"), append(args, sqlValue(values[column]))
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", table, strings.Join(sets, ", "),
+		quoteIdentifier(primaryColumn)), args
+}
+
+// buildJsonbSql 替换 jsonb 语句模版中的表名、主键 和 字段名
+func buildJsonbSql(tpl, table, primaryColumn, field string) string {
+	return strings.NewReplacer(":table", table, ":pk", quoteIdentifier(primaryColumn),
+		":field", quoteIdentifier(field)).Replace(tpl)
+}
+
+// postgresTable 获取目标表名，配置了 TargetDatabase 时作为 schema
+func postgresTable(rule *types.SyncRule) string {
+	if rule.TargetDatabase != "" {
+		return quoteIdentifier(rule.TargetDatabase) + "." + quoteIdentifier(rule.TargetTable)
+	}
+
+	return quoteIdentifier(rule.TargetTable)
+}
+
+// quoteIdentifier 使用双引号转义标识符
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,47 @@
+package writers
+
+import (
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/jackc/pgconn"
+	"github.com/pkg/errors"
+	"reflect"
+	"testing"
+)
+
+func TestBuildUpsertSql(t *testing.T) {
+	sql, args := buildUpsertSql(`"orders"`, "order_sn", map[string]interface{}{
+		"user_id": "1", "order_sn": "xlz2024030816051904940892", "price": "5000",
+	})
+
+	expectedSql := `INSERT INTO "orders" ("order_sn", "price", "user_id") VALUES (?, ?, ?) ON CONFLICT ("order_sn") ` +
+		`DO UPDATE SET "price" = EXCLUDED."price", "user_id" = EXCLUDED."user_id"`
+	if sql != expectedSql {
+		t.Fatalf("upsert sql error: %s", sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"xlz2024030816051904940892", "5000", "1"}) {
+		t.Fatalf("upsert args error: %v", args)
+	}
+
+	// 只有主键时 不需要更新
+	sql, _ = buildUpsertSql(`"orders"`, "order_sn", map[string]interface{}{"order_sn": "1"})
+	if sql != `INSERT INTO "orders" ("order_sn") VALUES (?) ON CONFLICT ("order_sn") DO NOTHING` {
+		t.Fatalf("upsert sql error: %s", sql)
+	}
+}
+
+func TestBuildJsonbSql(t *testing.T) {
+	rule := &types.SyncRule{TargetDatabase: "public", TargetTable: "users"}
+	sql := buildJsonbSql(deleteJoinSqlTpl, postgresTable(rule), "id", "last_order")
+	if sql != `UPDATE "public"."users" SET "last_order" = NULL WHERE "id" = ?` {
+		t.Fatalf("jsonb sql error: %s", sql)
+	}
+}
+
+func TestIsPostgresRetryableErr(t *testing.T) {
+	if !IsRetryableErr(types.DataSourcePostgres, errors.WithStack(&pgconn.PgError{Code: "40P01"})) {
+		t.Fatal("deadlock should be retryable")
+	}
+	if IsRetryableErr(types.DataSourcePostgres, &pgconn.PgError{Code: "23502"}) {
+		t.Fatal("not null violation should not be retryable")
+	}
+}
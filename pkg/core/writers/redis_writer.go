@@ -0,0 +1,155 @@
+package writers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const redisWriteTimeout = time.Second // 单次写入超时时间
+
+// mergeJoinFieldScript 把 join 记录合并到父级 hash 的字段中，字段值为 json 对象
+// 更新事件只包含被修改的字段，所以需要和已有的对象合并
+var mergeJoinFieldScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], ARGV[1])
+local record = {}
+if current then
+	record = cjson.decode(current)
+end
+for key, value in pairs(cjson.decode(ARGV[2])) do
+	record[key] = value
+end
+redis.call('HSET', KEYS[1], ARGV[1], cjson.encode(record))
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// RedisWriter 把记录物化到 redis 中
+// copy 写入 hash，join 写入父级 hash 的一个字段 (json 对象)，inner 写入 set 的一个成员
+// key 由 TargetTable 和 主键值组成，见 redisKey
+type RedisWriter struct {
+	*writer
+}
+
+func NewRedisWriter(source datasources.DataSource) Writer {
+	return &RedisWriter{&writer{dataSources: source}}
+}
+
+func (w *RedisWriter) Insert(params *types.SyncParams, values interface{}) error {
+	cli, err := w.getCli(params)
+	if err != nil {
+		return err
+	}
+	key := redisKey(params)
+	ctx, cancelFunc := context.WithTimeout(context.Background(), redisWriteTimeout)
+	defer cancelFunc()
+
+	switch params.Rule.SyncType {
+	case types.SyncTypeCopy:
+		mapValues, ok := toMapValues(values)
+		if !ok {
+			return errors.New("redis copy values type must be map[string]interface{}")
+		}
+		_, err = cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, redisHashValues(mapValues))
+			if cli.TTL > 0 {
+				pipe.PExpire(ctx, key, cli.TTL)
+			}
+			return nil
+		})
+	case types.SyncTypeJoin:
+		mapValues, ok := values.(map[string]interface{})
+		if !ok {
+			return errors.New("redis join values type must be map[string]interface{}")
+		}
+		record, err := json.Marshal(mapValues[params.Rule.JoinFieldName])
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = mergeJoinFieldScript.Run(ctx, cli, []string{key}, params.Rule.JoinFieldName,
+			string(record), cli.TTL.Milliseconds()).Err()
+		return errors.WithStack(err)
+	case types.SyncTypeInner:
+		_, err = cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, key, redisSetMember(params))
+			if cli.TTL > 0 {
+				pipe.PExpire(ctx, key, cli.TTL)
+			}
+			return nil
+		})
+	default:
+		return errors.Errorf("redis writer unsupported sync type: %s", params.Rule.SyncType)
+	}
+
+	return errors.WithStack(err)
+}
+
+func (w *RedisWriter) Update(params *types.SyncParams, values interface{}) error {
+	// hash 和 set 的写入都是覆盖或幂等的，更新 和 新增一致
+	return w.Insert(params, values)
+}
+
+// Delete copy 删除 key，join 删除父级 hash 中的字段，inner 删除 set 中的成员
+func (w *RedisWriter) Delete(params *types.SyncParams) error {
+	cli, err := w.getCli(params)
+	if err != nil {
+		return err
+	}
+	key := redisKey(params)
+	ctx, cancelFunc := context.WithTimeout(context.Background(), redisWriteTimeout)
+	defer cancelFunc()
+
+	switch params.Rule.SyncType {
+	case types.SyncTypeCopy:
+		err = cli.Del(ctx, key).Err()
+	case types.SyncTypeJoin:
+		err = cli.HDel(ctx, key, params.Rule.JoinFieldName).Err()
+	case types.SyncTypeInner:
+		err = cli.SRem(ctx, key, redisSetMember(params)).Err()
+	default:
+		return errors.Errorf("redis writer unsupported sync type: %s", params.Rule.SyncType)
+	}
+
+	return errors.WithStack(err)
+}
+
+func (w *RedisWriter) getCli(params *types.SyncParams) (*datasources.RedisClient, error) {
+	cliInter, err := w.dataSources.GetDataSource(params.Rule.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	return cliInter.(*datasources.RedisClient), nil
+}
+
+// redisKey 获取记录的 key 格式 (TargetDatabase:)TargetTable:主键值
+func redisKey(params *types.SyncParams) string {
+	key := params.Rule.TargetTable + ":" + params.Data[params.Rule.PrimaryKey]
+	if params.Rule.TargetDatabase != "" {
+		key = params.Rule.TargetDatabase + ":" + key
+	}
+
+	return key
+}
+
+// redisSetMember 获取 inner 写入 set 的成员，写入和删除使用相同的格式
+// 例如 bool 字段的成员为 true，不能使用来源的原始值 1 删除
+func redisSetMember(params *types.SyncParams) string {
+	return types.FormatValue(params.GetJoinValue())
+}
+
+// redisHashValues hash 的值都是字符串，null 写入为空字符串
+func redisHashValues(values map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(values))
+	for column, value := range values {
+		res[column] = types.FormatValue(value)
+	}
+
+	return res
+}
@@ -0,0 +1,155 @@
+package writers
+
+import (
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/alicebob/miniredis/v2"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestRedisWriter(t *testing.T) (Writer, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+	dataSource := datasources.NewRedisDataSource()
+	if err := dataSource.SetConfigs(map[string]datasources.DataSourceConfig{
+		"test": {Name: "test", Host: mr.Host(), Port: port, Target: "0", TTL: time.Hour},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRedisWriter(dataSource), mr
+}
+
+func TestRedisWriter_Copy(t *testing.T) {
+	rw, mr := newTestRedisWriter(t)
+	params := &types.SyncParams{
+		Rule: types.SyncRule{
+			Target: "test", TargetTable: "orders", PrimaryKey: "order_sn", SyncType: types.SyncTypeCopy,
+			Columns: map[string]string{"order_sn": "order_sn", "price": "trans_price"},
+		},
+		Data: map[string]string{"order_sn": "xlz2024030816051904940892", "price": "5000"},
+	}
+
+	key := "orders:xlz2024030816051904940892"
+	if err := rw.Insert(params, map[string]string{"order_sn": "xlz2024030816051904940892", "trans_price": "5000"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Update(params, map[string]string{"trans_price": "4500"}); err != nil {
+		t.Fatal(err)
+	}
+	if mr.HGet(key, "trans_price") != "4500" || mr.HGet(key, "order_sn") == "" {
+		t.Fatalf("hash should be updated: %v", mr.HGet(key, "trans_price"))
+	}
+	if ttl := mr.TTL(key); ttl != time.Hour {
+		t.Fatalf("key ttl should be 1h, current: %s", ttl)
+	}
+
+	if err := rw.Delete(params); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(key) {
+		t.Fatal("key should be deleted")
+	}
+}
+
+func TestRedisWriter_Join(t *testing.T) {
+	rw, mr := newTestRedisWriter(t)
+	params := &types.SyncParams{
+		Rule: types.SyncRule{
+			Target: "test", TargetTable: "users", PrimaryKey: "user_id", SyncType: types.SyncTypeJoin,
+			JoinFieldName: "last_order", Columns: map[string]string{"user_id": "id", "price": "price"},
+		},
+		Data: map[string]string{"user_id": "1", "price": "5000"},
+	}
+
+	if err := rw.Insert(params, params.GetUpdateValues([]string{"user_id", "price"})); err != nil {
+		t.Fatal(err)
+	}
+	params.Data["price"] = "4500"
+	if err := rw.Update(params, params.GetUpdateValues([]string{"price"})); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]string
+	if err := json.Unmarshal([]byte(mr.HGet("users:1", "last_order")), &record); err != nil {
+		t.Fatal(err)
+	}
+	// 更新只包含修改的字段，需要和已有的字段合并
+	if record["id"] != "1" || record["price"] != "4500" {
+		t.Fatalf("join field should be merged: %v", record)
+	}
+
+	if err := rw.Delete(params); err != nil {
+		t.Fatal(err)
+	}
+	if mr.HGet("users:1", "last_order") != "" {
+		t.Fatal("join field should be deleted")
+	}
+}
+
+func TestRedisWriter_Inner(t *testing.T) {
+	rw, mr := newTestRedisWriter(t)
+	params := &types.SyncParams{
+		Rule: types.SyncRule{
+			Target: "test", TargetDatabase: "cache", TargetTable: "user_orders", PrimaryKey: "user_id",
+			SyncType: types.SyncTypeInner, JoinFieldName: "order_ids",
+			Columns: map[string]string{"user_id": "id", "id": "order_ids"},
+			// 删除的成员需要和写入的成员一样经过字段转换
+			Transforms: map[string][]types.ColumnTransform{
+				"order_ids": {{Type: types.TransformMap, Mapping: map[string]string{"10": "order-10"}}},
+			},
+		},
+		Data: map[string]string{"user_id": "1", "id": "10"},
+	}
+
+	if err := rw.Insert(params, params.GetUpdateValues(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mr.SIsMember("cache:user_orders:1", "order-10"); !ok {
+		t.Fatal("member should be added")
+	}
+	if err := rw.Delete(params); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("cache:user_orders:1") {
+		t.Fatal("member should be removed")
+	}
+
+	// bool 字段的成员写入为 true，删除时使用相同的格式
+	params.Rule.Transforms, params.Data["id"] = nil, "1"
+	params.SetBinLogParams(&types.BinlogParams{ColumnTypes: map[string]string{"id": types.ColumnTypeBool}})
+	if err := rw.Insert(params, params.GetUpdateValues(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mr.SIsMember("cache:user_orders:1", "true"); !ok {
+		t.Fatal("bool member should be added as true")
+	}
+	if err := rw.Delete(params); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("cache:user_orders:1") {
+		t.Fatal("bool member should be removed")
+	}
+}
+
+func TestIsRedisRetryableErr(t *testing.T) {
+	if !isRedisRetryableErr(redisErr("LOADING Redis is loading the dataset in memory")) {
+		t.Fatal("loading should be retryable")
+	}
+	if isRedisRetryableErr(redisErr("WRONGTYPE Operation against a key holding the wrong kind of value")) {
+		t.Fatal("wrong type should not be retryable")
+	}
+	if !IsRetryableErr(types.DataSourceRedis, &net.OpError{Op: "dial"}) {
+		t.Fatal("network error should be retryable")
+	}
+}
+
+type redisErr string
+
+func (e redisErr) Error() string { return string(e) }
+
+func (e redisErr) RedisError() {}
@@ -0,0 +1,184 @@
+package writers
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/olivere/elastic/v7"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrClassifier 写入错误分类器，返回 true 代表错误是暂时的，可以重试
+type ErrClassifier func(err error) bool
+
+var _errClassifiers = make(map[string]ErrClassifier) // 写入错误分类器 映射表
+
+// postgres 可以重试的错误码
+var postgresRetryableCodes = map[string]struct{}{
+	"40001": {}, // serialization_failure
+	"40P01": {}, // deadlock_detected
+	"55P03": {}, // lock_not_available
+	"57P01": {}, // admin_shutdown
+}
+
+// redis 可以重试的错误前缀
+var redisRetryablePrefixes = []string{"LOADING", "READONLY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN"}
+
+const (
+	mysqlErrLockWaitTimeout = 1205 // 锁等待超时
+	mysqlErrDeadlock        = 1213 // 死锁
+)
+
+// SetErrClassifier 注册写入错误分类器
+func SetErrClassifier(name string, fn ErrClassifier) {
+	_errClassifiers[name] = fn
+}
+
+// GetErrClassifier 获取写入错误分类器
+func GetErrClassifier(name string) ErrClassifier {
+	return _errClassifiers[name]
+}
+
+// IsRetryableErr 判断写入错误是否可以重试
+// 超时、连接中断等通用错误都可以重试，其他错误交给写入器类型注册的分类器判断
+// 字段映射、参数校验等错误不可重试
+func IsRetryableErr(wType string, err error) bool {
+	if err == nil {
+		return false
+	}
+	if isTransientErr(err) {
+		return true
+	}
+	if classifier := GetErrClassifier(wType); classifier != nil {
+		return classifier(err)
+	}
+
+	return false
+}
+
+// isTransientErr 通用的暂时性错误: 超时 和 连接中断
+func isTransientErr(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr)
+}
+
+// isMysqlRetryableErr mysql 死锁 和 锁等待超时 可以重试
+func isMysqlRetryableErr(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlErrDeadlock || mysqlErr.Number == mysqlErrLockWaitTimeout
+	}
+
+	return errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// isElasticSearchRetryableErr es 限流、服务不可用 和 版本冲突 可以重试
+func isElasticSearchRetryableErr(err error) bool {
+	var esErr *elastic.Error
+	if errors.As(err, &esErr) {
+		switch esErr.Status {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusBadGateway,
+			http.StatusGatewayTimeout, http.StatusRequestTimeout, http.StatusConflict:
+			return true
+		}
+		return false
+	}
+
+	return elastic.IsConnErr(err)
+}
+
+// isPostgresRetryableErr postgres 死锁、序列化失败 和 锁等待失败 可以重试
+func isPostgresRetryableErr(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		_, ok := postgresRetryableCodes[pgErr.Code]
+		return ok
+	}
+
+	return false
+}
+
+// isClickHouseRetryableErr clickhouse 服务端错误 和 限流可以重试
+func isClickHouseRetryableErr(err error) bool {
+	var chErr *datasources.ClickHouseError
+	if errors.As(err, &chErr) {
+		return chErr.StatusCode >= 500 || chErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// isRedisRetryableErr redis 加载数据、主从切换 和 集群不可用时可以重试
+func isRedisRetryableErr(err error) bool {
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		for _, prefix := range redisRetryablePrefixes {
+			if strings.HasPrefix(redisErr.Error(), prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isKafkaRetryableErr kafka 服务端返回的暂时性错误可以重试，例如 leader 切换、限流
+func isKafkaRetryableErr(err error) bool {
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		return kafkaErr.Temporary()
+	}
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, writeErr := range writeErrs {
+			if writeErr != nil && !isKafkaRetryableErr(writeErr) && !isTransientErr(writeErr) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// isWebhookRetryableErr 回调服务端错误、限流 和 请求超时可以重试
+func isWebhookRetryableErr(err error) bool {
+	var webhookErr *datasources.WebhookError
+	if errors.As(err, &webhookErr) {
+		return webhookErr.StatusCode >= 500 || webhookErr.StatusCode == http.StatusTooManyRequests ||
+			webhookErr.StatusCode == http.StatusRequestTimeout
+	}
+
+	return false
+}
+
+// isMongoRetryableErr mongodb 网络错误、超时、带有可重试标签的错误 和 并发 upsert 导致的主键冲突可以重试
+func isMongoRetryableErr(err error) bool {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || mongo.IsDuplicateKeyError(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		return serverErr.HasErrorLabel("RetryableWriteError")
+	}
+
+	return false
+}
@@ -0,0 +1,65 @@
+package writers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"github.com/pkg/errors"
+)
+
+type (
+	// WebhookWriter 每条变更 POST 一个 json 请求到 数据源地址/TargetTable
+	// 非 2xx 响应作为写入错误，由处理器按重试策略重试 或 写入死信队列
+	WebhookWriter struct {
+		*writer
+	}
+
+	// WebhookPayload 回调请求体
+	WebhookPayload struct {
+		Target     string      `json:"target"`      // 同步规则目标 type:connect(.db).table
+		EventType  string      `json:"event_type"`  // 真实执行的事件类型 insert|update|delete
+		PrimaryKey string      `json:"primary_key"` // 主键值
+		EventAt    int64       `json:"event_at"`    // 事件时间 毫秒
+		Data       interface{} `json:"data"`        // 映射后的数据，格式和 SyncParams.GetUpdateValues 一致
+	}
+)
+
+func NewWebhookWriter(source datasources.DataSource) Writer {
+	return &WebhookWriter{&writer{dataSources: source}}
+}
+
+func (w *WebhookWriter) Insert(params *types.SyncParams, values interface{}) error {
+	return w.post(params, values)
+}
+
+func (w *WebhookWriter) Update(params *types.SyncParams, values interface{}) error {
+	return w.post(params, values)
+}
+
+// Delete 推送删除事件，数据为被删除记录的全部映射字段
+func (w *WebhookWriter) Delete(params *types.SyncParams) error {
+	return w.post(params, getAllUpdateValues(params))
+}
+
+func (w *WebhookWriter) post(params *types.SyncParams, values interface{}) error {
+	cliInter, err := w.dataSources.GetDataSource(params.Rule.Target)
+	if err != nil {
+		return err
+	}
+
+	var eventAt int64
+	if binLog := params.GetBingLogParams(); binLog != nil {
+		eventAt = binLog.EventAt
+	}
+	body, err := json.Marshal(&WebhookPayload{
+		Target: params.Rule.GetFullTarget(), EventType: params.RealEventType,
+		PrimaryKey: params.Data[params.Rule.PrimaryKey], EventAt: eventAt, Data: values,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// 超时时间由数据源配置的 http 客户端控制
+	return cliInter.(*datasources.WebhookClient).Post(context.Background(), params.Rule.TargetTable, body)
+}
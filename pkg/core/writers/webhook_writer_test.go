@@ -0,0 +1,68 @@
+package writers
+
+import (
+	"encoding/json"
+	"github.com/Junjiayy/hamal/pkg/core/datasources"
+	"github.com/Junjiayy/hamal/pkg/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookWriter(t *testing.T) {
+	var (
+		payload    WebhookPayload
+		statusCode = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/hooks/orders" || r.Header.Get("X-Token") != "token" ||
+			r.Header.Get(datasources.WebhookSignatureHeader) != datasources.SignWebhookBody("secret", body) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_ = json.Unmarshal(body, &payload)
+		w.WriteHeader(statusCode)
+	}))
+	defer srv.Close()
+
+	dataSource := datasources.NewWebhookDataSource()
+	if err := dataSource.SetConfigs(map[string]datasources.DataSourceConfig{
+		"legacy": {Name: "legacy", URL: srv.URL + "/hooks/", Headers: map[string]string{"X-Token": "token"},
+			Timeout: time.Second, Secret: "secret"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ww := NewWebhookWriter(dataSource)
+
+	rule := &types.SyncRule{
+		PrimaryKey: "id", TargetType: types.DataSourceWebhook, Target: "legacy", TargetTable: "orders",
+		SyncType: types.SyncTypeCopy, Columns: map[string]string{"id": "id", "price": "trans_price"},
+	}
+	binLog := &types.BinlogParams{EventType: types.EventTypeDelete, EventAt: 1709885119000}
+	params := types.NewSyncParams(types.NewSyncWaitGroup(), rule, map[string]string{"id": "1", "price": "5000"},
+		nil, binLog)
+
+	if err := ww.Delete(params); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := payload.Data.(map[string]interface{})
+	if payload.Target != "webhook:legacy.orders" || payload.EventType != types.EventTypeDelete ||
+		payload.PrimaryKey != "1" || payload.EventAt != 1709885119000 || data["trans_price"] != "5000" {
+		t.Fatalf("payload error: %+v", payload)
+	}
+
+	// 非 2xx 响应作为错误，服务端错误可以重试
+	statusCode = http.StatusServiceUnavailable
+	err := ww.Insert(params, map[string]string{"id": "1"})
+	if err == nil || !IsRetryableErr(types.DataSourceWebhook, err) {
+		t.Fatalf("service unavailable should be retryable error: %v", err)
+	}
+	statusCode = http.StatusUnprocessableEntity
+	if err := ww.Insert(params, map[string]string{"id": "1"}); err == nil ||
+		IsRetryableErr(types.DataSourceWebhook, err) {
+		t.Fatalf("unprocessable entity should not be retryable error: %v", err)
+	}
+}
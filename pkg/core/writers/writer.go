@@ -1,6 +1,7 @@
 package writers
 
 import (
+	"encoding/json"
 	"github.com/Junjiayy/hamal/pkg/core/datasources"
 	"github.com/Junjiayy/hamal/pkg/types"
 	"github.com/pkg/errors"
@@ -24,6 +25,20 @@ var _writerConstructors = make(map[string]WriterConstructor) // 写入构造函
 func init() {
 	SetWriterConstructor(types.DataSourceMysql, NewMysqlWriter)
 	SetWriterConstructor(types.DataSourceElasticSearch, NewElasticSearchWriter)
+	SetWriterConstructor(types.DataSourcePostgres, NewPostgresWriter)
+	SetWriterConstructor(types.DataSourceClickHouse, NewClickHouseWriter)
+	SetWriterConstructor(types.DataSourceRedis, NewRedisWriter)
+	SetWriterConstructor(types.DataSourceKafka, NewKafkaWriter)
+	SetWriterConstructor(types.DataSourceWebhook, NewWebhookWriter)
+	SetWriterConstructor(types.DataSourceMongo, NewMongoWriter)
+	SetErrClassifier(types.DataSourceMysql, isMysqlRetryableErr)
+	SetErrClassifier(types.DataSourceElasticSearch, isElasticSearchRetryableErr)
+	SetErrClassifier(types.DataSourcePostgres, isPostgresRetryableErr)
+	SetErrClassifier(types.DataSourceClickHouse, isClickHouseRetryableErr)
+	SetErrClassifier(types.DataSourceRedis, isRedisRetryableErr)
+	SetErrClassifier(types.DataSourceKafka, isKafkaRetryableErr)
+	SetErrClassifier(types.DataSourceWebhook, isWebhookRetryableErr)
+	SetErrClassifier(types.DataSourceMongo, isMongoRetryableErr)
 }
 
 func SetWriterConstructor(name string, fn WriterConstructor) {
@@ -45,6 +60,47 @@ func (w *writer) GetDataSource() datasources.DataSource {
 	return w.dataSources
 }
 
+// getAllUpdateValues 获取全部映射字段的数据，用于删除事件等没有 values 的场景
+func getAllUpdateValues(params *types.SyncParams) interface{} {
+	columns := make([]string, 0, len(params.Rule.Columns))
+	for column := range params.Rule.Columns {
+		columns = append(columns, column)
+	}
+
+	return params.GetUpdateValues(columns)
+}
+
+// toMapValues 把 copy 和 join 的记录转换为 map[string]interface{}，兼容字符串格式的记录
+func toMapValues(values interface{}) (map[string]interface{}, bool) {
+	switch v := values.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[string]string:
+		return strMpaToInterMap(v), true
+	}
+
+	return nil, false
+}
+
+// sqlValue 转换为数据库驱动支持的参数，json 字段以字符串写入
+func sqlValue(value interface{}) interface{} {
+	if raw, ok := value.(json.RawMessage); ok {
+		return string(raw)
+	}
+
+	return value
+}
+
+// sqlValues 转换记录中的全部值，参考 sqlValue
+func sqlValues(values map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(values))
+	for column, value := range values {
+		res[column] = sqlValue(value)
+	}
+
+	return res
+}
+
 // WriterPool 写入器池
 type WriterPool struct {
 	ws    map[string]Writer
@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"github.com/panjf2000/ants/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync/atomic"
+	"time"
+)
+
+const namespace = "hamal"
+
+// 同步规则任务的执行结果
+const (
+	RuleResultInvoked  = "invoked"  // 任务开始执行
+	RuleResultInserted = "inserted" // 新增成功
+	RuleResultUpdated  = "updated"  // 更新成功
+	RuleResultDeleted  = "deleted"  // 删除成功
+	RuleResultFiltered = "filtered" // 未通过过滤条件
+	RuleResultEmpty    = "empty"    // 没有需要同步的字段
+	RuleResultErrored  = "errored"  // 同步失败
+)
+
+var (
+	ReaderEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "reader", Name: "events_total",
+		Help: "Number of events read by reader.",
+	}, []string{"reader"})
+
+	ReaderDdlSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "reader", Name: "ddl_skipped_total",
+		Help: "Number of ddl events skipped by reader.",
+	}, []string{"reader"})
+
+	ReaderDecodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "reader", Name: "decode_errors_total",
+		Help: "Number of messages reader failed to decode.",
+	}, []string{"reader"})
+
+	ReaderCompleteSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "reader", Name: "complete_seconds",
+		Help:    "Latency of reader Complete.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"reader"})
+
+	ReaderEventLagSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "reader", Name: "event_lag_seconds",
+		Help: "Lag between event time and the time event is completed.",
+	}, []string{"reader"})
+
+	RuleTasks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Subsystem: "rule", Name: "tasks_total",
+		Help: "Number of sync tasks by rule and result.",
+	}, []string{"rule", "target", "result"})
+
+	WriterSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "writer", Name: "write_seconds",
+		Help:    "Latency of writer operations.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"writer", "target", "operation"})
+
+	LockWaitSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace, Subsystem: "lock", Name: "wait_seconds",
+		Help:    "Time waiting for redis record lock.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	pool atomic.Value // 当前的携程池 *ants.PoolWithFunc
+)
+
+func init() {
+	prometheus.MustRegister(ReaderEvents, ReaderDdlSkipped, ReaderDecodeErrors, ReaderCompleteSeconds,
+		ReaderEventLagSeconds, RuleTasks, WriterSeconds, LockWaitSeconds)
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "pool", Name: "running",
+		Help: "Number of running goroutines in pool.",
+	}, func() float64 {
+		if p, ok := pool.Load().(*ants.PoolWithFunc); ok {
+			return float64(p.Running())
+		}
+		return 0
+	}))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace, Subsystem: "pool", Name: "free",
+		Help: "Number of free goroutines in pool.",
+	}, func() float64 {
+		if p, ok := pool.Load().(*ants.PoolWithFunc); ok {
+			return float64(p.Free())
+		}
+		return 0
+	}))
+}
+
+// SetPool 设置需要统计的携程池，处理器创建后调用
+func SetPool(p *ants.PoolWithFunc) {
+	pool.Store(p)
+}
+
+// ObserveSince 记录从 start 开始到现在的耗时
+func ObserveSince(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
+
+// SetEventLag 根据事件时间 (毫秒) 设置 reader 的事件延迟
+func SetEventLag(reader string, eventAt int64) {
+	if eventAt <= 0 {
+		return
+	}
+	lag := time.Since(time.Unix(0, eventAt*int64(time.Millisecond)))
+	ReaderEventLagSeconds.WithLabelValues(reader).Set(lag.Seconds())
+}
+
+// IncRuleTask 规则任务结果计数
+func IncRuleTask(rule, target, result string) {
+	RuleTasks.WithLabelValues(rule, target, result).Inc()
+}
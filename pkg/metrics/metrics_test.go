@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"github.com/panjf2000/ants/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetEventLag(t *testing.T) {
+	eventAt := time.Now().Add(-3*time.Second).UnixNano() / int64(time.Millisecond)
+	SetEventLag("test", eventAt)
+	if lag := testutil.ToFloat64(ReaderEventLagSeconds.WithLabelValues("test")); lag < 3 || lag > 4 {
+		t.Fatalf("event lag should be about 3s, current: %f", lag)
+	}
+
+	// 没有事件时间时不更新
+	SetEventLag("test", 0)
+	if lag := testutil.ToFloat64(ReaderEventLagSeconds.WithLabelValues("test")); lag < 3 {
+		t.Fatalf("event lag should not be updated, current: %f", lag)
+	}
+}
+
+func TestServer(t *testing.T) {
+	if NewServer(&Config{Path: "/metrics"}) != nil {
+		t.Fatal("server should be disabled without listen address")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	pool, err := ants.NewPoolWithFunc(3, func(interface{}) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Release()
+	SetPool(pool)
+	IncRuleTask("test_orders", "es:default.orders", RuleResultInserted)
+
+	s := NewServer(&Config{Listen: addr, Path: "/metrics"})
+	s.Start()
+	defer s.Stop()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/metrics"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"hamal_pool_free 3",
+		`hamal_rule_tasks_total{result="inserted",rule="test_orders",target="es:default.orders"} 1`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Fatalf("metrics should contain %s", expected)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const serverShutdownTimeout = 5 * time.Second // 关闭监控服务的超时时间
+
+type (
+	// Config 监控服务配置 listen 为空时不开启
+	Config struct {
+		Listen string `json:"listen,omitempty" yaml:"listen,omitempty"`                // 监听地址 例如 :9100
+		Path   string `json:"path,omitempty" yaml:"path,omitempty" default:"/metrics"` // 指标路径
+	}
+
+	Server struct {
+		srv *http.Server
+	}
+)
+
+// NewServer 创建监控服务，未配置监听地址时返回 nil
+func NewServer(conf *Config) *Server {
+	if conf.Listen == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle(conf.Path, promhttp.Handler())
+
+	return &Server{srv: &http.Server{Addr: conf.Listen, Handler: mux}}
+}
+
+// Start 在后台开启监控服务
+func (s *Server) Start() {
+	go func() {
+		zap.L().Info("metrics server start", zap.String("addr", s.srv.Addr))
+		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			zap.L().Error("metrics server failed", zap.Error(err))
+		}
+	}()
+}
+
+// Stop 关闭监控服务
+func (s *Server) Stop() error {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancelFunc()
+
+	return s.srv.Shutdown(ctx)
+}
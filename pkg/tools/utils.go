@@ -118,26 +118,6 @@ func getFieldDefaultValueByReflect(defaultValue string, field reflect.Value, val
 	return nil
 }
 
-// JudgmentEval 判断 动态执行
-func JudgmentEval(first, two string, operator string) bool {
-	switch operator {
-	case ">":
-		return first > two
-	case "<":
-		return first < two
-	case "=":
-		return first == two
-	case "!=":
-		return first != two
-	case ">=":
-		return first >= two
-	case "<=":
-		return first <= two
-	}
-
-	return false
-}
-
 // CamelToSnake 驼峰式字符串转下划线式
 func CamelToSnake(dest string) string {
 	var result strings.Builder
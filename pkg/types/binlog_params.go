@@ -16,18 +16,125 @@ type (
 		Data      []map[string]string `json:"data" binding:"required"`     // 更新后数据 (全量数据，根据 canal: canal.instance.filter.regex 的字段规则，没有字段规则就是全量)
 		Old       []map[string]string `json:"old" binding:"omitempty"`     // 更新前数据 (只存在被更新的字段)
 		Source    interface{}         `json:"-" binding:"omitempty"`       // 原始数据
+
+		MysqlType   map[string]string `json:"mysqlType,omitempty" binding:"omitempty"`    // canal 字段的 mysql 类型
+		SqlType     map[string]int    `json:"sqlType,omitempty" binding:"omitempty"`      // canal 字段的 java.sql.Types 类型
+		ColumnTypes map[string]string `json:"column_types,omitempty" binding:"omitempty"` // 字段类型 ColumnType*，为空代表全部是字符串
+		Nulls       []map[string]bool `json:"nulls,omitempty" binding:"omitempty"`        // Data 中每条记录值为 null 的字段，下标和 Data 一致
+		OldNulls    []map[string]bool `json:"old_nulls,omitempty" binding:"omitempty"`    // Old 中每条记录值为 null 的字段，下标和 Old 一致
 	}
 
 	innerBinlogParams BinlogParams
+
+	// binlogParamsRows 解析 data 和 old 时区分 null 和空字符串，覆盖 innerBinlogParams 的同名字段
+	binlogParamsRows struct {
+		*innerBinlogParams
+		Data []map[string]*string `json:"data"`
+		Old  []map[string]*string `json:"old"`
+	}
 )
 
 // UnmarshalJSON 重写 json 解析方法，如果是更新事件，记录本次更新的字段
+// data 和 old 中值为 null 的字段记录到 Nulls 和 OldNulls，并根据 canal 的 mysqlType 和 sqlType 获取字段类型
 func (c *BinlogParams) UnmarshalJSON(bytes []byte) error {
-	innerParams := (*innerBinlogParams)(c)
-	if err := json.Unmarshal(bytes, innerParams); err != nil {
+	rows := binlogParamsRows{innerBinlogParams: (*innerBinlogParams)(c)}
+	if err := json.Unmarshal(bytes, &rows); err != nil {
 		return err
 	}
-	innerParams.EventType = strings.ToLower(innerParams.EventType)
+	c.EventType = strings.ToLower(c.EventType)
+
+	c.Data, c.Old = make([]map[string]string, len(rows.Data)), nil
+	for i, row := range rows.Data {
+		c.Data[i] = make(map[string]string, len(row))
+		for column, value := range row {
+			if value == nil {
+				c.SetNull(i, column)
+				c.Data[i][column] = ""
+			} else {
+				c.Data[i][column] = *value
+			}
+		}
+	}
+	for i, row := range rows.Old {
+		old := make(map[string]string, len(row))
+		for column, value := range row {
+			if value != nil {
+				old[column] = *value
+			} else {
+				c.SetOldNull(i, column)
+				old[column] = ""
+			}
+		}
+		c.Old = append(c.Old, old)
+	}
+	if len(c.ColumnTypes) == 0 {
+		c.ColumnTypes = c.normalizeCanalTypes()
+	}
+
+	return nil
+}
+
+// SetNull 标记 Data 中第 i 条记录的字段值为 null
+func (c *BinlogParams) SetNull(i int, column string) {
+	c.Nulls = setRowNull(c.Nulls, i, column)
+}
+
+// GetNulls 获取 Data 中第 i 条记录值为 null 的字段
+func (c *BinlogParams) GetNulls(i int) map[string]bool {
+	if i < len(c.Nulls) {
+		return c.Nulls[i]
+	}
+
+	return nil
+}
+
+// SetOldNull 标记 Old 中第 i 条记录的字段更新前的值为 null
+func (c *BinlogParams) SetOldNull(i int, column string) {
+	c.OldNulls = setRowNull(c.OldNulls, i, column)
+}
+
+// GetOldNulls 获取 Old 中第 i 条记录更新前值为 null 的字段
+func (c *BinlogParams) GetOldNulls(i int) map[string]bool {
+	if i < len(c.OldNulls) {
+		return c.OldNulls[i]
+	}
 
 	return nil
 }
+
+func setRowNull(nulls []map[string]bool, i int, column string) []map[string]bool {
+	for len(nulls) <= i {
+		nulls = append(nulls, nil)
+	}
+	if nulls[i] == nil {
+		nulls[i] = make(map[string]bool)
+	}
+	nulls[i][column] = true
+
+	return nulls
+}
+
+// SetColumnType 设置字段类型
+func (c *BinlogParams) SetColumnType(column, columnType string) {
+	if c.ColumnTypes == nil {
+		c.ColumnTypes = make(map[string]string)
+	}
+	c.ColumnTypes[column] = columnType
+}
+
+// normalizeCanalTypes 根据 canal 的 mysqlType 获取字段类型，没有 mysqlType 时使用 sqlType
+func (c *BinlogParams) normalizeCanalTypes() map[string]string {
+	if len(c.MysqlType) == 0 && len(c.SqlType) == 0 {
+		return nil
+	}
+
+	columnTypes := make(map[string]string, len(c.MysqlType)+len(c.SqlType))
+	for column, sqlType := range c.SqlType {
+		columnTypes[column] = NormalizeSqlType(sqlType)
+	}
+	for column, mysqlType := range c.MysqlType {
+		columnTypes[column] = NormalizeMysqlType(mysqlType)
+	}
+
+	return columnTypes
+}
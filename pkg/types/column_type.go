@@ -0,0 +1,118 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sqlTypeColumnTypes canal sqlType (java.sql.Types) 对应的字段类型
+var sqlTypeColumnTypes = map[int]string{
+	-7: ColumnTypeBool, // BIT
+	-6: ColumnTypeInt,  // TINYINT
+	5:  ColumnTypeInt,  // SMALLINT
+	4:  ColumnTypeInt,  // INTEGER
+	-5: ColumnTypeInt,  // BIGINT
+	2:  ColumnTypeDecimal,
+	3:  ColumnTypeDecimal,
+	6:  ColumnTypeFloat, // FLOAT
+	7:  ColumnTypeFloat, // REAL
+	8:  ColumnTypeFloat, // DOUBLE
+	16: ColumnTypeBool,  // BOOLEAN
+	91: ColumnTypeTime,  // DATE
+	92: ColumnTypeTime,  // TIME
+	93: ColumnTypeTime,  // TIMESTAMP
+}
+
+// NormalizeMysqlType 把 mysql 字段类型 (例如 bigint(20) unsigned) 转换为字段类型
+// tinyint(1) 和 bit(1) 按照惯例作为布尔值，不是布尔值时 ConvertValue 按整数转换
+func NormalizeMysqlType(mysqlType string) string {
+	mysqlType = strings.ToLower(strings.TrimSpace(mysqlType))
+	name := mysqlType
+	if i := strings.IndexAny(name, "( "); i >= 0 {
+		name = name[:i]
+	}
+
+	switch name {
+	case "tinyint", "bit":
+		if strings.HasPrefix(mysqlType, name+"(1)") {
+			return ColumnTypeBool
+		}
+		return ColumnTypeInt
+	case "smallint", "mediumint", "int", "integer", "bigint", "year":
+		return ColumnTypeInt
+	case "decimal", "numeric":
+		return ColumnTypeDecimal
+	case "float", "double", "real":
+		return ColumnTypeFloat
+	case "bool", "boolean":
+		return ColumnTypeBool
+	case "date", "datetime", "timestamp", "time":
+		return ColumnTypeTime
+	case "json":
+		return ColumnTypeJson
+	}
+
+	return ColumnTypeString
+}
+
+// NormalizeSqlType 把 canal sqlType 转换为字段类型
+func NormalizeSqlType(sqlType int) string {
+	if columnType, ok := sqlTypeColumnTypes[sqlType]; ok {
+		return columnType
+	}
+
+	return ColumnTypeString
+}
+
+// ConvertValue 把字符串格式的值转换为字段类型对应的值，转换失败时返回原字符串
+// decimal 使用 json.Number 保留精度，json 使用 json.RawMessage，time 保持字符串格式
+func ConvertValue(value, columnType string) interface{} {
+	switch columnType {
+	case ColumnTypeInt:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v
+		} else if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return v
+		}
+	case ColumnTypeDecimal:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	case ColumnTypeFloat:
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v
+		}
+	case ColumnTypeBool:
+		if v, err := strconv.ParseBool(value); err == nil {
+			return v
+		}
+		// tinyint(1) 可以保存 0 和 1 以外的值，这些值按整数转换，不能作为字符串写入
+		return ConvertValue(value, ColumnTypeInt)
+	case ColumnTypeJson:
+		if json.Valid([]byte(value)) {
+			return json.RawMessage(value)
+		}
+	}
+
+	return value
+}
+
+// FormatValue 把 ConvertValue 转换后的值格式化为字符串，nil 为空字符串
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case json.RawMessage:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(value)
+}
@@ -7,6 +7,38 @@ const EventTypeDelete = "delete" // 删除事件
 const ConditionTypeAnd = "and" // 多条件与关系
 const ConditionTypeOr = "or"   // 多条件或关系
 
+const ConditionOperatorIn = "in"                 // 值在 values 中
+const ConditionOperatorNotIn = "not in"          // 值不在 values 中
+const ConditionOperatorLike = "like"             // sql like 匹配
+const ConditionOperatorRegex = "regex"           // 正则匹配
+const ConditionOperatorIsNull = "is null"        // 字段为 null
+const ConditionOperatorIsNotNull = "is not null" // 字段不为 null
+const ConditionOperatorBetween = "between"       // 值在 values 两个值之间，包含边界
+
+const ConditionValueTypeInt = "int"         // 整数比较
+const ConditionValueTypeDecimal = "decimal" // 小数精确比较
+const ConditionValueTypeTime = "time"       // 时间比较
+const ConditionValueTypeString = "string"   // 字符串比较
+
+const ColumnTypeInt = "int"         // 整数
+const ColumnTypeDecimal = "decimal" // 定点小数
+const ColumnTypeFloat = "float"     // 浮点数
+const ColumnTypeBool = "bool"       // 布尔值
+const ColumnTypeTime = "time"       // 日期时间，保持字符串格式
+const ColumnTypeJson = "json"       // json
+const ColumnTypeString = "string"   // 字符串
+
+const TransformCast = "cast"                       // 类型转换
+const TransformDateFormat = "date_format"          // 时间格式转换
+const TransformUnixToDatetime = "unix_to_datetime" // 时间戳转时间
+const TransformDatetimeToUnix = "datetime_to_unix" // 时间转时间戳
+const TransformJson = "json"                       // 解析 json 文本
+const TransformConcat = "concat"                   // 拼接多个来源字段
+const TransformMap = "map"                         // 静态映射表查找
+const TransformHash = "hash"                       // 哈希
+const TransformMask = "mask"                       // 掩码
+const TransformDefault = "default"                 // null 默认值
+
 const InnerSyncTypeDefaultKey = "innerSyncTypeDefaultKey"
 
 const SyncTypeCopy = "copy"   // 可复制的字段全部拷贝到目标数据源作为一条新的记录
@@ -15,11 +47,31 @@ const SyncTypeInner = "inner" // 只允许复制一个字段，复制到目标
 
 const identifyIdColumnSeparator = "-" // 标识ID字段分隔符
 
-const ReaderTypeWeb = "web"     // web 类型读取器
-const ReaderTypeKafka = "kafka" // kafka 类型读取器
+const ReaderTypeWeb = "web"       // web 类型读取器
+const ReaderTypeKafka = "kafka"   // kafka 类型读取器
+const ReaderTypeBinlog = "binlog" // mysql binlog 类型读取器
 
-const DataSourceMysql = "mysql"      // mysql 类型数据源
-const DataSourceElasticSearch = "es" // es 类型数据源
+const MessageFormatCanal = "canal"       // canal flat message 格式
+const MessageFormatDebezium = "debezium" // debezium 变更消息格式
+const MessageFormatMaxwell = "maxwell"   // maxwell 变更消息格式
+
+const DataSourceMysql = "mysql"           // mysql 类型数据源
+const DataSourceElasticSearch = "es"      // es 类型数据源
+const DataSourcePostgres = "postgres"     // postgres 类型数据源
+const DataSourceClickHouse = "clickhouse" // clickhouse 类型数据源
+const DataSourceRedis = "redis"           // redis 类型数据源
+const DataSourceKafka = "kafka"           // kafka 类型数据源
+const DataSourceWebhook = "webhook"       // http 回调类型数据源
+const DataSourceMongo = "mongodb"         // mongodb 类型数据源
+
+const NoRowsAffectedIgnore = "ignore" // 更新或删除影响行数为 0 时忽略
+const NoRowsAffectedError = "error"   // 更新或删除影响行数为 0 时返回错误
+const NoRowsAffectedInsert = "insert" // 更新影响行数为 0 时插入完整记录
 
 const TimestampCreatedAt = "created_at" // 创建时间戳
 const TimestampUpdatedAt = "updated_at" // 更新时间戳
+const TimestampLayout = "2006-01-02 15:04:05"
+
+const DeadLetterKafka = "kafka" // kafka topic 类型死信队列
+const DeadLetterRedis = "redis" // redis stream 类型死信队列
+const DeadLetterFile = "file"   // 本地 jsonl 文件类型死信队列
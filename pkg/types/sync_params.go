@@ -3,6 +3,7 @@ package types
 import (
 	"strings"
 	"sync"
+	"time"
 )
 
 // SyncParams 同步参数
@@ -13,6 +14,8 @@ type SyncParams struct {
 	Rule          SyncRule          `json:"rule"` // 只读，不用指针传递
 	Data          map[string]string `json:"data"`
 	Old           map[string]string `json:"old"`
+	Nulls         map[string]bool   `json:"nulls,omitempty"`     // Data 中值为 null 的字段
+	OldNulls      map[string]bool   `json:"old_nulls,omitempty"` // Old 中更新前值为 null 的字段
 	binLogParams  *BinlogParams
 	RealEventType string `json:"real_event_type"` // 和 BinlogParams 的 EventType 重复，用于记录真实执行同步的事件类型
 	joinColumn    string
@@ -25,7 +28,7 @@ func (s *SyncParams) GetWg() *syncWaitGroup {
 func NewSyncParams(wg *syncWaitGroup, rule *SyncRule, data, old map[string]string, binLog *BinlogParams) *SyncParams {
 	params := _syncParamsPool.Get().(*SyncParams)
 	params.wg, params.Rule, params.Data, params.Old, params.binLogParams = wg, *rule, data, old, binLog
-	params.joinColumn, params.RealEventType = "", binLog.EventType
+	params.joinColumn, params.RealEventType, params.Nulls, params.OldNulls = "", binLog.EventType, nil, nil
 
 	return params
 }
@@ -43,6 +46,15 @@ func (s *SyncParams) SetBinLogParams(params *BinlogParams) {
 	s.binLogParams = params
 }
 
+// GetSource 获取来源库名和表名，通配和正则规则的库名和表名是匹配规则，使用 binlog 中的实际库名和表名
+func (s *SyncParams) GetSource() (database, table string) {
+	if s.binLogParams != nil {
+		return s.binLogParams.Database, s.binLogParams.Table
+	}
+
+	return s.Rule.Database, s.Rule.Table
+}
+
 // GetJoinColumn 当 SyncType 等于 SyncTypeInner 时只同步一个字段, 暂时缓存起来
 // 同一个 SyncParams 只会被一个协程使用， 所以不存在并发问题
 func (s *SyncParams) GetJoinColumn() string {
@@ -79,14 +91,54 @@ func (s *SyncParams) MergeOldToData() map[string]string {
 	return newData
 }
 
-// GetUpdateValues 获取当次更新的数据 格式: {"column": "value"}
+// MergeOldNulls 获取这条记录 更新之前值为 null 的字段，和 MergeOldToData 对应
+func (s *SyncParams) MergeOldNulls() map[string]bool {
+	if s.Old == nil {
+		return s.Nulls
+	}
+
+	nulls := make(map[string]bool, len(s.Nulls)+len(s.OldNulls))
+	for column := range s.Data {
+		if _, ok := s.Old[column]; ok {
+			if s.OldNulls[column] {
+				nulls[column] = true
+			}
+		} else if s.Nulls[column] {
+			nulls[column] = true
+		}
+	}
+
+	return nulls
+}
+
+// EvaluateFilterConditions 判断本次变更是否符合同步条件
+func (s *SyncParams) EvaluateFilterConditions() bool {
+	return s.Rule.EvaluateFilterConditions(s.Data, s.Old, s.Nulls, s.OldNulls)
+}
+
+// EvaluateOldFilterConditions 判断更新之前的数据是否符合同步条件，条件中的 old 字段使用更新前的值
+func (s *SyncParams) EvaluateOldFilterConditions() bool {
+	return s.Rule.EvaluateFilterConditions(s.MergeOldToData(), nil, s.MergeOldNulls(), nil)
+}
+
+// GetUpdateValues 获取当次更新的数据 格式: {"column": value}
+// 值按照字段类型转换，null 字段为 nil，没有字段类型时为字符串，参考 ConvertValue
+// 配置了字段转换的目标字段返回转换后的值，计算字段在依赖的来源字段被更新时重新计算
 func (s *SyncParams) GetUpdateValues(updatedColumns []string) interface{} {
+	transformers := s.Rule.getTransformers()
 
 	switch s.Rule.SyncType {
 	case SyncTypeCopy, SyncTypeJoin: // 拷贝记录，作为目标表的一条新记录
-		record := make(map[string]string, len(updatedColumns)+len(s.Rule.TargetExtraParams))
+		record := make(map[string]interface{}, len(updatedColumns)+len(s.Rule.TargetExtraParams))
 		for _, column := range updatedColumns {
-			record[s.Rule.Columns[column]] = s.Data[column]
+			target := s.Rule.Columns[column]
+			record[target] = s.getValue(column, transformers[target])
+		}
+		for target, transformer := range transformers {
+			if _, ok := record[target]; !ok && transformer.sources != nil && transformer.isUpdated(updatedColumns) {
+				value, _ := transformer.apply("", false, s.Data)
+				record[target] = ConvertValue(value, transformer.columnType)
+			}
 		}
 
 		if s.Rule.TargetExtraParams != nil {
@@ -95,6 +147,15 @@ func (s *SyncParams) GetUpdateValues(updatedColumns []string) interface{} {
 			}
 		}
 
+		if s.Rule.SyncType == SyncTypeCopy {
+			if s.Rule.VersionColumn != "" {
+				record[s.Rule.VersionColumn] = s.GetVersion()
+			}
+			if s.Rule.AutoUpdatedAt {
+				record[TimestampUpdatedAt] = time.Now().Format(TimestampLayout)
+			}
+		}
+
 		if s.Rule.SyncType == SyncTypeJoin {
 			return map[string]interface{}{
 				s.Rule.Columns[s.Rule.PrimaryKey]: s.Data[s.Rule.PrimaryKey],
@@ -105,12 +166,60 @@ func (s *SyncParams) GetUpdateValues(updatedColumns []string) interface{} {
 		return record
 	case SyncTypeInner:
 		// 只取一个字段, 作为目标表的某个字段的 一个元素, 例如es数组 add
-		return s.Data[s.GetJoinColumn()]
+		return s.GetJoinValue()
 	}
 
 	return nil
 }
 
+// GetJoinValue 获取 inner 同步的元素值，配置了字段转换时返回转换后的值
+// 写入和删除元素都使用这个值，保证删除的元素和写入的元素相同
+func (s *SyncParams) GetJoinValue() interface{} {
+	return s.getValue(s.GetJoinColumn(), s.Rule.getTransformers()[s.Rule.JoinFieldName])
+}
+
+// GetValue 获取来源字段按照字段类型转换后的值，null 字段返回 nil
+func (s *SyncParams) GetValue(column string) interface{} {
+	return s.getValue(column, nil)
+}
+
+// getValue 获取来源字段按照字段类型转换后的值，transformer 不为空时先执行字段转换
+// null 字段转换后仍为空时返回 nil，例如 default 可以把 null 转换为默认值
+func (s *SyncParams) getValue(column string, transformer *columnTransformer) interface{} {
+	value, columnType, null := s.Data[column], s.GetColumnType(column), s.Nulls[column]
+	if transformer != nil {
+		value, null = transformer.apply(value, null, s.Data)
+		if transformer.columnType != "" {
+			columnType = transformer.columnType
+		}
+	}
+	if null {
+		return nil
+	}
+
+	return ConvertValue(value, columnType)
+}
+
+// GetColumnType 获取来源字段的类型，来源没有提供字段类型时为 ColumnTypeString
+func (s *SyncParams) GetColumnType(column string) string {
+	if s.binLogParams != nil {
+		if columnType, ok := s.binLogParams.ColumnTypes[column]; ok {
+			return columnType
+		}
+	}
+
+	return ColumnTypeString
+}
+
+// GetVersion 获取写入目标的版本号，使用事件时间
+func (s *SyncParams) GetVersion() int64 {
+	if s.binLogParams == nil {
+		return 0
+	}
+
+	return s.binLogParams.EventAt
+}
+
 // IsPrimaryKeyUpdated 判断主键 或关联字段是否更新
 func (s *SyncParams) IsPrimaryKeyUpdated() bool {
 	_, primaryKeyUpdated := s.Old[s.Rule.PrimaryKey]
@@ -122,14 +231,14 @@ func (s *SyncParams) IsPrimaryKeyUpdated() bool {
 	return primaryKeyUpdated
 }
 
-// GetIdentifyId 获取标识id
+// GetIdentifyId 获取标识id, 由加锁字段的值拼接而成
 func (s *SyncParams) GetIdentifyId() string {
-	identifyIdColumns := make([]string, len(s.Rule.LockColumns))
+	identifyIdValues := make([]string, 0, len(s.Rule.LockColumns))
 	for _, column := range s.Rule.LockColumns {
-		identifyIdColumns = append(identifyIdColumns, column)
+		identifyIdValues = append(identifyIdValues, s.Data[column])
 	}
 
-	return strings.Join(identifyIdColumns,
+	return strings.Join(identifyIdValues,
 		identifyIdColumnSeparator)
 }
 
@@ -138,7 +247,7 @@ func (s *SyncParams) GetIdentifyId() string {
 func (s *SyncParams) Clone(eventType string) *SyncParams {
 	params := _syncParamsPool.Get().(*SyncParams)
 	params.wg, params.Rule, params.Data = s.wg, s.Rule, s.Data
-	params.Old, params.binLogParams = s.Old, s.binLogParams
+	params.Old, params.Nulls, params.OldNulls, params.binLogParams = s.Old, s.Nulls, s.OldNulls, s.binLogParams
 	params.RealEventType = eventType
 
 	return params
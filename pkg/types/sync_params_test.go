@@ -1,7 +1,13 @@
 package types
 
 import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestSyncParams_GetUpdateValues(t *testing.T) {
@@ -37,9 +43,9 @@ func TestSyncParams_GetUpdateValues(t *testing.T) {
 	params := NewSyncParams(wg, &rule, data, nil, binLog)
 	columns := []string{"id", "name", "age"}
 	values := params.GetUpdateValues(columns)
-	mapping, ok := values.(map[string]string)
+	mapping, ok := values.(map[string]interface{})
 	if !ok {
-		t.Fatalf("get update value type error, expect: map[string]string, actual: %T", values)
+		t.Fatalf("get update value type error, expect: map[string]interface{}, actual: %T", values)
 	}
 
 	if len(mapping) != len(columns) {
@@ -47,6 +53,14 @@ func TestSyncParams_GetUpdateValues(t *testing.T) {
 			len(columns), len(mapping))
 	}
 
+	// 版本字段和更新时间只写入 copy
+	params.Rule.VersionColumn, params.Rule.AutoUpdatedAt = "version", true
+	mapping = params.GetUpdateValues([]string{"name"}).(map[string]interface{})
+	if mapping["version"] != int64(1709026288000) || mapping[TimestampUpdatedAt] == "" || len(mapping) != 3 {
+		t.Fatalf("version and updated_at should be filled: %v", mapping)
+	}
+	params.Rule.VersionColumn, params.Rule.AutoUpdatedAt = "", false
+
 	params.Rule.SyncType = SyncTypeJoin
 	params.Rule.JoinFieldName = "test_join_name"
 	values = params.GetUpdateValues(columns)
@@ -60,9 +74,9 @@ func TestSyncParams_GetUpdateValues(t *testing.T) {
 		t.Fatalf("field %s not exists", params.Rule.JoinFieldName)
 	}
 
-	mapping, ok = record.(map[string]string)
+	mapping, ok = record.(map[string]interface{})
 	if !ok {
-		t.Fatalf("get update value type error, expect: map[string]string, actual: %T", values)
+		t.Fatalf("get update value type error, expect: map[string]interface{}, actual: %T", values)
 	}
 
 	if len(mapping) != len(columns) {
@@ -83,3 +97,140 @@ func TestSyncParams_GetUpdateValues(t *testing.T) {
 			params.Data["name"], valueStr)
 	}
 }
+
+func TestSyncParams_transforms(t *testing.T) {
+	rule := SyncRule{
+		Database: "test", Table: "orders", PrimaryKey: "id", SyncType: SyncTypeCopy,
+		Target: "test", TargetType: DataSourceElasticSearch, TargetTable: "orders",
+		Columns: map[string]string{
+			"id": "id", "price": "price", "paid_at": "paid_at", "created_at": "created_ts", "extra": "city",
+			"status": "status", "mobile": "mobile", "email": "email_hash", "remark": "remark",
+			"first_name": "first_name", "last_name": "last_name", "payload": "trade_no",
+		},
+		Transforms: map[string][]ColumnTransform{
+			"price":      {{Type: TransformCast, To: ConditionValueTypeDecimal, Scale: 2}},
+			"paid_at":    {{Type: TransformUnixToDatetime, Layout: "2006-01-02"}},
+			"created_ts": {{Type: TransformDatetimeToUnix, Unit: "ms"}},
+			"city":       {{Type: TransformJson, Path: "address.city"}},
+			"trade_no":   {{Type: TransformJson, Path: "trade.no"}},
+			"status":     {{Type: TransformMap, Mapping: map[string]string{"1": "paid"}, Value: "unknown"}},
+			"mobile":     {{Type: TransformMask, KeepStart: 3, KeepEnd: 4}},
+			"email_hash": {{Type: TransformHash, Algorithm: "md5"}},
+			"remark":     {{Type: TransformDefault, Value: "-"}},
+			"full_name":  {{Type: TransformConcat, Columns: []string{"first_name", "last_name"}, Separator: " "}},
+		},
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatalf("transforms should be valid: %v", err)
+	}
+
+	data := map[string]string{
+		"id": "1", "price": "5000", "paid_at": "1709885119", "created_at": "2024-03-08 16:05:19",
+		"extra": `{"address":{"city":"hangzhou"}}`, "status": "2", "mobile": "13812345678",
+		"email": "a@b.c", "remark": "", "first_name": "san", "last_name": "zhang",
+		"payload": `{"trade":{"no":12345678901234567890}}`,
+	}
+	params := NewSyncParams(NewSyncWaitGroup(), &rule, data, nil, &BinlogParams{EventType: EventTypeInsert})
+	var columns []string
+	for column := range rule.Columns {
+		columns = append(columns, column)
+	}
+	record := params.GetUpdateValues(columns).(map[string]interface{})
+
+	createdAt, _ := time.ParseInLocation(TimestampLayout, "2024-03-08 16:05:19", time.Local)
+	expects := map[string]string{
+		"price": "5000.00", "paid_at": time.Unix(1709885119, 0).Format("2006-01-02"),
+		"created_ts": strconv.FormatInt(createdAt.UnixMilli(), 10), "city": "hangzhou", "status": "unknown",
+		"mobile": "138****5678", "email_hash": fmt.Sprintf("%x", md5.Sum([]byte("a@b.c"))), "remark": "",
+		"full_name": "san zhang", "trade_no": "12345678901234567890",
+	}
+	for column, expect := range expects {
+		if fmt.Sprint(record[column]) != expect {
+			t.Fatalf("column %s should be %q, actual: %v", column, expect, record[column])
+		}
+	}
+
+	// default 只替换 null，空字符串保持原值
+	params.Nulls = map[string]bool{"remark": true}
+	if record = params.GetUpdateValues([]string{"remark"}).(map[string]interface{}); record["remark"] != "-" {
+		t.Fatalf("null remark should be replaced by default, actual: %v", record["remark"])
+	}
+	params.Nulls = nil
+
+	// 计算字段只在依赖的来源字段更新时写入
+	record = params.GetUpdateValues([]string{"price"}).(map[string]interface{})
+	if _, ok := record["full_name"]; ok || len(record) != 1 {
+		t.Fatalf("computed column should not be written: %v", record)
+	}
+}
+
+func TestSyncParams_typedValues(t *testing.T) {
+	var binLog BinlogParams
+	err := json.Unmarshal([]byte(`{"database":"test","table":"orders","type":"UPDATE","ts":1709885119000,
+		"data":[{"id":"1","price":"50.10","paid":"1","attrs":"{\"a\":1}","remark":null,"name":"xx"}],
+		"old":[{"name":null}],
+		"mysqlType":{"id":"bigint(20) unsigned","price":"decimal(10,2)","paid":"tinyint(1)","attrs":"json",
+		"remark":"varchar(32)"},"sqlType":{"name":12}}`), &binLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binLog.EventType != EventTypeUpdate || !binLog.GetNulls(0)["remark"] || binLog.Old[0]["name"] != "" ||
+		!binLog.GetOldNulls(0)["name"] {
+		t.Fatalf("canal nulls should be recorded: %+v", binLog)
+	}
+
+	rule := SyncRule{
+		Database: "test", Table: "orders", PrimaryKey: "id", SyncType: SyncTypeCopy,
+		Columns: map[string]string{"id": "id", "price": "price", "paid": "paid", "attrs": "attrs",
+			"remark": "remark", "name": "name"},
+	}
+	params := NewSyncParams(NewSyncWaitGroup(), &rule, binLog.Data[0], binLog.Old[0], &binLog)
+	params.Nulls, params.OldNulls = binLog.GetNulls(0), binLog.GetOldNulls(0)
+	// 更新前 name 为 null，remark 没有被更新
+	if nulls := params.MergeOldNulls(); len(nulls) != 2 || !nulls["name"] || !nulls["remark"] {
+		t.Fatalf("merged old nulls error: %v", nulls)
+	}
+	record := params.GetUpdateValues([]string{"id", "price", "paid", "attrs", "remark", "name"})
+	expects := map[string]interface{}{
+		"id": int64(1), "price": json.Number("50.10"), "paid": true, "attrs": json.RawMessage(`{"a":1}`),
+		"remark": nil, "name": "xx",
+	}
+	if !reflect.DeepEqual(record, expects) {
+		t.Fatalf("typed values error: %#v", record)
+	}
+
+	// 转换后的字段类型由转换步骤决定，default 可以替换 null
+	rule.Transforms = map[string][]ColumnTransform{
+		"price": {{Type: TransformCast, To: ColumnTypeString}}, "remark": {{Type: TransformDefault, Value: "-"}},
+	}
+	params.Rule = rule
+	record = params.GetUpdateValues([]string{"price", "remark"})
+	if expects = map[string]interface{}{"price": "50.10", "remark": "-"}; !reflect.DeepEqual(record, expects) {
+		t.Fatalf("transformed typed values error: %#v", record)
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		mysqlType, value string
+		expect           interface{}
+	}{
+		{"int(11)", "-1", int64(-1)},
+		{"bigint(20) unsigned", "18446744073709551615", uint64(18446744073709551615)},
+		{"tinyint(1)", "0", false},
+		{"tinyint(1)", "2", int64(2)},
+		{"tinyint(1)", "-1", int64(-1)},
+		{"tinyint(4)", "3", int64(3)},
+		{"double", "1.5", 1.5},
+		{"decimal(10,2)", "1.50", json.Number("1.50")},
+		{"datetime", "2024-03-08 16:05:19", "2024-03-08 16:05:19"},
+		{"json", "[1,2]", json.RawMessage("[1,2]")},
+		{"json", "{", "{"},
+		{"varchar(32)", "1", "1"},
+	}
+	for _, test := range tests {
+		if actual := ConvertValue(test.value, NormalizeMysqlType(test.mysqlType)); !reflect.DeepEqual(actual, test.expect) {
+			t.Fatalf("%s %q should be %#v, actual: %#v", test.mysqlType, test.value, test.expect, actual)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package types
 
 import (
 	"encoding/json"
-	"github.com/Junjiayy/hamal/pkg/tools"
 	"github.com/pkg/errors"
 	"strings"
 )
@@ -10,35 +9,49 @@ import (
 type (
 	// SyncRule 同步规则
 	SyncRule struct {
-		Database          string                           `json:"database" yaml:"database"`                                       // 需要同步的库
-		Table             string                           `json:"table" yaml:"table"`                                             // 需要同步的表
+		Database          string                           `json:"database" yaml:"database"`                                       // 需要同步的库 支持通配符和 /正则/
+		Table             string                           `json:"table" yaml:"table"`                                             // 需要同步的表 支持通配符和 /正则/
 		PrimaryKey        string                           `json:"primary_key" yaml:"primary_key"`                                 // 来源表中主键名称
-		LockColumns       []string                         `json:"lock_columns" yaml:"lock_columns"`                               // 加锁时 依赖的字段
+		LockColumns       []string                         `json:"lock_columns" yaml:"lock_columns"`                               // 加锁时 依赖的字段 必须是规则中出现过的来源字段
 		Columns           map[string]string                `json:"columns" yaml:"columns"`                                         // 字段映射表 local:target 格式
 		SystemColumns     []string                         `json:"-" yaml:"-"`                                                     // 系统字段 (特殊逻辑)
 		SoftDeleteField   string                           `json:"soft_delete_field,omitempty" yaml:"soft_delete_field,omitempty"` // 软删除字段名称 为空代表不支持软删除
 		UnSoftDeleteValue string                           `json:"un_soft_delete_value" yaml:"un_soft_delete_value"`               // 未软删除值 SoftDeleteField 不为空且作为key获取到的值不相等及被软删除
 		DataConditions    map[string][]DataFilterCondition `json:"data_conditions,omitempty" yaml:"data_conditions,omitempty"`     // 同步条件 key为 and或or  比对结果false 不同步
+		DataFilter        string                           `json:"data_filter,omitempty" yaml:"data_filter,omitempty"`             // 同步条件表达式 和 DataConditions 二选一
 		TargetType        string                           `json:"-" yaml:"target_type"`                                           // 目标类型 mysql|es
 		Target            string                           `json:"target" yaml:"target"`                                           // 目标 mysql:connect.database.table es:connect.index
-		TargetDatabase    string                           `json:"-" yaml:"target_database"`
-		TargetTable       string                           `json:"-" yaml:"target_table"`
+		TargetDatabase    string                           `json:"-" yaml:"target_database"`                                       // 支持 $1 ${name} 引用库名和表名的捕获组
+		TargetTable       string                           `json:"-" yaml:"target_table"`                                          // 支持 $1 ${name} 引用库名和表名的捕获组
 		//Type              string                     `json:"type"`                 // 同步类型 stats:统计 sync:同步
 		SyncType      string `json:"sync_type" yaml:"sync_type"`                                 // 具体同步或统计类型
 		JoinFieldName string `json:"join_field_name,omitempty" yaml:"join_field_name,omitempty"` // 加入字段名 sync_type:join|inner 时存在
 		//SyncConditions    []SyncCondition            `json:"sync_conditions"`      // 同步条件 只允许and条件
-		TargetExtraParams map[string]string `json:"target_extra_params,omitempty" yaml:"target_extra_params,omitempty"` // 目标额外参数，常量同步时一起写入目标表
+		TargetExtraParams map[string]string            `json:"target_extra_params,omitempty" yaml:"target_extra_params,omitempty"` // 目标额外参数，常量同步时一起写入目标表
+		UpsertOnInsert    bool                         `json:"upsert_on_insert,omitempty" yaml:"upsert_on_insert,omitempty"`       // 插入时主键已存在则更新 仅 mysql
+		NoRowsAffected    string                       `json:"no_rows_affected,omitempty" yaml:"no_rows_affected,omitempty"`       // 更新或删除影响行数为 0 时的策略 ignore|error|insert 默认 ignore 仅 mysql
+		VersionColumn     string                       `json:"version_column,omitempty" yaml:"version_column,omitempty"`           // 版本字段 写入事件时间，旧事件不会覆盖新数据 仅 copy
+		AutoUpdatedAt     bool                         `json:"auto_updated_at,omitempty" yaml:"auto_updated_at,omitempty"`         // 写入时自动填充 updated_at 字段 仅 copy
+		Transforms        map[string][]ColumnTransform `json:"transforms,omitempty" yaml:"transforms,omitempty"`                   // 目标字段转换管道 key 为目标字段
+
+		filter *filterGroup // 编译后的 DataConditions
+		expr   *filterExpr  // 编译后的 DataFilter
+
+		transformers map[string]*columnTransformer // 编译后的 Transforms
+		matcher      *tableMatcher                 // 编译后的 Database 和 Table 匹配规则
 	}
 
 	innerSyncRule SyncRule
 
 	// DataFilterCondition  数据规则条件
 	DataFilterCondition struct {
-		Column      string                           `json:"column,omitempty"`       // 字段名
-		Operator    string                           `json:"operator,omitempty"`     // 运算符
-		Value       string                           `json:"value,omitempty"`        // 值
-		ValueColumn string                           `json:"value_column,omitempty"` // 值字段  value 和 value_column 同时只存在其中一个
-		Children    map[string][]DataFilterCondition `json:"children,omitempty"`     // 子条件
+		Column      string                           `json:"column,omitempty" yaml:"column,omitempty"`             // 字段名
+		Operator    string                           `json:"operator,omitempty" yaml:"operator,omitempty"`         // 运算符
+		Type        string                           `json:"type,omitempty" yaml:"type,omitempty"`                 // 比较时的值类型 int|decimal|time|string 默认 string
+		Value       string                           `json:"value,omitempty" yaml:"value,omitempty"`               // 值
+		Values      []string                         `json:"values,omitempty" yaml:"values,omitempty"`             // 多个值 in|not in|between 使用
+		ValueColumn string                           `json:"value_column,omitempty" yaml:"value_column,omitempty"` // 值字段  value 和 value_column 同时只存在其中一个
+		Children    map[string][]DataFilterCondition `json:"children,omitempty" yaml:"children,omitempty"`         // 子条件
 	}
 )
 
@@ -65,58 +78,55 @@ func (sr *SyncRule) UnmarshalJSON(bytes []byte) error {
 	return nil
 }
 
-// EvaluateFilterConditions 判断是否符合同步条件
-func (sr *SyncRule) EvaluateFilterConditions(data map[string]string) bool {
-	if sr.DataConditions != nil {
-		return sr.evaluateFilterConditions(sr.DataConditions, data)
+// getTransformers 获取编译后的字段转换管道，未经过 Validate 的规则每次调用时编译
+func (sr *SyncRule) getTransformers() map[string]*columnTransformer {
+	if sr.transformers == nil && sr.Transforms != nil {
+		return compileColumnTransforms(sr, func(string, string, ...interface{}) {})
 	}
 
-	return true
+	return sr.transformers
 }
 
-// evaluateFilterConditions 判断是否符合同步条件
-func (sr *SyncRule) evaluateFilterConditions(conditions map[string][]DataFilterCondition, data map[string]string) bool {
-	var ok bool
-
-	for operator, ruleConditions := range conditions {
-		ok = ok || operator == ConditionTypeAnd
-		switch operator {
-		case ConditionTypeAnd:
-			for _, condition := range ruleConditions {
-				if value, columnExists := data[condition.Column]; columnExists {
-					if ok = ok && tools.JudgmentEval(value, condition.Value,
-						condition.Operator); !ok {
-						return false
-					}
-				} else if condition.Column != "" {
-					return false
-				}
-
-				if len(condition.Children) > 0 {
-					if ok = ok && sr.evaluateFilterConditions(condition.Children,
-						data); !ok {
-						return false
-					}
-				}
-			}
-		case ConditionTypeOr:
-			for _, condition := range ruleConditions {
-				if value, columnExists := data[condition.Column]; columnExists {
-					if ok = ok || tools.JudgmentEval(value, condition.Value,
-						condition.Operator); ok {
-						return true
-					}
-				}
-
-				if len(condition.Children) > 0 {
-					if ok = ok || sr.evaluateFilterConditions(condition.Children,
-						data); !ok {
-						return true
-					}
-				}
+// GetFullTarget 获取完整的目标，格式和 json 中的 target 一致 type:connect(.db).table
+func (sr *SyncRule) GetFullTarget() string {
+	targets := []string{sr.Target, sr.TargetTable}
+	if sr.TargetDatabase != "" {
+		targets = []string{sr.Target, sr.TargetDatabase, sr.TargetTable}
+	}
+
+	return sr.TargetType + ":" + strings.Join(targets, ".")
+}
+
+// GetRuleKey 获取规则key 格式 database_table
+func (sr *SyncRule) GetRuleKey() string {
+	return sr.Database + "_" + sr.Table
+}
+
+// EvaluateFilterConditions 判断是否符合同步条件，old 为更新前被修改字段的值，只在 DataFilter 中使用
+// nulls 和 oldNulls 为 data 和 old 中值为 null 的字段，null 和空字符串不同
+// 同步条件在 Validate 时编译，未经过 Validate 的规则每次调用时编译，条件不合法时不同步
+func (sr *SyncRule) EvaluateFilterConditions(data, old map[string]string, nulls, oldNulls map[string]bool) bool {
+	if expr := sr.expr; expr != nil || sr.DataFilter != "" {
+		if expr == nil {
+			var err error
+			if expr, err = compileFilterExpr(sr.DataFilter); err != nil {
+				return false
 			}
 		}
+		return expr.evaluate(data, old, nulls, oldNulls)
 	}
 
-	return ok
+	filter := sr.filter
+	if filter == nil && sr.DataConditions != nil {
+		var invalid bool
+		if filter = compileFilterConditions("data_conditions", sr.DataConditions,
+			func(string, string, ...interface{}) { invalid = true }); invalid {
+			return false
+		}
+	}
+	if filter != nil {
+		return filter.evaluate(data, nulls)
+	}
+
+	return true
 }
@@ -0,0 +1,462 @@
+package types
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// data_filter 表达式语法:
+//   expr      = and { ("||" | "or") and }
+//   and       = unary { ("&&" | "and") unary }
+//   unary     = ("!" | "not") unary | "(" expr ")" | "true" | "false" | predicate
+//   predicate = operand [ compare operand | ["not"] "in" "(" operand { "," operand } ")"
+//               | ["not"] ("like" | "regex") string | "is" ["not"] "null"
+//               | ["not"] "between" operand "and" operand ]
+//   operand   = column | "old." column | string | number
+// compare 支持 == = != <> > >= < <=，两边都是数字时按数字比较，否则按字符串比较
+// old.column 为更新前的值，字段没有被更新时和 column 相同
+
+type (
+	// FilterSyntaxError data_filter 表达式语法错误，Pos 为错误位置，从 1 开始
+	FilterSyntaxError struct {
+		Pos     int
+		Message string
+	}
+
+	// filterExpr 编译后的 data_filter 表达式
+	filterExpr struct {
+		root    exprNode
+		columns map[string]bool // 表达式引用的字段，old.column 记为 column
+	}
+
+	// exprRow 表达式求值的数据，nulls 和 oldNulls 为值为 null 的字段
+	exprRow struct {
+		data, old       map[string]string
+		nulls, oldNulls map[string]bool
+	}
+
+	// exprNode 表达式的布尔节点
+	exprNode interface {
+		eval(row *exprRow) bool
+	}
+
+	// exprOperand 表达式的值节点，第二个返回值为 false 代表 null
+	exprOperand interface {
+		value(row *exprRow) (string, bool)
+	}
+
+	exprLogical struct {
+		and         bool
+		left, right exprNode
+	}
+	exprNot     struct{ node exprNode }
+	exprLiteral struct{ result bool }
+	exprCompare struct {
+		operator    string
+		left, right exprOperand
+	}
+	exprIn struct {
+		not     bool
+		operand exprOperand
+		list    []exprOperand
+	}
+	exprMatch struct {
+		not     bool
+		operand exprOperand
+		pattern *regexp.Regexp
+	}
+	exprIsNull struct {
+		not     bool
+		operand exprOperand
+	}
+	exprBetween struct {
+		not             bool
+		operand, lo, hi exprOperand
+	}
+	exprTruth struct{ operand exprOperand }
+
+	exprColumn struct {
+		column string
+		old    bool
+	}
+	exprConst struct{ text string }
+
+	exprToken struct {
+		kind string // ident|string|number|op|eof
+		text string
+		pos  int
+	}
+
+	exprParser struct {
+		tokens  []exprToken
+		current int
+		columns map[string]bool
+	}
+)
+
+const exprOldPrefix = "old."
+
+func (e *FilterSyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Message)
+}
+
+// compileFilterExpr 编译 data_filter 表达式
+func compileFilterExpr(filter string) (expr *filterExpr, err error) {
+	tokens, err := tokenizeFilterExpr(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		// 语法错误在解析过程中通过 panic 返回，简化递归下降的错误传递
+		if r := recover(); r != nil {
+			syntaxErr, ok := r.(*FilterSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			expr, err = nil, syntaxErr
+		}
+	}()
+
+	parser := &exprParser{tokens: tokens, columns: make(map[string]bool)}
+	root := parser.parseOr()
+	if token := parser.peek(); token.kind != "eof" {
+		parser.fail(token, "unexpected %q", token.text)
+	}
+
+	return &filterExpr{root: root, columns: parser.columns}, nil
+}
+
+// evaluate 判断数据是否符合表达式
+func (e *filterExpr) evaluate(data, old map[string]string, nulls, oldNulls map[string]bool) bool {
+	return e.root.eval(&exprRow{data: data, old: old, nulls: nulls, oldNulls: oldNulls})
+}
+
+// tokenizeFilterExpr 把表达式拆分为 token
+func tokenizeFilterExpr(filter string) ([]exprToken, error) {
+	var tokens []exprToken
+	runes := []rune(filter)
+	for i := 0; i < len(runes); {
+		r, pos := runes[i], i+1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			var builder strings.Builder
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					builder.WriteRune(runes[i])
+				} else if runes[i] == r {
+					closed = true
+					i++
+					break
+				} else {
+					builder.WriteRune(runes[i])
+				}
+			}
+			if !closed {
+				return nil, &FilterSyntaxError{Pos: pos, Message: "unterminated string"}
+			}
+			tokens = append(tokens, exprToken{kind: "string", text: builder.String(), pos: pos})
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, exprToken{kind: "number", text: string(runes[start:i]), pos: pos})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i++; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '_' || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, exprToken{kind: "ident", text: string(runes[start:i]), pos: pos})
+		default:
+			operator := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "&&", "||", "==", "!=", "<>", ">=", "<=":
+					operator = two
+				}
+			}
+			switch operator {
+			case "&&", "||", "==", "!=", "<>", ">=", "<=", "=", ">", "<", "!", "(", ")", ",":
+			default:
+				return nil, &FilterSyntaxError{Pos: pos, Message: fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, exprToken{kind: "op", text: operator, pos: pos})
+			i += len([]rune(operator))
+		}
+	}
+
+	return append(tokens, exprToken{kind: "eof", pos: len(runes) + 1}), nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.current]
+}
+
+func (p *exprParser) next() exprToken {
+	token := p.tokens[p.current]
+	if token.kind != "eof" {
+		p.current++
+	}
+
+	return token
+}
+
+// isKeyword 判断 token 是否为关键字，关键字不区分大小写
+func (t exprToken) isKeyword(keyword string) bool {
+	return t.kind == "ident" && strings.EqualFold(t.text, keyword)
+}
+
+// accept 当前 token 为指定的运算符或关键字时跳过并返回 true
+func (p *exprParser) accept(texts ...string) bool {
+	token := p.peek()
+	for _, text := range texts {
+		if (token.kind == "op" && token.text == text) || token.isKeyword(text) {
+			p.current++
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *exprParser) expect(text string) {
+	if !p.accept(text) {
+		token := p.peek()
+		p.fail(token, "expected %q, got %q", text, token.text)
+	}
+}
+
+func (p *exprParser) fail(token exprToken, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if token.kind == "eof" {
+		message = strings.ReplaceAll(message, `""`, "end of expression")
+	}
+	panic(&FilterSyntaxError{Pos: token.pos, Message: message})
+}
+
+func (p *exprParser) parseOr() exprNode {
+	node := p.parseAnd()
+	for p.accept("||", "or") {
+		node = &exprLogical{left: node, right: p.parseAnd()}
+	}
+
+	return node
+}
+
+func (p *exprParser) parseAnd() exprNode {
+	node := p.parseUnary()
+	for p.accept("&&", "and") {
+		node = &exprLogical{and: true, left: node, right: p.parseUnary()}
+	}
+
+	return node
+}
+
+func (p *exprParser) parseUnary() exprNode {
+	switch {
+	case p.accept("!", "not"):
+		return &exprNot{node: p.parseUnary()}
+	case p.accept("("):
+		node := p.parseOr()
+		p.expect(")")
+		return node
+	case p.accept("true"):
+		return &exprLiteral{result: true}
+	case p.accept("false"):
+		return &exprLiteral{result: false}
+	}
+
+	return p.parsePredicate()
+}
+
+func (p *exprParser) parsePredicate() exprNode {
+	operand := p.parseOperand()
+	token := p.peek()
+	if token.kind == "op" {
+		switch token.text {
+		case "==", "=", "!=", "<>", ">", ">=", "<", "<=":
+			p.next()
+			operator := token.text
+			if operator == "==" {
+				operator = "="
+			} else if operator == "<>" {
+				operator = "!="
+			}
+			return &exprCompare{operator: operator, left: operand, right: p.parseOperand()}
+		}
+	}
+
+	if p.accept("is") {
+		not := p.accept("not")
+		p.expect("null")
+		return &exprIsNull{not: not, operand: operand}
+	}
+
+	not := p.accept("not")
+	switch token = p.peek(); {
+	case p.accept("in"):
+		p.expect("(")
+		node := &exprIn{not: not, operand: operand, list: []exprOperand{p.parseOperand()}}
+		for p.accept(",") {
+			node.list = append(node.list, p.parseOperand())
+		}
+		p.expect(")")
+		return node
+	case p.accept("like"), p.accept("regex"):
+		patternToken := p.next()
+		if patternToken.kind != "string" {
+			p.fail(patternToken, "%s pattern must be a string, got %q", token.text, patternToken.text)
+		}
+		expr := patternToken.text
+		if token.isKeyword("like") {
+			expr = likeToRegexp(expr)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			p.fail(patternToken, "invalid %s pattern: %s", token.text, err)
+		}
+		return &exprMatch{not: not, operand: operand, pattern: pattern}
+	case p.accept("between"):
+		node := &exprBetween{not: not, operand: operand, lo: p.parseOperand()}
+		p.expect("and")
+		node.hi = p.parseOperand()
+		return node
+	}
+	if not {
+		p.fail(token, "expected in, like, regex or between after not, got %q", token.text)
+	}
+
+	return &exprTruth{operand: operand}
+}
+
+func (p *exprParser) parseOperand() exprOperand {
+	token := p.next()
+	switch token.kind {
+	case "string", "number":
+		return &exprConst{text: token.text}
+	case "ident":
+		for _, keyword := range []string{"and", "or", "not", "in", "like", "regex", "is", "null", "between",
+			"true", "false"} {
+			if token.isKeyword(keyword) {
+				p.fail(token, "unexpected keyword %q", token.text)
+			}
+		}
+		column := &exprColumn{column: token.text}
+		if strings.HasPrefix(token.text, exprOldPrefix) {
+			column.column, column.old = strings.TrimPrefix(token.text, exprOldPrefix), true
+		}
+		p.columns[column.column] = true
+		return column
+	}
+
+	p.fail(token, "expected column or value, got %q", token.text)
+	return nil
+}
+
+func (n *exprLogical) eval(row *exprRow) bool {
+	if n.and {
+		return n.left.eval(row) && n.right.eval(row)
+	}
+
+	return n.left.eval(row) || n.right.eval(row)
+}
+
+func (n *exprNot) eval(row *exprRow) bool {
+	return !n.node.eval(row)
+}
+
+func (n *exprLiteral) eval(*exprRow) bool {
+	return n.result
+}
+
+// eval 和 null 比较结果都不成立
+func (n *exprCompare) eval(row *exprRow) bool {
+	left, ok := n.left.value(row)
+	if !ok {
+		return false
+	}
+	right, ok := n.right.value(row)
+	if !ok {
+		return false
+	}
+
+	return compareResult(n.operator, compareExprValues(left, right))
+}
+
+func (n *exprIn) eval(row *exprRow) bool {
+	value, ok := n.operand.value(row)
+	if !ok {
+		return false
+	}
+	for _, item := range n.list {
+		if itemValue, ok := item.value(row); ok && compareExprValues(value, itemValue) == 0 {
+			return !n.not
+		}
+	}
+
+	return n.not
+}
+
+func (n *exprMatch) eval(row *exprRow) bool {
+	value, ok := n.operand.value(row)
+
+	return ok && n.pattern.MatchString(value) != n.not
+}
+
+func (n *exprIsNull) eval(row *exprRow) bool {
+	_, ok := n.operand.value(row)
+
+	return !ok != n.not
+}
+
+func (n *exprBetween) eval(row *exprRow) bool {
+	value, ok := n.operand.value(row)
+	lo, loOk := n.lo.value(row)
+	hi, hiOk := n.hi.value(row)
+	if !ok || !loOk || !hiOk {
+		return false
+	}
+
+	return (compareExprValues(value, lo) >= 0 && compareExprValues(value, hi) <= 0) != n.not
+}
+
+// eval 单独的字段作为条件时，非空并且不是 0 或 false 成立
+func (n *exprTruth) eval(row *exprRow) bool {
+	value, ok := n.operand.value(row)
+
+	return ok && value != "" && value != "0" && !strings.EqualFold(value, "false")
+}
+
+// value old 字段没有被更新时使用当前值，字段不存在或值为 null 时返回 false
+func (n *exprColumn) value(row *exprRow) (string, bool) {
+	if n.old {
+		if value, ok := row.old[n.column]; ok {
+			return value, !row.oldNulls[n.column]
+		}
+	}
+	value, ok := row.data[n.column]
+
+	return value, ok && !row.nulls[n.column]
+}
+
+func (n *exprConst) value(*exprRow) (string, bool) {
+	return n.text, true
+}
+
+// compareExprValues 两个值都是数字时按数字比较，否则按字符串比较
+func compareExprValues(a, b string) int {
+	if x, ok := new(big.Rat).SetString(a); ok {
+		if y, ok := new(big.Rat).SetString(b); ok {
+			return x.Cmp(y)
+		}
+	}
+
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,337 @@
+package types
+
+import (
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// filterGroup 编译后的同步条件组，and 条件全部成立 或 or 条件任意一个成立
+	filterGroup struct {
+		and []*filterCondition
+		or  []*filterCondition
+	}
+
+	// filterCondition 编译后的单个同步条件，字段条件和子条件同时存在时都需要成立
+	filterCondition struct {
+		column      string
+		operator    string
+		valueColumn string          // 和另一个字段比较，比较值在执行时解析
+		valueType   filterValueType // 比较时使用的值类型
+		values      []interface{}   // 编译时解析好的比较值
+		pattern     *regexp.Regexp  // like 和 regex 的正则
+		children    *filterGroup
+	}
+
+	// filterValueType 同步条件的值类型
+	filterValueType interface {
+		parse(value string) (interface{}, error)
+		compare(a, b interface{}) int
+	}
+
+	intFilterValueType     struct{}
+	decimalFilterValueType struct{}
+	timeFilterValueType    struct{}
+	stringFilterValueType  struct{}
+)
+
+var (
+	filterValueTypes = map[string]filterValueType{
+		"":                        stringFilterValueType{},
+		ConditionValueTypeString:  stringFilterValueType{},
+		ConditionValueTypeInt:     intFilterValueType{},
+		ConditionValueTypeDecimal: decimalFilterValueType{},
+		ConditionValueTypeTime:    timeFilterValueType{},
+	}
+
+	// filterTimeLayouts time 类型支持的时间格式
+	filterTimeLayouts = []string{TimestampLayout, time.RFC3339Nano, "2006-01-02"}
+)
+
+// compileFilterConditions 编译同步条件，不合法的条件通过 addErr 返回，并且不会被编译
+func compileFilterConditions(field string, conditions map[string][]DataFilterCondition,
+	addErr func(field, format string, args ...interface{})) *filterGroup {
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	group := &filterGroup{}
+	for conditionType, ruleConditions := range conditions {
+		if conditionType != ConditionTypeAnd && conditionType != ConditionTypeOr {
+			addErr(field, "unknown condition type %q", conditionType)
+			continue
+		}
+
+		for i, condition := range ruleConditions {
+			conditionField := field + "." + conditionType + "[" + strconv.Itoa(i) + "]"
+			compiled := compileFilterCondition(conditionField, condition, addErr)
+			if conditionType == ConditionTypeAnd {
+				group.and = append(group.and, compiled)
+			} else {
+				group.or = append(group.or, compiled)
+			}
+		}
+	}
+
+	return group
+}
+
+// compileFilterCondition 编译单个同步条件
+func compileFilterCondition(field string, condition DataFilterCondition,
+	addErr func(field, format string, args ...interface{})) *filterCondition {
+	compiled := &filterCondition{
+		column: condition.Column, operator: condition.Operator, valueColumn: condition.ValueColumn,
+		children: compileFilterConditions(field+".children", condition.Children, addErr),
+	}
+	if condition.Column == "" {
+		if compiled.children == nil {
+			addErr(field, "column or children is required")
+		}
+		return compiled
+	}
+
+	valueType, ok := filterValueTypes[condition.Type]
+	if !ok {
+		addErr(field+".type", "unknown value type %q", condition.Type)
+		return compiled
+	}
+	compiled.valueType = valueType
+	if condition.ValueColumn != "" && (condition.Value != "" || len(condition.Values) > 0) {
+		addErr(field+".value_column", "value and value_column can not be set at the same time")
+	}
+
+	switch condition.Operator {
+	case ">", "<", "=", "!=", ">=", "<=":
+	default:
+		if condition.ValueColumn != "" {
+			addErr(field+".value_column", "operator %s not support value_column", condition.Operator)
+		}
+	}
+
+	var values []string
+	switch condition.Operator {
+	case ConditionOperatorIsNull, ConditionOperatorIsNotNull:
+		return compiled
+	case ConditionOperatorLike, ConditionOperatorRegex:
+		expr := condition.Value
+		if condition.Operator == ConditionOperatorLike {
+			expr = likeToRegexp(condition.Value)
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			addErr(field+".value", "invalid %s pattern: %s", condition.Operator, err)
+		}
+		compiled.pattern = pattern
+		return compiled
+	case ConditionOperatorIn, ConditionOperatorNotIn:
+		if values = condition.Values; len(values) == 0 {
+			addErr(field+".values", "is required when operator is %s", condition.Operator)
+		}
+	case ConditionOperatorBetween:
+		if values = condition.Values; len(values) != 2 {
+			addErr(field+".values", "must contain 2 values when operator is %s", condition.Operator)
+		}
+	case ">", "<", "=", "!=", ">=", "<=":
+		if condition.ValueColumn != "" {
+			return compiled
+		}
+		values = []string{condition.Value}
+	default:
+		addErr(field+".operator", "unknown operator %q", condition.Operator)
+		return compiled
+	}
+
+	for _, value := range values {
+		parsed, err := valueType.parse(value)
+		if err != nil {
+			addErr(field+".value", "%q is not a valid %s: %s", value, condition.Type, err)
+		}
+		compiled.values = append(compiled.values, parsed)
+	}
+
+	return compiled
+}
+
+// evaluate 判断数据是否符合条件组
+func (g *filterGroup) evaluate(data map[string]string, nulls map[string]bool) bool {
+	if len(g.and) > 0 {
+		ok := true
+		for _, condition := range g.and {
+			if ok = condition.evaluate(data, nulls); !ok {
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+
+	for _, condition := range g.or {
+		if condition.evaluate(data, nulls) {
+			return true
+		}
+	}
+
+	return len(g.and) == 0 && len(g.or) == 0
+}
+
+// evaluate 判断数据是否符合单个条件
+func (c *filterCondition) evaluate(data map[string]string, nulls map[string]bool) bool {
+	if c.column != "" && !c.match(data, nulls) {
+		return false
+	}
+	if c.children != nil {
+		return c.children.evaluate(data, nulls)
+	}
+
+	return true
+}
+
+// match 比较字段的值，字段不存在、值为 null 或值不能解析为条件类型时不成立
+func (c *filterCondition) match(data map[string]string, nulls map[string]bool) bool {
+	value, exists := data[c.column]
+	exists = exists && !nulls[c.column]
+	switch c.operator {
+	case ConditionOperatorIsNull:
+		return !exists
+	case ConditionOperatorIsNotNull:
+		return exists
+	}
+	if !exists {
+		return false
+	} else if c.pattern != nil {
+		return c.pattern.MatchString(value)
+	}
+
+	left, err := c.valueType.parse(value)
+	if err != nil {
+		return false
+	}
+
+	switch c.operator {
+	case ConditionOperatorIn, ConditionOperatorNotIn:
+		for _, right := range c.values {
+			if c.valueType.compare(left, right) == 0 {
+				return c.operator == ConditionOperatorIn
+			}
+		}
+		return c.operator == ConditionOperatorNotIn
+	case ConditionOperatorBetween:
+		return c.valueType.compare(left, c.values[0]) >= 0 && c.valueType.compare(left, c.values[1]) <= 0
+	}
+
+	var right interface{}
+	if c.valueColumn != "" {
+		rightValue, ok := data[c.valueColumn]
+		if !ok || nulls[c.valueColumn] {
+			return false
+		}
+		if right, err = c.valueType.parse(rightValue); err != nil {
+			return false
+		}
+	} else {
+		right = c.values[0]
+	}
+
+	return compareResult(c.operator, c.valueType.compare(left, right))
+}
+
+// compareResult 根据比较运算符判断比较结果
+func compareResult(operator string, result int) bool {
+	switch operator {
+	case ">":
+		return result > 0
+	case "<":
+		return result < 0
+	case "=":
+		return result == 0
+	case "!=":
+		return result != 0
+	case ">=":
+		return result >= 0
+	case "<=":
+		return result <= 0
+	}
+
+	return false
+}
+
+// likeToRegexp 把 sql like 表达式转换为正则，% 匹配任意字符串 _ 匹配单个字符
+func likeToRegexp(like string) string {
+	var builder strings.Builder
+	builder.WriteString("(?s)^")
+	for _, r := range like {
+		switch r {
+		case '%':
+			builder.WriteString(".*")
+		case '_':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+
+	return builder.String()
+}
+
+func (intFilterValueType) parse(value string) (interface{}, error) {
+	return strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+}
+
+func (intFilterValueType) compare(a, b interface{}) int {
+	if x, y := a.(int64), b.(int64); x < y {
+		return -1
+	} else if x > y {
+		return 1
+	}
+
+	return 0
+}
+
+// parse decimal 使用有理数精确比较，避免浮点数误差
+func (decimalFilterValueType) parse(value string) (interface{}, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok {
+		return nil, strconv.ErrSyntax
+	}
+
+	return rat, nil
+}
+
+func (decimalFilterValueType) compare(a, b interface{}) int {
+	return a.(*big.Rat).Cmp(b.(*big.Rat))
+}
+
+func (timeFilterValueType) parse(value string) (interface{}, error) {
+	var err error
+	for _, layout := range filterTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (timeFilterValueType) compare(a, b interface{}) int {
+	if x, y := a.(time.Time), b.(time.Time); x.Before(y) {
+		return -1
+	} else if x.After(y) {
+		return 1
+	}
+
+	return 0
+}
+
+func (stringFilterValueType) parse(value string) (interface{}, error) {
+	return value, nil
+}
+
+func (stringFilterValueType) compare(a, b interface{}) int {
+	return strings.Compare(a.(string), b.(string))
+}
@@ -0,0 +1,259 @@
+package types
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// tableMatcher 编译后的库名和表名匹配规则，为空的一方使用精确匹配
+	tableMatcher struct {
+		database *regexp.Regexp
+		table    *regexp.Regexp
+	}
+
+	// RuleIndex 同步规则索引，规则变更时创建
+	// 精确规则通过规则key 直接查找，通配和正则规则逐个匹配，匹配结果按来源表缓存
+	RuleIndex struct {
+		exact    map[string][]*SyncRule
+		patterns []*SyncRule
+		cache    map[string][]*SyncRule
+		mux      sync.RWMutex
+	}
+)
+
+// rulePlaceholderRegexp 目标中引用捕获组的占位符 $1 ${1} ${name}
+var rulePlaceholderRegexp = regexp.MustCompile(`\$(?:\{(\w+)\}|(\d+))`)
+
+// NewRuleIndex 创建同步规则索引，规则需要先经过 Validate 编译
+func NewRuleIndex(rules map[string][]*SyncRule) *RuleIndex {
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	// 匹配规则按规则key 排序，保证多条规则匹配同一张表时的执行顺序稳定
+	sort.Strings(keys)
+
+	index := &RuleIndex{exact: make(map[string][]*SyncRule, len(rules)), cache: make(map[string][]*SyncRule)}
+	for _, key := range keys {
+		for _, rule := range rules[key] {
+			if rule.IsPattern() {
+				index.patterns = append(index.patterns, rule)
+			} else {
+				index.exact[key] = append(index.exact[key], rule)
+			}
+		}
+	}
+
+	return index
+}
+
+// Match 获取来源表的全部同步规则，通配和正则规则返回替换占位符后的规则
+func (idx *RuleIndex) Match(database, table string) []*SyncRule {
+	key := database + "_" + table
+	if len(idx.patterns) == 0 {
+		return idx.exact[key]
+	}
+
+	idx.mux.RLock()
+	rules, ok := idx.cache[key]
+	idx.mux.RUnlock()
+	if ok {
+		return rules
+	}
+
+	rules = append([]*SyncRule(nil), idx.exact[key]...)
+	for _, pattern := range idx.patterns {
+		if rule, ok := pattern.Match(database, table); ok {
+			rules = append(rules, rule)
+		}
+	}
+	// 来源表数量有限，不匹配的表同样缓存
+	idx.mux.Lock()
+	idx.cache[key] = rules
+	idx.mux.Unlock()
+
+	return rules
+}
+
+// IsPattern 判断库名或表名是否为通配或正则规则
+func (sr *SyncRule) IsPattern() bool {
+	return isRulePattern(sr.Database) || isRulePattern(sr.Table)
+}
+
+// Match 判断规则是否匹配来源表，通配和正则规则返回替换了目标占位符的新规则
+// 未经过 Validate 的规则每次调用时编译，匹配规则不合法时不匹配
+func (sr *SyncRule) Match(database, table string) (*SyncRule, bool) {
+	if !sr.IsPattern() {
+		return sr, sr.Database == database && sr.Table == table
+	}
+
+	matcher := sr.matcher
+	if matcher == nil {
+		var invalid bool
+		if matcher = compileTableMatcher(sr, func(string, string, ...interface{}) { invalid = true }); invalid {
+			return nil, false
+		}
+	}
+	captures, named, ok := matcher.match(database, table)
+	if !ok {
+		return nil, false
+	}
+
+	rule := *sr
+	rule.TargetDatabase = expandRulePlaceholders(sr.TargetDatabase, captures, named)
+	rule.TargetTable = expandRulePlaceholders(sr.TargetTable, captures, named)
+	if sr.TargetExtraParams != nil {
+		rule.TargetExtraParams = make(map[string]string, len(sr.TargetExtraParams))
+		for column, value := range sr.TargetExtraParams {
+			rule.TargetExtraParams[column] = expandRulePlaceholders(value, captures, named)
+		}
+	}
+
+	return &rule, true
+}
+
+// compileTableMatcher 编译库名和表名的匹配规则，并校验目标中的占位符
+// 不合法的规则通过 addErr 返回，都是精确匹配时返回 nil
+func compileTableMatcher(sr *SyncRule, addErr func(field, format string, args ...interface{})) *tableMatcher {
+	if !sr.IsPattern() {
+		return nil
+	}
+
+	database, err := compileRulePattern(sr.Database)
+	if err != nil {
+		addErr("database", "invalid pattern: %s", err)
+	}
+	table, err := compileRulePattern(sr.Table)
+	if err != nil {
+		addErr("table", "invalid pattern: %s", err)
+	}
+	matcher := &tableMatcher{database: database, table: table}
+
+	groups, names := 0, make(map[string]bool)
+	for _, re := range []*regexp.Regexp{database, table} {
+		if re != nil {
+			groups += re.NumSubexp()
+			for _, name := range re.SubexpNames() {
+				names[name] = true
+			}
+		}
+	}
+	checkPlaceholders := func(field, value string) {
+		for _, placeholder := range rulePlaceholderRegexp.FindAllStringSubmatch(value, -1) {
+			name := placeholder[1] + placeholder[2]
+			if n, err := strconv.Atoi(name); (err == nil && n > 0 && n <= groups) || (err != nil && names[name]) {
+				continue
+			}
+			addErr(field, "placeholder %s is not captured by database or table pattern", placeholder[0])
+		}
+	}
+	checkPlaceholders("target_database", sr.TargetDatabase)
+	checkPlaceholders("target_table", sr.TargetTable)
+	for column, value := range sr.TargetExtraParams {
+		checkPlaceholders("target_extra_params."+column, value)
+	}
+
+	return matcher
+}
+
+// match 匹配库名和表名，返回按顺序排列的捕获组 (库名在前) 和命名捕获组
+func (m *tableMatcher) match(database, table string) ([]string, map[string]string, bool) {
+	var captures []string
+	named := make(map[string]string)
+	for _, part := range []struct {
+		re   *regexp.Regexp
+		name string
+	}{{m.database, database}, {m.table, table}} {
+		if part.re == nil {
+			continue
+		}
+		submatches := part.re.FindStringSubmatch(part.name)
+		if submatches == nil {
+			return nil, nil, false
+		}
+		for i, name := range part.re.SubexpNames() {
+			if i > 0 && name != "" {
+				named[name] = submatches[i]
+			}
+		}
+		captures = append(captures, submatches[1:]...)
+	}
+
+	return captures, named, true
+}
+
+// isRulePattern / 包裹的为正则，包含 * ? [ 的为通配符
+func isRulePattern(name string) bool {
+	return isRuleRegexp(name) || strings.ContainsAny(name, "*?[")
+}
+
+func isRuleRegexp(name string) bool {
+	return len(name) > 2 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/")
+}
+
+// compileRulePattern 把库名或表名编译为完整匹配的正则，精确名称返回 nil
+// 通配符 * 匹配任意字符并作为捕获组，? 匹配单个字符，[0-9] 匹配字符集合，[!0-9] 为排除
+func compileRulePattern(name string) (*regexp.Regexp, error) {
+	if isRuleRegexp(name) {
+		return regexp.Compile("^(?:" + name[1:len(name)-1] + ")$")
+	} else if !isRulePattern(name) {
+		return nil, nil
+	}
+
+	return regexp.Compile("^" + globToRegexp(name) + "$")
+}
+
+// globToRegexp 把通配符转换为正则，没有闭合的 [ 作为普通字符
+func globToRegexp(glob string) string {
+	var builder strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			builder.WriteString("(.*)")
+		case '?':
+			builder.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end <= 0 {
+				builder.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			builder.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return builder.String()
+}
+
+// expandRulePlaceholders 替换目标中的占位符，$1 为第一个捕获组，${name} 为命名捕获组
+func expandRulePlaceholders(template string, captures []string, named map[string]string) string {
+	if !strings.Contains(template, "$") {
+		return template
+	}
+
+	return rulePlaceholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		submatches := rulePlaceholderRegexp.FindStringSubmatch(placeholder)
+		name := submatches[1] + submatches[2]
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > 0 && n <= len(captures) {
+				return captures[n-1]
+			}
+			return placeholder
+		} else if value, ok := named[name]; ok {
+			return value
+		}
+
+		return placeholder
+	})
+}
@@ -72,14 +72,259 @@ func TestSyncRule_evaluateFilterConditions(t *testing.T) {
 		},
 	}
 
-	rule := &SyncRule{}
+	rule := &SyncRule{DataConditions: conditions}
 	data := map[string]string{
 		"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6",
 		"g": "7", "h": "8", "i": "9",
 	}
 
-	ok := rule.evaluateFilterConditions(conditions, data)
+	ok := rule.EvaluateFilterConditions(data, nil, nil, nil)
 	if !ok {
 		t.Fatal("filter conditions failed")
 	}
 }
+
+func TestSyncRule_typedFilterConditions(t *testing.T) {
+	data := map[string]string{
+		"price": "900", "discount": "1000.50", "status": "paid", "order_sn": "xlz2024030816051904940892",
+		"paid_at": "2024-03-08 16:05:19", "remark": "", "memo": "",
+	}
+	nulls := map[string]bool{"remark": true}
+	cases := []struct {
+		condition DataFilterCondition
+		expect    bool
+	}{
+		// 字符串比较 "900" > "5000"，整数比较不成立
+		{DataFilterCondition{Column: "price", Operator: ">", Value: "5000"}, true},
+		{DataFilterCondition{Column: "price", Operator: ">", Value: "5000", Type: ConditionValueTypeInt}, false},
+		{DataFilterCondition{Column: "discount", Operator: "=", Value: "1000.5", Type: ConditionValueTypeDecimal}, true},
+		{DataFilterCondition{Column: "price", Operator: "<", ValueColumn: "discount", Type: ConditionValueTypeDecimal}, true},
+		{DataFilterCondition{Column: "paid_at", Operator: ConditionOperatorBetween, Type: ConditionValueTypeTime,
+			Values: []string{"2024-03-08", "2024-03-09"}}, true},
+		{DataFilterCondition{Column: "status", Operator: ConditionOperatorIn, Values: []string{"paid", "shipped"}}, true},
+		{DataFilterCondition{Column: "price", Operator: ConditionOperatorNotIn, Type: ConditionValueTypeInt,
+			Values: []string{"0900"}}, false},
+		{DataFilterCondition{Column: "order_sn", Operator: ConditionOperatorLike, Value: "xlz2024%"}, true},
+		{DataFilterCondition{Column: "order_sn", Operator: ConditionOperatorRegex, Value: `^xlz\d{22}$`}, true},
+		{DataFilterCondition{Column: "remark", Operator: ConditionOperatorIsNull}, true},
+		{DataFilterCondition{Column: "status", Operator: ConditionOperatorIsNotNull}, true},
+		// 空字符串不是 null
+		{DataFilterCondition{Column: "memo", Operator: ConditionOperatorIsNotNull}, true},
+		{DataFilterCondition{Column: "remark", Operator: "=", Value: ""}, false},
+		// 值不能解析为条件类型时不成立
+		{DataFilterCondition{Column: "status", Operator: "!=", Value: "0", Type: ConditionValueTypeInt}, false},
+	}
+
+	for _, c := range cases {
+		rule := &SyncRule{DataConditions: map[string][]DataFilterCondition{ConditionTypeAnd: {c.condition}}}
+		if ok := rule.EvaluateFilterConditions(data, nil, nulls, nil); ok != c.expect {
+			t.Fatalf("condition %+v should be %v", c.condition, c.expect)
+		}
+	}
+}
+
+func TestSyncRule_filterExpr(t *testing.T) {
+	// 和 TestSyncRule_evaluateFilterConditions 的条件相同
+	rule := &SyncRule{DataFilter: "(a = 1 && (b = 2 && (c = 3 || d != 4)) && (e = 5 || f != 6)) || " +
+		"(g = 7 && (h = 8 || i != 9))"}
+	data := map[string]string{
+		"a": "1", "b": "2", "c": "3", "d": "4", "e": "5", "f": "6",
+		"g": "7", "h": "8", "i": "9",
+	}
+	if !rule.EvaluateFilterConditions(data, nil, nil, nil) {
+		t.Fatal("filter expression failed")
+	}
+
+	rule.DataFilter = "price >= 5000 && status in ('paid', 'shipped') && old.status != status && " +
+		"order_sn like 'xlz%' and remark is null and not (price between 1 and 100)"
+	data = map[string]string{"price": "900", "status": "paid", "order_sn": "xlz2024", "remark": ""}
+	old, nulls := map[string]string{"status": "created"}, map[string]bool{"remark": true}
+	// 按数字比较 900 < 5000
+	if rule.EvaluateFilterConditions(data, old, nulls, nil) {
+		t.Fatal("price 900 should not pass")
+	}
+	data["price"] = "5000.00"
+	if !rule.EvaluateFilterConditions(data, old, nulls, nil) {
+		t.Fatal("changed status should pass")
+	}
+	// old 中不存在的字段使用当前值
+	if rule.EvaluateFilterConditions(data, nil, nulls, nil) {
+		t.Fatal("unchanged status should not pass")
+	}
+	// 空字符串不是 null
+	if rule.EvaluateFilterConditions(data, old, nil, nil) {
+		t.Fatal("empty remark should not be null")
+	}
+
+	// 更新前的 null 根据 oldNulls 判断
+	rule.DataFilter = "old.remark is null and remark is not null"
+	data, old = map[string]string{"remark": "paid"}, map[string]string{"remark": ""}
+	if !rule.EvaluateFilterConditions(data, old, nil, map[string]bool{"remark": true}) {
+		t.Fatal("remark updated from null should pass")
+	}
+	if rule.EvaluateFilterConditions(data, old, nil, nil) {
+		t.Fatal("remark updated from empty string should not pass")
+	}
+
+	cases := map[string]int{
+		"price >= ":                    10,
+		"price >= 5000 && (a = 1":      24,
+		"status in ('paid' 'shipped')": 19,
+		"name like 'a":                 11,
+		"price # 1":                    7,
+	}
+	for filter, pos := range cases {
+		_, err := compileFilterExpr(filter)
+		if syntaxErr, ok := err.(*FilterSyntaxError); !ok || syntaxErr.Pos != pos {
+			t.Fatalf("%q syntax error position should be %d: %v", filter, pos, err)
+		}
+	}
+}
+
+func TestSyncRule_Validate(t *testing.T) {
+	newRule := func() *SyncRule {
+		return &SyncRule{
+			Database: "test", Table: "orders", PrimaryKey: "id", LockColumns: []string{"id"},
+			Columns: map[string]string{"id": "order_id", "sku": "sku"}, Target: "test",
+			TargetType: DataSourceElasticSearch, TargetTable: "orders", SyncType: SyncTypeCopy,
+		}
+	}
+
+	if err := newRule().Validate(); err != nil {
+		t.Fatalf("rule should be valid: %v", err)
+	}
+	// 加锁字段可以是同步条件引用的未映射字段
+	unmappedLockRule := newRule()
+	unmappedLockRule.LockColumns, unmappedLockRule.DataFilter = []string{"user_id"}, "old.user_id > 0"
+	if err := unmappedLockRule.Validate(); err != nil {
+		t.Fatalf("lock column referenced by data_filter should be valid: %v", err)
+	}
+	unmappedLockRule.DataFilter, unmappedLockRule.DataConditions = "", map[string][]DataFilterCondition{
+		ConditionTypeAnd: {{Children: map[string][]DataFilterCondition{
+			ConditionTypeOr: {{Column: "user_id", Operator: ConditionOperatorIsNotNull}},
+		}}},
+	}
+	if err := unmappedLockRule.Validate(); err != nil {
+		t.Fatalf("lock column referenced by data_conditions should be valid: %v", err)
+	}
+
+	cases := []struct {
+		field  string
+		modify func(sr *SyncRule)
+	}{
+		{"sync_type", func(sr *SyncRule) { sr.SyncType = "merge" }},
+		{"join_field_name", func(sr *SyncRule) { sr.SyncType, sr.JoinFieldName = SyncTypeInner, "skus" }},
+		{"lock_columns", func(sr *SyncRule) { sr.LockColumns = []string{"id", "id"} }},
+		{"lock_columns", func(sr *SyncRule) { sr.LockColumns = []string{""} }},
+		{"lock_columns", func(sr *SyncRule) { sr.LockColumns = []string{"user_id"} }},
+		{"primary_key", func(sr *SyncRule) { sr.PrimaryKey = "order_sn" }},
+		{"sync_type", func(sr *SyncRule) {
+			sr.TargetType, sr.SyncType, sr.JoinFieldName = DataSourceMysql, SyncTypeJoin, "detail"
+		}},
+		{"data_conditions", func(sr *SyncRule) {
+			sr.DataConditions = map[string][]DataFilterCondition{"xor": {{Column: "id"}}}
+		}},
+		{"no_rows_affected", func(sr *SyncRule) { sr.TargetType, sr.NoRowsAffected = DataSourceMysql, "skip" }},
+		{"target_type", func(sr *SyncRule) { sr.UpsertOnInsert = true }},
+		{"data_filter", func(sr *SyncRule) { sr.DataFilter = "price >" }},
+		{"transforms.order_no[0]", func(sr *SyncRule) {
+			sr.Transforms = map[string][]ColumnTransform{"order_no": {{Type: TransformHash}}}
+		}},
+		{"transforms.sku[0].to", func(sr *SyncRule) {
+			sr.Transforms = map[string][]ColumnTransform{"sku": {{Type: TransformCast, To: "float"}}}
+		}},
+		{"data_conditions.and[0].value", func(sr *SyncRule) {
+			sr.DataConditions = map[string][]DataFilterCondition{
+				ConditionTypeAnd: {{Column: "id", Operator: ">", Value: "abc", Type: ConditionValueTypeInt}},
+			}
+		}},
+		{"data_conditions.and[0].values", func(sr *SyncRule) {
+			sr.DataConditions = map[string][]DataFilterCondition{
+				ConditionTypeAnd: {{Column: "id", Operator: ConditionOperatorBetween, Values: []string{"1"}}},
+			}
+		}},
+		{"version_column", func(sr *SyncRule) {
+			sr.VersionColumn, sr.SyncType, sr.JoinFieldName = "version", SyncTypeJoin, "detail"
+		}},
+		{"table", func(sr *SyncRule) { sr.Table = `/orders_(\d+/` }},
+		{"target_table", func(sr *SyncRule) { sr.Table, sr.TargetTable = "orders_*", "orders_$2" }},
+	}
+
+	for _, c := range cases {
+		rule := newRule()
+		c.modify(rule)
+		err := rule.Validate()
+		if ruleErrors, ok := err.(RuleErrors); !ok || len(ruleErrors) != 1 || ruleErrors[0].Field != c.field {
+			t.Fatalf("%s should be invalid, err: %v", c.field, err)
+		}
+	}
+
+	invalidRule := newRule()
+	invalidRule.SyncType = ""
+	err := ValidateRules(map[string][]*SyncRule{"test_orders": {newRule(), invalidRule}})
+	if rulesErr, ok := err.(RulesError); !ok || len(rulesErr) != 1 || rulesErr["test_orders[1]"] == nil {
+		t.Fatalf("rules error should contain test_orders[1]: %v", err)
+	}
+
+	// es 的版本写入会覆盖同一文档中 join 和 inner 写入的字段
+	versionedRule, innerRule := newRule(), newRule()
+	versionedRule.VersionColumn = "version"
+	innerRule.Table, innerRule.SyncType, innerRule.JoinFieldName = "order_skus", SyncTypeInner, "skus"
+	innerRule.Columns = map[string]string{"id": "id", "sku": "skus"}
+	err = ValidateRules(map[string][]*SyncRule{"test_orders": {versionedRule}, "test_order_skus": {innerRule}})
+	if rulesErr, ok := err.(RulesError); !ok || len(rulesErr) != 1 || len(rulesErr["test_orders[0]"]) != 1 ||
+		rulesErr["test_orders[0]"][0].Field != "version_column" {
+		t.Fatalf("versioned es rule should conflict with inner rule: %v", err)
+	}
+}
+
+func TestRuleIndex_Match(t *testing.T) {
+	exact := &SyncRule{Database: "orders_db_0", Table: "orders_000", TargetTable: "orders"}
+	glob := &SyncRule{
+		Database: "orders_db_*", Table: "orders_[0-9][0-9][0-9]", TargetTable: "orders_$1",
+		TargetExtraParams: map[string]string{"shard": "$1"},
+	}
+	regex := &SyncRule{
+		Database: `/orders_db_(?P<db>\d+)/`, Table: `/orders_(\d+)/`, TargetDatabase: "db_${db}",
+		TargetTable: "orders_${2}",
+	}
+	rules := map[string][]*SyncRule{
+		exact.GetRuleKey(): {exact}, glob.GetRuleKey(): {glob}, regex.GetRuleKey(): {regex},
+	}
+	for _, rule := range []*SyncRule{exact, glob, regex} {
+		rule.PrimaryKey, rule.Columns, rule.Target, rule.TargetType = "id", map[string]string{"id": "id"},
+			"test", DataSourceElasticSearch
+		rule.SyncType = SyncTypeCopy
+	}
+	if err := ValidateRules(rules); err != nil {
+		t.Fatal(err)
+	}
+
+	index := NewRuleIndex(rules)
+	matched := index.Match("orders_db_0", "orders_000")
+	if len(matched) != 3 || matched[0] != exact {
+		t.Fatalf("exact rule should be matched first: %v", matched)
+	}
+	// 匹配规则按规则key 排序，占位符替换后的规则不修改原规则
+	if matched[1].TargetDatabase != "db_0" || matched[1].TargetTable != "orders_000" {
+		t.Fatalf("regex rule placeholders error: %+v", matched[1])
+	}
+	if matched[2].TargetTable != "orders_0" || matched[2].TargetExtraParams["shard"] != "0" ||
+		glob.TargetTable != "orders_$1" {
+		t.Fatalf("glob rule placeholders error: %+v", matched[2])
+	}
+	if again := index.Match("orders_db_0", "orders_000"); len(again) != 3 || again[1] != matched[1] {
+		t.Fatalf("matched rules should be cached: %v", again)
+	}
+
+	if matched = index.Match("orders_db_15", "orders_127"); len(matched) != 2 ||
+		matched[0].TargetTable != "orders_127" || matched[1].TargetTable != "orders_15" {
+		t.Fatalf("sharded table should match pattern rules: %v", matched)
+	}
+	if matched = index.Match("orders_db_1", "orders_1000"); len(matched) != 1 || matched[0].TargetTable != "orders_1000" {
+		t.Fatalf("glob character class should match single character: %v", matched)
+	}
+	if matched = index.Match("users_db_0", "orders_000"); len(matched) != 0 {
+		t.Fatalf("other database should not match: %v", matched)
+	}
+}
@@ -0,0 +1,325 @@
+package types
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// ColumnTransform 目标字段的一个转换步骤，多个步骤按顺序组成转换管道
+	ColumnTransform struct {
+		Type       string            `json:"type" yaml:"type"`                                   // 转换类型
+		To         string            `json:"to,omitempty" yaml:"to,omitempty"`                   // cast 目标类型 int|decimal|bool|string
+		Scale      int               `json:"scale,omitempty" yaml:"scale,omitempty"`             // cast decimal 保留的小数位数
+		FromLayout string            `json:"from_layout,omitempty" yaml:"from_layout,omitempty"` // 来源时间格式 默认 2006-01-02 15:04:05
+		Layout     string            `json:"layout,omitempty" yaml:"layout,omitempty"`           // 目标时间格式 默认 2006-01-02 15:04:05
+		Unit       string            `json:"unit,omitempty" yaml:"unit,omitempty"`               // 时间戳单位 s|ms 默认 s
+		Path       string            `json:"path,omitempty" yaml:"path,omitempty"`               // json 取值路径 格式 a.b.c 为空时格式化整个 json
+		Columns    []string          `json:"columns,omitempty" yaml:"columns,omitempty"`         // concat 拼接的来源字段
+		Separator  string            `json:"separator,omitempty" yaml:"separator,omitempty"`     // concat 分隔符
+		Mapping    map[string]string `json:"mapping,omitempty" yaml:"mapping,omitempty"`         // map 静态映射表
+		Value      string            `json:"value,omitempty" yaml:"value,omitempty"`             // default 的默认值，map 未命中时的值
+		Algorithm  string            `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`     // hash 算法 md5|sha1|sha256 默认 sha256
+		Salt       string            `json:"salt,omitempty" yaml:"salt,omitempty"`               // hash 盐
+		KeepStart  int               `json:"keep_start,omitempty" yaml:"keep_start,omitempty"`   // mask 保留开头的字符数
+		KeepEnd    int               `json:"keep_end,omitempty" yaml:"keep_end,omitempty"`       // mask 保留结尾的字符数
+		MaskChar   string            `json:"mask_char,omitempty" yaml:"mask_char,omitempty"`     // mask 替换字符 默认 *
+	}
+
+	// columnTransformer 编译后的目标字段转换管道
+	columnTransformer struct {
+		sources    []string // 计算字段依赖的来源字段，为空代表映射字段
+		steps      []transformStep
+		columnType string // 转换结果的字段类型 ColumnType*，为空代表和来源字段一致
+	}
+
+	// transformStep 单个转换步骤，null 代表当前值为 null，data 为来源数据，返回错误时保留转换前的值
+	transformStep func(value string, null bool, data map[string]string) (string, error)
+)
+
+// compileColumnTransforms 编译字段转换管道，不合法的步骤通过 addErr 返回，并且不会被编译
+func compileColumnTransforms(sr *SyncRule, addErr func(field, format string, args ...interface{})) map[string]*columnTransformer {
+	if len(sr.Transforms) == 0 {
+		return nil
+	}
+
+	transformers := make(map[string]*columnTransformer, len(sr.Transforms))
+	for target, transforms := range sr.Transforms {
+		transformer := &columnTransformer{}
+		for i, transform := range transforms {
+			field := fmt.Sprintf("transforms.%s[%d]", target, i)
+			if i == 0 && !sr.isTargetColumn(target) && target != sr.JoinFieldName {
+				// 没有来源字段的目标字段是计算字段，只能通过 concat 生成
+				if transform.Type != TransformConcat {
+					addErr(field, "%s is not a target column in columns, first transform must be %s",
+						target, TransformConcat)
+					break
+				}
+				transformer.sources = transform.Columns
+			}
+			if step := compileTransformStep(field, transform, sr, addErr); step != nil {
+				transformer.steps = append(transformer.steps, step)
+				transformer.columnType = transformColumnType(transform, transformer.columnType)
+			}
+		}
+		transformers[target] = transformer
+	}
+
+	return transformers
+}
+
+// compileTransformStep 编译单个转换步骤
+func compileTransformStep(field string, transform ColumnTransform, sr *SyncRule,
+	addErr func(field, format string, args ...interface{})) transformStep {
+	switch transform.Type {
+	case TransformCast:
+		switch transform.To {
+		case ConditionValueTypeInt, ConditionValueTypeDecimal, ConditionValueTypeString, ColumnTypeBool:
+		default:
+			addErr(field+".to", "unknown cast type %q", transform.To)
+			return nil
+		}
+		return skipNull(func(value string, _ map[string]string) (string, error) {
+			return castValue(value, transform.To, transform.Scale)
+		})
+	case TransformDateFormat:
+		fromLayout, layout := transformLayout(transform.FromLayout), transformLayout(transform.Layout)
+		return skipNull(func(value string, _ map[string]string) (string, error) {
+			t, err := time.ParseInLocation(fromLayout, value, time.Local)
+			return t.Format(layout), err
+		})
+	case TransformUnixToDatetime, TransformDatetimeToUnix:
+		if transform.Unit != "" && transform.Unit != "s" && transform.Unit != "ms" {
+			addErr(field+".unit", "unknown unit %q", transform.Unit)
+			return nil
+		}
+		if transform.Type == TransformUnixToDatetime {
+			layout := transformLayout(transform.Layout)
+			return skipNull(func(value string, _ map[string]string) (string, error) {
+				ts, err := strconv.ParseInt(value, 10, 64)
+				if transform.Unit == "ms" {
+					return time.UnixMilli(ts).Format(layout), err
+				}
+				return time.Unix(ts, 0).Format(layout), err
+			})
+		}
+		fromLayout := transformLayout(transform.FromLayout)
+		return skipNull(func(value string, _ map[string]string) (string, error) {
+			t, err := time.ParseInLocation(fromLayout, value, time.Local)
+			if transform.Unit == "ms" {
+				return strconv.FormatInt(t.UnixMilli(), 10), err
+			}
+			return strconv.FormatInt(t.Unix(), 10), err
+		})
+	case TransformJson:
+		var path []string
+		if transform.Path != "" {
+			path = strings.Split(transform.Path, ".")
+		}
+		return skipNull(func(value string, _ map[string]string) (string, error) {
+			return parseJsonValue(value, path)
+		})
+	case TransformConcat:
+		if len(transform.Columns) == 0 {
+			addErr(field+".columns", "is required when type is %s", TransformConcat)
+			return nil
+		}
+		for _, column := range transform.Columns {
+			if _, ok := sr.Columns[column]; !ok {
+				addErr(field+".columns", "%s is not a source column", column)
+			}
+		}
+		return func(_ string, _ bool, data map[string]string) (string, error) {
+			values := make([]string, len(transform.Columns))
+			for i, column := range transform.Columns {
+				values[i] = data[column]
+			}
+			return strings.Join(values, transform.Separator), nil
+		}
+	case TransformMap:
+		if len(transform.Mapping) == 0 {
+			addErr(field+".mapping", "is required when type is %s", TransformMap)
+			return nil
+		}
+		return func(value string, _ bool, _ map[string]string) (string, error) {
+			if mapped, ok := transform.Mapping[value]; ok {
+				return mapped, nil
+			} else if transform.Value != "" {
+				return transform.Value, nil
+			}
+			return value, nil
+		}
+	case TransformHash:
+		var newHash func() hash.Hash
+		switch transform.Algorithm {
+		case "md5":
+			newHash = md5.New
+		case "sha1":
+			newHash = sha1.New
+		case "", "sha256":
+			newHash = sha256.New
+		default:
+			addErr(field+".algorithm", "unknown hash algorithm %q", transform.Algorithm)
+			return nil
+		}
+		return skipNull(func(value string, _ map[string]string) (string, error) {
+			h := newHash()
+			h.Write([]byte(transform.Salt + value))
+			return hex.EncodeToString(h.Sum(nil)), nil
+		})
+	case TransformMask:
+		if transform.KeepStart < 0 || transform.KeepEnd < 0 {
+			addErr(field, "keep_start and keep_end can not be negative")
+			return nil
+		}
+		maskChar := transform.MaskChar
+		if maskChar == "" {
+			maskChar = "*"
+		}
+		return skipNull(func(value string, _ map[string]string) (string, error) {
+			return maskValue(value, transform.KeepStart, transform.KeepEnd, maskChar), nil
+		})
+	case TransformDefault:
+		return func(value string, null bool, _ map[string]string) (string, error) {
+			if null {
+				return transform.Value, nil
+			}
+			return value, nil
+		}
+	}
+
+	addErr(field+".type", "unknown transform type %q", transform.Type)
+	return nil
+}
+
+// apply 执行转换管道，单个步骤失败时保留这个步骤转换前的值
+// 值为 null 时只有转换结果不为空才不再是 null
+func (t *columnTransformer) apply(value string, null bool, data map[string]string) (string, bool) {
+	for _, step := range t.steps {
+		if result, err := step(value, null, data); err == nil {
+			value, null = result, null && result == ""
+		}
+	}
+
+	return value, null
+}
+
+// isUpdated 计算字段依赖的任意一个来源字段被更新时需要重新计算
+func (t *columnTransformer) isUpdated(updatedColumns []string) bool {
+	for _, source := range t.sources {
+		for _, column := range updatedColumns {
+			if source == column {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// transformColumnType 获取转换步骤结果的字段类型，default 不改变字段类型
+func transformColumnType(transform ColumnTransform, columnType string) string {
+	switch transform.Type {
+	case TransformDefault:
+		return columnType
+	case TransformCast:
+		return transform.To
+	case TransformDatetimeToUnix:
+		return ColumnTypeInt
+	case TransformDateFormat, TransformUnixToDatetime:
+		return ColumnTypeTime
+	case TransformJson:
+		if transform.Path == "" {
+			return ColumnTypeJson
+		}
+	}
+
+	return ColumnTypeString
+}
+
+// skipNull 空值 (null 或空字符串) 不执行转换，保持原值
+func skipNull(step func(value string, data map[string]string) (string, error)) transformStep {
+	return func(value string, _ bool, data map[string]string) (string, error) {
+		if value == "" {
+			return value, nil
+		}
+		return step(value, data)
+	}
+}
+
+// transformLayout 获取时间格式，默认 TimestampLayout
+func transformLayout(layout string) string {
+	if layout == "" {
+		return TimestampLayout
+	}
+
+	return layout
+}
+
+// castValue 转换值的类型，int 直接截断小数部分
+func castValue(value, to string, scale int) (string, error) {
+	switch to {
+	case ConditionValueTypeInt, ConditionValueTypeDecimal:
+		rat, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+		if !ok {
+			return value, strconv.ErrSyntax
+		}
+		if to == ConditionValueTypeInt {
+			return new(big.Int).Quo(rat.Num(), rat.Denom()).String(), nil
+		}
+		return rat.FloatString(scale), nil
+	case ColumnTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		return strconv.FormatBool(b), err
+	}
+
+	return value, nil
+}
+
+// parseJsonValue 解析 json 文本，path 为空时返回压缩后的 json，取到的值为字符串时直接返回字符串
+func parseJsonValue(value string, path []string) (string, error) {
+	// 数字按原文保留，超过 float64 精度的整数不能被截断
+	var parsed interface{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsed); err != nil {
+		return value, err
+	}
+	for _, key := range path {
+		object, ok := parsed.(map[string]interface{})
+		if !ok {
+			return "", nil
+		}
+		parsed = object[key]
+	}
+
+	switch v := parsed.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	bytes, err := json.Marshal(parsed)
+
+	return string(bytes), err
+}
+
+// maskValue 保留开头和结尾的字符，中间的字符替换为 maskChar
+func maskValue(value string, keepStart, keepEnd int, maskChar string) string {
+	runes := []rune(value)
+	if keepStart+keepEnd >= len(runes) {
+		return value
+	}
+
+	return string(runes[:keepStart]) + strings.Repeat(maskChar, len(runes)-keepStart-keepEnd) +
+		string(runes[len(runes)-keepEnd:])
+}
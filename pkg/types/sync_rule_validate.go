@@ -0,0 +1,245 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type (
+	// RuleError 同步规则单个字段的校验错误
+	RuleError struct {
+		Field   string `json:"field"`   // 规则字段 yaml 名称
+		Message string `json:"message"` // 错误描述
+	}
+
+	// RuleErrors 单条同步规则的全部校验错误
+	RuleErrors []RuleError
+
+	// RulesError 批量同步规则校验错误 key 格式为: 规则key[规则下标]
+	RulesError map[string]RuleErrors
+)
+
+func (e RuleError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func (e RuleErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, ruleErr := range e {
+		messages = append(messages, ruleErr.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func (e RulesError) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("%s: %s", key, e[key].Error()))
+	}
+
+	return "invalid sync rules: " + strings.Join(messages, ", ")
+}
+
+// ValidateRules 校验全部同步规则，任意一条规则不合法都返回 RulesError
+func ValidateRules(rules map[string][]*SyncRule) error {
+	rulesErr := make(RulesError)
+	for ruleKey, ruleArr := range rules {
+		for i, rule := range ruleArr {
+			if rule == nil {
+				rulesErr[fmt.Sprintf("%s[%d]", ruleKey, i)] = RuleErrors{{Field: "rule", Message: "is null"}}
+			} else if err := rule.Validate(); err != nil {
+				rulesErr[fmt.Sprintf("%s[%d]", ruleKey, i)] = err.(RuleErrors)
+			}
+		}
+	}
+
+	validateEsVersionedTargets(rules, rulesErr)
+	if len(rulesErr) > 0 {
+		return rulesErr
+	}
+
+	return nil
+}
+
+// validateEsVersionedTargets es 的版本写入使用 index 替换完整文档
+// 同一个目标索引有 join 或 inner 规则时，关联写入的字段会在每次版本写入时被清除，所以不允许同时配置
+func validateEsVersionedTargets(rules map[string][]*SyncRule, rulesErr RulesError) {
+	embedded := make(map[string]string)
+	for ruleKey, ruleArr := range rules {
+		for i, rule := range ruleArr {
+			if rule != nil && rule.TargetType == DataSourceElasticSearch && rule.SyncType != SyncTypeCopy {
+				embedded[rule.Target+"."+rule.TargetTable] = fmt.Sprintf("%s[%d]", ruleKey, i)
+			}
+		}
+	}
+
+	for ruleKey, ruleArr := range rules {
+		for i, rule := range ruleArr {
+			if rule == nil || rule.TargetType != DataSourceElasticSearch || rule.SyncType != SyncTypeCopy ||
+				rule.VersionColumn == "" {
+				continue
+			}
+			if embeddedRule, ok := embedded[rule.Target+"."+rule.TargetTable]; ok {
+				path := fmt.Sprintf("%s[%d]", ruleKey, i)
+				rulesErr[path] = append(rulesErr[path], RuleError{Field: "version_column",
+					Message: fmt.Sprintf("versioned index replaces the whole document of %s, conflicts with rule %s",
+						rule.TargetTable, embeddedRule)})
+			}
+		}
+	}
+}
+
+// Validate 校验同步规则并编译同步条件，返回全部不合法字段 RuleErrors
+func (sr *SyncRule) Validate() error {
+	var errArr RuleErrors
+	addErr := func(field, format string, args ...interface{}) {
+		errArr = append(errArr, RuleError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if sr.Database == "" {
+		addErr("database", "is required")
+	}
+	if sr.Table == "" {
+		addErr("table", "is required")
+	}
+	if len(sr.Columns) == 0 {
+		addErr("columns", "is required")
+	}
+
+	if sr.PrimaryKey == "" {
+		addErr("primary_key", "is required")
+	} else if _, ok := sr.Columns[sr.PrimaryKey]; !ok {
+		addErr("primary_key", "%s is not mapped in columns", sr.PrimaryKey)
+	}
+
+	if sr.Target == "" {
+		addErr("target", "is required")
+	}
+	if sr.TargetType == "" {
+		addErr("target_type", "is required")
+	}
+	if sr.TargetTable == "" {
+		addErr("target_table", "is required")
+	}
+
+	switch sr.SyncType {
+	case SyncTypeCopy:
+	case SyncTypeJoin, SyncTypeInner:
+		if sr.JoinFieldName == "" {
+			addErr("join_field_name", "is required when sync_type is %s", sr.SyncType)
+		} else if sr.SyncType == SyncTypeInner && !sr.isTargetColumn(sr.JoinFieldName) {
+			addErr("join_field_name", "%s is not a target column in columns", sr.JoinFieldName)
+		}
+		if sr.TargetType == DataSourceMysql || sr.TargetType == DataSourceClickHouse {
+			addErr("sync_type", "%s target only support %s", sr.TargetType, SyncTypeCopy)
+		}
+	default:
+		addErr("sync_type", "unknown sync type %q", sr.SyncType)
+	}
+
+	switch sr.NoRowsAffected {
+	case "", NoRowsAffectedIgnore, NoRowsAffectedError, NoRowsAffectedInsert:
+	default:
+		addErr("no_rows_affected", "unknown policy %q", sr.NoRowsAffected)
+	}
+	if (sr.UpsertOnInsert || sr.NoRowsAffected != "") && sr.TargetType != DataSourceMysql {
+		addErr("target_type", "upsert_on_insert and no_rows_affected only support %s target", DataSourceMysql)
+	}
+	if sr.VersionColumn != "" {
+		if sr.TargetType != DataSourceMysql && sr.TargetType != DataSourceElasticSearch {
+			addErr("version_column", "only support %s and %s target", DataSourceMysql, DataSourceElasticSearch)
+		} else if sr.SyncType != SyncTypeCopy {
+			addErr("version_column", "only support sync_type %s", SyncTypeCopy)
+		}
+	}
+	if sr.AutoUpdatedAt && sr.SyncType != SyncTypeCopy {
+		addErr("auto_updated_at", "only support sync_type %s", SyncTypeCopy)
+	}
+
+	sr.matcher = compileTableMatcher(sr, addErr)
+	sr.transformers = compileColumnTransforms(sr, addErr)
+	sr.filter = compileFilterConditions("data_conditions", sr.DataConditions, addErr)
+	if sr.DataFilter != "" {
+		if sr.DataConditions != nil {
+			addErr("data_filter", "data_filter and data_conditions can not be set at the same time")
+		}
+		var err error
+		if sr.expr, err = compileFilterExpr(sr.DataFilter); err != nil {
+			addErr("data_filter", "%s", err)
+		}
+	}
+
+	// 加锁字段需要在同步条件编译之后校验，同步条件引用的字段也是已知的来源字段
+	sourceColumns, lockColumns := sr.sourceColumns(), make(map[string]bool, len(sr.LockColumns))
+	for _, column := range sr.LockColumns {
+		if column == "" {
+			addErr("lock_columns", "column name is empty")
+		} else if lockColumns[column] {
+			addErr("lock_columns", "%s is duplicated", column)
+		} else if !sourceColumns[column] {
+			addErr("lock_columns", "%s is not a known source column", column)
+		}
+		lockColumns[column] = true
+	}
+
+	if len(errArr) > 0 {
+		return errArr
+	}
+
+	return nil
+}
+
+// sourceColumns 规则中出现过的来源字段: 映射字段、主键、软删除字段 和 同步条件引用的字段
+// concat 引用的字段必须是映射字段，不需要单独收集
+func (sr *SyncRule) sourceColumns() map[string]bool {
+	columns := make(map[string]bool, len(sr.Columns)+2)
+	for column := range sr.Columns {
+		columns[column] = true
+	}
+	columns[sr.PrimaryKey] = true
+	if sr.SoftDeleteField != "" {
+		columns[sr.SoftDeleteField] = true
+	}
+
+	var collect func(conditions map[string][]DataFilterCondition)
+	collect = func(conditions map[string][]DataFilterCondition) {
+		for _, ruleConditions := range conditions {
+			for _, condition := range ruleConditions {
+				if condition.Column != "" {
+					columns[condition.Column] = true
+				}
+				if condition.ValueColumn != "" {
+					columns[condition.ValueColumn] = true
+				}
+				collect(condition.Children)
+			}
+		}
+	}
+	collect(sr.DataConditions)
+	if sr.expr != nil {
+		for column := range sr.expr.columns {
+			columns[column] = true
+		}
+	}
+
+	return columns
+}
+
+// isTargetColumn 判断是否为字段映射表中的目标字段
+func (sr *SyncRule) isTargetColumn(column string) bool {
+	for _, target := range sr.Columns {
+		if target == column {
+			return true
+		}
+	}
+
+	return false
+}